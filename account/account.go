@@ -33,6 +33,8 @@ type Oauth struct {
 	Provider  string    `sql:"type:varchar(32);not null"  UserInfo:"-"`
 	Picture   string    `sql:"type:varchar(128)"          UserInfo:""`
 	Enabled   bool      `sql:"default:true"               UserInfo:"-"`
+	// bcrypt of local password or sha256 of api key, empty for other providers
+	SecretHash string `sql:"type:varchar(128)" UserInfo:"-" json:"-"`
 }
 
 // Find Oauth, preload Account and Account.Ones
@@ -66,7 +68,13 @@ func (o *Oauth) Unlink(prd string) error {
 	if o.Provider == prd {
 		return ErrUnLinkSelf
 	}
-	return aservice.UnlinkOauth(o.AccountId, prd)
+	if err := aservice.UnlinkOauth(o.AccountId, prd); err != nil {
+		return err
+	}
+	if hooks.OnUnlink != nil {
+		hooks.OnUnlink(o.AccountId, prd)
+	}
+	return nil
 }
 func (o *Oauth) Info() interface{} {
 	info, err := tagjson.MarshalR(o, UserInfo)
@@ -82,6 +90,30 @@ func (o *Oauth) RawRooms() (*json.RawMessage, error) {
 	}
 	return tagjson.MarshalR(o.Account.Ones, UserRooms)
 }
+
+// RawRoomsFilter returns rooms viewed by o and matched by f
+func (o *Oauth) RawRoomsFilter(f RoomFilter) (*json.RawMessage, error) {
+	if err := o.GetOnes(); err != nil {
+		return nil, err
+	}
+	var aos AccountOnes
+	if err := o.Account.ViewsByViewer(&aos); err != nil {
+		return nil, err
+	}
+	matched := make(map[uint]bool, len(aos))
+	for i := range aos {
+		if f.Match(&aos[i]) {
+			matched[aos[i].OneId] = true
+		}
+	}
+	ones := Ones{}
+	for _, one := range o.Account.Ones {
+		if matched[one.ID] {
+			ones = append(ones, one)
+		}
+	}
+	return tagjson.MarshalR(ones, UserRooms)
+}
 func (o *Oauth) RawViewsByViewer() (*json.RawMessage, error) {
 	var aos AccountOnes
 	if err := o.Account.ViewsByViewer(&aos); err != nil {
@@ -97,23 +129,30 @@ func (o *Oauth) GetOnes() error                  { return o.Account.GetOnes() }
 func (o *Oauth) CanView(one *One) bool           { return aservice.CanView(o, one) }
 func (o *Oauth) GetProviders(ps *[]string) error { return o.Account.GetProviders(ps) }
 
+// SetEnabled finds the Oauth by id, even disabled, then sets Enabled
+func (o *Oauth) SetEnabled(id uint, enabled bool) error {
+	return aservice.SetOauthEnabled(o, id, enabled)
+}
+
 /////////////////////////////////////////
 //                Account
 /////////////////////////////////////////
 
 // tagjson: {"UserInfo":"e"}
 type Account struct {
-	ID        uint      `gorm:"primary_key"                 UserInfo:""`
-	CreatedAt time.Time `                                   UserInfo:"-"`
-	UpdatedAt time.Time `                                   UserInfo:"-"`
-	Name      string    `sql:"type:varchar(128);not null"   UserInfo:""`
-	Dsc       string    `sql:"type:varchar(128);default:''" UserInfo:""`
-	Oauths    []Oauth   `                                   UserInfo:"-"`
-	Ones      Ones      `gorm:"many2many:account_ones;"     UserInfo:"-"`
-	Enabled   bool      `sql:"default:true"                 UserInfo:"-"`
+	ID        uint       `gorm:"primary_key"                 UserInfo:""`
+	CreatedAt time.Time  `                                   UserInfo:"-"`
+	UpdatedAt time.Time  `                                   UserInfo:"-"`
+	Name      string     `sql:"type:varchar(128);not null"   UserInfo:""`
+	Dsc       string     `sql:"type:varchar(128);default:''" UserInfo:""`
+	Oauths    []Oauth    `                                   UserInfo:"-"`
+	Ones      Ones       `gorm:"many2many:account_ones;"     UserInfo:"-"`
+	Enabled   bool       `sql:"default:true"                 UserInfo:"-"`
+	DeletedAt *time.Time `sql:"index"                        UserInfo:"-"`
 }
 
-func (a *Account) GetOnes() error { return aservice.GetOnes(a) }
+func (a *Account) Find(id uint) error { return aservice.FindAccount(a, id) }
+func (a *Account) GetOnes() error     { return aservice.GetOnes(a) }
 
 // one must be non-exist record
 // a   must be from Oauth.OnLogin
@@ -124,6 +163,11 @@ func (a *Account) RegOne(o *One) error { return aservice.RegOne(a, o) }
 // a   must be from Oauth.OnLogin
 func (a *Account) ViewOne(o *One) error { return aservice.ViewOne(a, o) }
 
+// FindView finds the view even when it is closed by schedule or expired
+func (a *Account) FindView(ao *AccountOne, oneId uint) error {
+	return aservice.FindView(ao, a.ID, oneId)
+}
+
 // one must be exist record
 // a   must be from Oauth.OnLogin
 func (a *Account) RemoveOne(o *One) error { return aservice.RemoveOne(a, o) }
@@ -131,27 +175,50 @@ func (a *Account) RemoveOne(o *One) error { return aservice.RemoveOne(a, o) }
 // a   must be from Oauth.OnLogin
 func (a *Account) GetProviders(ps *[]string) error { return aservice.AccountProviders(a, ps) }
 
-func (a *Account) Logoff() error { return aservice.Logoff(a) }
+// Logoff soft deletes a and its personal rooms, org rooms are kept.
+// There is no restore api, the next OnLogin by any oauth of a restores
+// them before DeleteGrace, see PurgeDeleted.
+func (a *Account) Logoff() error {
+	if err := aservice.Logoff(a); err != nil {
+		return err
+	}
+	if hooks.OnLogoff != nil {
+		hooks.OnLogoff(a.ID)
+	}
+	return nil
+}
 
 func (a *Account) ViewsByViewer(aos *AccountOnes) error { return aservice.ViewsByViewer(a, aos) }
 
+// a.ID is required only, disabled account cannot be found by Find
+func (a *Account) SetEnabled(enabled bool) error {
+	if err := aservice.SetAccountEnabled(a.ID, enabled); err != nil {
+		return err
+	}
+	a.Enabled = enabled
+	return nil
+}
+
 /////////////////////////////////////////
 //                One
 /////////////////////////////////////////
 
-// tagjson: {"UserRooms":"e"}
+// tagjson: {"UserRooms":"e","Upd":"dv"}
 type One struct {
-	ID        uint      `gorm:"primary_key"                 upd:",-o,+i"          UserRooms:""`
-	CreatedAt time.Time `                                   upd:"-"               UserRooms:""`
-	UpdatedAt time.Time `                                   upd:"-"               UserRooms:""`
-	Name      string    `sql:"type:varchar(32);not null"    upd:",-o,+i;lmax(32)" UserRooms:""`
-	Dsc       string    `sql:"type:varchar(128);default:''" upd:",-o;lmax(128)"   UserRooms:""`
-	Addr      string    `sql:"not null;type:varchar(128)"   upd:"-"               UserRooms:"-"`
-	Enabled   bool      `sql:"default:true"                 upd:",-o"             UserRooms:""`
-	Owner     Account   `                                   upd:"-"               UserRooms:"-"`
-	OwnerId   uint      `                                   upd:"-"               UserRooms:""`
-	Accounts  []Account `gorm:"many2many:account_ones;"     upd:"-"               UserRooms:"-"`
-	Ver       string    `sql:"-"                            upd:"-"               UserRooms:""`
+	ID            uint       `gorm:"primary_key"                 Upd:"-"               UserRooms:""`
+	CreatedAt     time.Time  `                                   Upd:"-"               UserRooms:""`
+	UpdatedAt     time.Time  `                                   Upd:"-"               UserRooms:""`
+	Name          string     `sql:"type:varchar(32);not null"    Upd:",-o,+i;lmax(32)" UserRooms:""`
+	Dsc           string     `sql:"type:varchar(128);default:''" Upd:",-o;lmax(128)"   UserRooms:""`
+	Addr          string     `sql:"not null;type:varchar(128)"   Upd:"-"               UserRooms:"-"`
+	Enabled       bool       `sql:"default:true"                 Upd:",-o"             UserRooms:""`
+	DisabledBySys bool       `sql:"default:false"                Upd:"-"               UserRooms:""`
+	Owner         Account    `                                   Upd:"-"               UserRooms:"-"`
+	OwnerId       uint       `                                   Upd:"-"               UserRooms:""`
+	OrgId         uint       `sql:"default:0;index"              Upd:"-"               UserRooms:""`
+	Accounts      []Account  `gorm:"many2many:account_ones;"     Upd:"-"               UserRooms:"-"`
+	Ver           string     `sql:"-"                            Upd:"-"               UserRooms:""`
+	DeletedAt     *time.Time `sql:"index"                        Upd:"-"               UserRooms:"-"`
 }
 
 // tagjson: include
@@ -160,8 +227,10 @@ type Ones []One
 func (o *One) Find(id uint) error                     { return aservice.FindOne(o, id) }
 func (o *One) FindIfOwner(id, ownerId uint) error     { return aservice.FindOneIfOwner(o, id, ownerId) }
 func (o *One) Save() error                            { return aservice.Save(o) }
+func (o *One) SetAddr(addr string) error              { return aservice.SetOneAddr(o, addr) }
 func (o *One) Viewers() error                         { return aservice.Viewers(o) }
 func (o *One) Delete() error                          { return aservice.Delete(o) }
+func (o *One) Usable() bool                           { return o.Enabled && !o.DisabledBySys }
 func (o *One) RawUserRoom() (*json.RawMessage, error) { return tagjson.MarshalR(o, UserRooms) }
 func (o *One) RawViewsByShare() (*json.RawMessage, error) {
 	var aos AccountOnes
@@ -171,20 +240,22 @@ func (o *One) RawViewsByShare() (*json.RawMessage, error) {
 	return tagjson.MarshalR(aos, ViewByShare)
 }
 
-// For mysql add:
-// CREATE TRIGGER `account_del_oauths` BEFORE DELETE ON `accounts`
-// FOR EACH ROW DELETE FROM oauths WHERE oauths.account_id =  OLD.id
 /////////////////////////////////////////
 //              AccountOne
 /////////////////////////////////////////
 
 // tagjson: {"ViewByViewer":"e","ViewByShare":"e"}
 type AccountOne struct {
-	AccountId    uint      `gorm:"primary_key" sql:"auto_increment:false" ViewByViewer:"-" ViewByShare:""`
-	OneId        uint      `gorm:"primary_key" sql:"auto_increment:false" ViewByViewer:""  ViewByShare:"-"`
-	ViewByShare  string    `                   sql:"type:varchar(128)"    ViewByViewer:"-" ViewByShare:""`
-	ViewByViewer string    `                   sql:"type:varchar(128)"    ViewByViewer:""  ViewByShare:"-"`
-	CreatedAt    time.Time `                                              ViewByViewer:""  ViewByShare:""`
+	AccountId    uint       `gorm:"primary_key" sql:"auto_increment:false" ViewByViewer:"-" ViewByShare:""`
+	OneId        uint       `gorm:"primary_key" sql:"auto_increment:false" ViewByViewer:""  ViewByShare:"-"`
+	ViewByShare  string     `                   sql:"type:varchar(128)"    ViewByViewer:"-" ViewByShare:""`
+	ViewByViewer string     `                   sql:"type:varchar(128)"    ViewByViewer:""  ViewByShare:"-"`
+	GroupId      uint       `                   sql:"default:0"            ViewByViewer:""  ViewByShare:"-"`
+	Tags         string     `                   sql:"type:varchar(255)"    ViewByViewer:""  ViewByShare:"-"`
+	Cameras      string     `                   sql:"type:varchar(1024)"   ViewByViewer:"-" ViewByShare:""`
+	Schedule     string     `                   sql:"type:varchar(1024)"   ViewByViewer:""  ViewByShare:""`
+	ExpiresAt    *time.Time `                                              ViewByViewer:""  ViewByShare:""`
+	CreatedAt    time.Time  `                                              ViewByViewer:""  ViewByShare:""`
 }
 
 // tagjson: include
@@ -2,40 +2,66 @@ package account
 
 import (
 	"errors"
+	"time"
 
 	. "github.com/empirefox/ic-server-conductor/gorm"
 )
 
 var (
-	aservice            = NewAccountService()
+	// set by SetService, calls panic before it
+	aservice            AccountService
 	AccountNotAuthedErr = errors.New("As Account is not authed")
 	ErrParamsRequired   = errors.New("As Query param required")
 )
 
+// Hooks are called after the change saved.
+// Server use them to drop live sockets.
+type Hooks struct {
+	OnLogoff func(accountId uint)
+	OnUnlink func(accountId uint, provider string)
+}
+
+var hooks Hooks
+
+func SetHooks(h Hooks) { hooks = h }
+
+// SetService must be called before any other call of the package.
+// Use NewAccountService or NewMemAccountService. The service, Hooks and
+// DeleteGrace are process wide, so a process serves one Server.
 func SetService(a AccountService) {
 	if a == nil {
-		aservice = NewAccountService()
-	} else {
-		aservice = a
+		panic("account: SetService with nil AccountService")
 	}
+	aservice = a
+	resetTouches()
 }
 
+// Service returns the service set by SetService, nil before it.
+func Service() AccountService { return aservice }
+
 func ClearTables() error {
 	return aservice.DropTables()
 }
 
+// CreateTables applies all pending migrations, data is kept.
 func CreateTables() error {
-	err := aservice.DropTables()
-	if err != nil {
-		return err
-	}
 	return aservice.CreateTables()
 }
 
+func Migrate(dryRun bool) ([]Migration, error) {
+	return aservice.Migrate(dryRun)
+}
+
+func CurrentVersion() (int, error) {
+	return aservice.SchemaVersion()
+}
+
 // Used by application
 type AccountService interface {
 	CreateTables() error
 	DropTables() error
+	Migrate(dryRun bool) ([]Migration, error)
+	SchemaVersion() (int, error)
 
 	FindOauthProviders(ops *OauthProviders) error
 	SaveOauthProvider(op *OauthProvider) error
@@ -44,9 +70,16 @@ type AccountService interface {
 	SaveOauth(o *Oauth) error
 	UnlinkOauth(accountId uint, prd string) error
 	FindOauth(o *Oauth, provider, oid string) error
+	OauthExists(provider, oid string) (bool, error)
+	AccountOauths(a *Account, os *[]Oauth) error
+	SetOauthEnabled(o *Oauth, id uint, enabled bool) error
+	SetOauthSecret(o *Oauth) error
+	DeleteOauth(o *Oauth) error
 	Valid(o *Oauth) bool
 	CanView(o *Oauth, one *One) bool
 
+	FindAccount(a *Account, id uint) error
+	SetAccountEnabled(id uint, enabled bool) error
 	GetOnes(a *Account) error
 	RegOne(a *Account, o *One) error
 	ViewOne(a *Account, o *One) error
@@ -61,70 +94,197 @@ type AccountService interface {
 	Viewers(o *One) error
 	Delete(o *One) error
 	ViewsByShare(o *One, aos *AccountOnes) error
+
+	RevokeToken(rt *RevokedToken) error
+	IsTokenRevoked(jti string) bool
+	PurgeRevokedTokens(before time.Time) error
+
+	CreateOrg(a *Account, org *Org) error
+	FindOrg(org *Org, id uint) error
+	SaveOrg(org *Org) error
+	DeleteOrg(org *Org) error
+	AccountOrgs(a *Account, ms *[]OrgMember) error
+	OrgRole(orgId, accountId uint) string
+	SaveOrgMember(m *OrgMember) error
+	RemoveOrgMember(orgId, accountId uint) error
+	SetOneOrg(o *One, orgId uint) error
+	SetOneAddr(o *One, addr string) error
+
+	AccountGroups(a *Account, gs *[]RoomGroup) error
+	FindGroup(g *RoomGroup, id, accountId uint) error
+	SaveGroup(g *RoomGroup) error
+	DeleteGroup(g *RoomGroup) error
+	UpdateView(ao *AccountOne) error
+	FindView(ao *AccountOne, accountId, oneId uint) error
+	SetViewCameras(ao *AccountOne) error
+	SetViewSchedule(ao *AccountOne) error
+
+	SaveTransfer(t *OneTransfer) error
+	FindTransfer(t *OneTransfer, id uint) error
+	AccountTransfers(a *Account, ts *[]OneTransfer) error
+	DeleteTransfer(t *OneTransfer) error
+	AcceptTransfer(t *OneTransfer) error
+
+	DeletedOnes(a *Account, ones *Ones, since time.Time) error
+	RestoreOne(o *One, id, ownerId uint, since time.Time) error
+	PurgeDeleted(before time.Time) error
+
+	SaveGuestLink(l *GuestLink) error
+	FindGuestLink(l *GuestLink, id uint) error
+	FindGuestLinkByHash(l *GuestLink, hash string) error
+	OneGuestLinks(o *One, ls *[]GuestLink) error
+	DeleteGuestLink(l *GuestLink) error
+	PurgeGuestLinks(before time.Time) error
+
+	FindTotp(t *AccountTotp, accountId uint) error
+	CreateTotp(t *AccountTotp) error
+	UpdateTotp(t *AccountTotp, prev AccountTotp) error
+	FailTotp(accountId uint, lockedUntil time.Time) error
+	DeleteTotp(accountId uint) error
+
+	FindSession(ss *Session, jti string) error
+	UpsertSession(ss *Session, columns ...string) error
+	AccountSessions(a *Account, ss *[]Session, now time.Time) error
+	DeleteSession(ss *Session) error
+	PurgeSessions(before time.Time) error
 }
 
-func NewAccountService() AccountService {
-	return accountService{}
+// db is shared by all calls, cannot be nil.
+func NewAccountService(db *DB) AccountService {
+	return accountService{db}
 }
 
-type accountService struct{}
+type accountService struct {
+	db *DB
+}
 
-func (accountService) CreateTables() error {
-	ao := &AccountOne{}
-	one := &One{}
-	oauth := &Oauth{}
-	return DB.CreateTable(ao).CreateTable(&Account{}).CreateTable(one).
-		CreateTable(oauth).CreateTable(&OauthProvider{}).
-		Model(ao).AddForeignKey("account_id", "accounts", "CASCADE", "CASCADE").
-		Model(ao).AddForeignKey("one_id", "ones", "CASCADE", "CASCADE").
-		Model(one).AddForeignKey("owner_id", "accounts", "CASCADE", "CASCADE").
-		Model(oauth).AddForeignKey("account_id", "accounts", "CASCADE", "CASCADE").Error
+func (s accountService) CreateTables() error {
+	_, err := MigrateUp(s.db, migrations, false)
+	return err
+}
+
+func (s accountService) DropTables() error {
+	return s.db.DropTableIfExists(&AccountOne{}).DropTableIfExists(&GuestLink{}).DropTableIfExists(&AccountTotp{}).DropTableIfExists(&Session{}).DropTableIfExists(&Oauth{}).DropTableIfExists(&One{}).
+		DropTableIfExists(&Account{}).DropTableIfExists(&OauthProvider{}).
+		DropTableIfExists(&RevokedToken{}).DropTableIfExists(&OrgMember{}).DropTableIfExists(&Org{}).
+		DropTableIfExists(&RoomGroup{}).DropTableIfExists(&OneTransfer{}).
+		DropTableIfExists(&SchemaVersion{}).Error
 }
 
-func (accountService) DropTables() error {
-	return DB.DropTableIfExists(&AccountOne{}).DropTableIfExists(&Oauth{}).DropTableIfExists(&One{}).
-		DropTableIfExists(&Account{}).DropTableIfExists(&OauthProvider{}).Error
+func (s accountService) Migrate(dryRun bool) ([]Migration, error) {
+	return MigrateUp(s.db, migrations, dryRun)
 }
 
-func (accountService) FindOauthProviders(ops *OauthProviders) error {
-	return DB.Find(ops).Error
+func (s accountService) SchemaVersion() (int, error) {
+	return Version(s.db)
 }
 
-func (accountService) SaveOauthProvider(op *OauthProvider) error {
-	return DB.Save(op).Error
+func (s accountService) FindOauthProviders(ops *OauthProviders) error {
+	return s.db.Find(ops).Error
 }
 
-func (accountService) AccountProviders(a *Account, ps *[]string) error {
+func (s accountService) SaveOauthProvider(op *OauthProvider) error {
+	return s.db.Save(op).Error
+}
+
+func (s accountService) AccountProviders(a *Account, ps *[]string) error {
 	w := &Oauth{AccountId: a.ID}
-	return DB.Model(w).Where(w).Pluck("provider", ps).Error
+	return s.db.Model(w).Where(w).Pluck("provider", ps).Error
 }
 
-func (accountService) Logoff(a *Account) error {
-	return DB.Unscoped().Delete(a).Error
+// soft delete, personal rooms are deleted at the same time,
+// both are restored by next login before purged.
+func (s accountService) Logoff(a *Account) error {
+	now := time.Now().Truncate(time.Second)
+	tx := s.db.Begin()
+	if err := tx.Model(a).UpdateColumn("deleted_at", now).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	err := tx.Model(&One{}).Where("owner_id = ? and org_id = 0", a.ID).UpdateColumn("deleted_at", now).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
 }
 
-func (accountService) GetOnes(a *Account) error {
+func (s accountService) restoreAccount(a *Account, id uint) error {
+	tx := s.db.Begin()
+	if err := tx.Unscoped().Where("id = ? and deleted_at is not null", id).First(a).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	err := tx.Unscoped().Model(&One{}).Where("owner_id = ? and org_id = 0 and deleted_at = ?", id, a.DeletedAt).
+		UpdateColumn("deleted_at", nil).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Unscoped().Model(a).UpdateColumn("deleted_at", nil).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	a.DeletedAt = nil
+	return tx.Commit().Error
+}
+
+func (s accountService) FindAccount(a *Account, id uint) error {
+	if id == 0 {
+		return ErrParamsRequired
+	}
+	q := s.db.Where("id = ? and enabled = ?", id, true).First(a)
+	if q.RecordNotFound() {
+		return ErrRecordNotFound
+	}
+	return q.Error
+}
+
+// a disabled account is found by id only here
+func (s accountService) SetAccountEnabled(id uint, enabled bool) error {
+	a := &Account{}
+	if q := s.db.Where("id = ?", id).First(a); q.RecordNotFound() {
+		return ErrRecordNotFound
+	} else if q.Error != nil {
+		return q.Error
+	}
+	return s.db.Model(a).UpdateColumn("enabled", enabled).Error
+}
+
+// include rooms of orgs
+func (s accountService) GetOnes(a *Account) error {
 	ones := []One{}
-	err := DB.Model(a).Association("Ones").Find(&ones).Error
-	a.Ones = ones
-	return err
+	if err := s.db.Model(a).Association("Ones").Find(&ones).Error; err != nil {
+		return err
+	}
+	orgOnes := []One{}
+	if err := s.db.Where(orgOnesOf, a.ID).Find(&orgOnes).Error; err != nil {
+		return err
+	}
+	a.Ones = mergeOnes(ones, orgOnes)
+	return nil
 }
 
-func (accountService) ViewsByViewer(a *Account, aos *AccountOnes) error {
-	return DB.Where(AccountOne{AccountId: a.ID}).Select([]string{"one_id", "view_by_viewer"}).Find(aos).Error
+// views of deleted rooms are hidden until restored
+const aliveOne = "one_id not in (select id from ones where deleted_at is not null)"
+
+func (s accountService) ViewsByViewer(a *Account, aos *AccountOnes) error {
+	return s.db.Where(AccountOne{AccountId: a.ID}).Where(aliveOne).
+		Select([]string{"one_id", "view_by_viewer", "group_id", "tags", "cameras", "schedule", "expires_at", "created_at"}).Find(aos).Error
 }
 
 // one must be non-exist record
 // a   must be from Oauth.OnLogin
-func (as accountService) RegOne(a *Account, one *One) error {
+func (s accountService) RegOne(a *Account, one *One) error {
 	one.OwnerId = a.ID
-	return as.ViewOne(a, one)
+	one.Enabled = true
+	return s.ViewOne(a, one)
 }
 
 // one must be exist record
 // a   must be from Oauth.OnLogin
-func (accountService) ViewOne(a *Account, one *One) error {
-	tx := DB.Debug().Begin()
+func (s accountService) ViewOne(a *Account, one *One) error {
+	tx := s.db.Debug().Begin()
 	if err := tx.Save(one).Error; err != nil {
 		tx.Rollback()
 		return err
@@ -139,43 +299,68 @@ func (accountService) ViewOne(a *Account, one *One) error {
 
 // one must be exist record
 // a   must be from Oauth.OnLogin
-func (accountService) RemoveOne(a *Account, one *One) error {
+func (s accountService) RemoveOne(a *Account, one *One) error {
 	if one.OwnerId == a.ID {
-		return DB.Delete(one).Error
+		return s.db.Delete(one).Error
 	}
-	return DB.Model(a).Association("Ones").Delete(one).Error
+	return s.db.Model(a).Association("Ones").Delete(one).Error
 }
 
-func (accountService) FindOne(o *One, id uint) error {
+func (s accountService) FindOne(o *One, id uint) error {
 	var w One
 	w.ID = id
-	return DB.Where(w).Preload("Owner").First(o).Error
+	q := s.db.Where(w).Preload("Owner").First(o)
+	if q.RecordNotFound() {
+		return ErrRecordNotFound
+	}
+	return q.Error
 }
 
-func (accountService) FindOneIfOwner(o *One, id, ownerId uint) error {
-	return DB.Where("id = ? and owner_id = ?", id, ownerId).Preload("Owner").First(o).Error
+// owner of personal room, or owner/admin of the org
+const managedOne = "((org_id = 0 and owner_id = ?) or org_id in " +
+	"(select org_id from org_members where account_id = ? and role in (?)))"
+
+var manageRoles = []string{OrgRoleOwner, OrgRoleAdmin}
+
+func (s accountService) FindOneIfOwner(o *One, id, ownerId uint) error {
+	q := s.db.Where("id = ? and "+managedOne, id, ownerId, ownerId, manageRoles).Preload("Owner").First(o)
+	if q.RecordNotFound() {
+		return ErrRecordNotFound
+	}
+	return q.Error
 }
 
-func (accountService) Save(o *One) error {
-	return DB.Save(o).Error
+func (s accountService) Save(o *One) error {
+	return s.db.Save(o).Error
 }
 
-func (accountService) Viewers(o *One) error {
+// include members of the org
+func (s accountService) Viewers(o *One) error {
 	viewers := []Account{}
-	err := DB.Model(o).Association("Accounts").Find(&viewers).Error
+	if err := s.db.Model(o).Association("Accounts").Find(&viewers).Error; err != nil {
+		return err
+	}
+	if o.OrgId != 0 {
+		members := []Account{}
+		err := s.db.Where("id in (select account_id from org_members where org_id = ?)", o.OrgId).Find(&members).Error
+		if err != nil {
+			return err
+		}
+		viewers = mergeAccounts(viewers, members)
+	}
 	o.Accounts = viewers
-	return err
+	return nil
 }
 
-func (accountService) Delete(o *One) error {
-	return DB.Delete(o).Error
+func (s accountService) Delete(o *One) error {
+	return s.db.Delete(o).Error
 }
 
-func (accountService) ViewsByShare(o *One, aos *AccountOnes) error {
-	return DB.Where(AccountOne{OneId: o.ID}).Select([]string{"account_id", "view_by_share"}).Find(aos).Error
+func (s accountService) ViewsByShare(o *One, aos *AccountOnes) error {
+	return s.db.Where(AccountOne{OneId: o.ID}).Select([]string{"account_id", "view_by_share", "cameras", "schedule", "expires_at"}).Find(aos).Error
 }
 
-func (accountService) OnLogin(o *Oauth, provider, oid, name, pic string) error {
+func (s accountService) OnLogin(o *Oauth, provider, oid, name, pic string) error {
 	if provider == "" || oid == "" || name == "" {
 		return ErrParamsRequired
 	}
@@ -184,36 +369,520 @@ func (accountService) OnLogin(o *Oauth, provider, oid, name, pic string) error {
 	attr := Oauth{Picture: pic}
 	attr.Account.Name = name
 	attr.Account.Enabled = true
-	return DB.Where(&w).Attrs(&attr).Preload("Account").FirstOrCreate(o).Error
+	if err := s.db.Where(&w).Attrs(&attr).Preload("Account").FirstOrCreate(o).Error; err != nil {
+		return err
+	}
+	if o.Account.ID == 0 && o.AccountId != 0 {
+		// logged off, but not purged yet
+		return s.restoreAccount(&o.Account, o.AccountId)
+	}
+	return nil
 }
 
-func (accountService) SaveOauth(o *Oauth) error {
-	return DB.Save(o).Error
+func (s accountService) SaveOauth(o *Oauth) error {
+	return s.db.Save(o).Error
 }
 
-func (accountService) UnlinkOauth(accountId uint, prd string) error {
+func (s accountService) UnlinkOauth(accountId uint, prd string) error {
 	if accountId == 0 || prd == "" {
 		return ErrParamsRequired
 	}
-	return DB.Where(&Oauth{AccountId: accountId, Provider: prd}).Delete(Oauth{}).Error
+	return s.db.Where(&Oauth{AccountId: accountId, Provider: prd}).Delete(Oauth{}).Error
 }
 
-func (accountService) FindOauth(o *Oauth, provider, oid string) error {
+func (s accountService) FindOauth(o *Oauth, provider, oid string) error {
 	if provider == "" || oid == "" {
 		return ErrParamsRequired
 	}
-	return DB.Where(&Oauth{Provider: provider, Oid: oid, Enabled: true}).
-		Preload("Account").First(o).Error
+	err := s.db.Where(&Oauth{Provider: provider, Oid: oid, Enabled: true}).Preload("Account").First(o).Error
+	if err == nil && o.Account.ID == 0 {
+		// account logged off
+		return ErrRecordNotFound
+	}
+	return err
+}
+
+// OauthExists also sees disabled oauths and logged off accounts.
+func (s accountService) OauthExists(provider, oid string) (bool, error) {
+	if provider == "" || oid == "" {
+		return false, ErrParamsRequired
+	}
+	var count int
+	err := s.db.Model(&Oauth{}).Where("provider = ? and oid = ?", provider, oid).Count(&count).Error
+	return count > 0, err
+}
+
+func (s accountService) AccountOauths(a *Account, os *[]Oauth) error {
+	return s.db.Where(&Oauth{AccountId: a.ID}).Find(os).Error
+}
+
+func (s accountService) SetOauthEnabled(o *Oauth, id uint, enabled bool) error {
+	if q := s.db.Where("id = ?", id).First(o); q.RecordNotFound() {
+		return ErrRecordNotFound
+	} else if q.Error != nil {
+		return q.Error
+	}
+	o.Enabled = enabled
+	return s.db.Model(o).UpdateColumn("enabled", enabled).Error
+}
+
+func (s accountService) SetOauthSecret(o *Oauth) error {
+	return s.db.Model(o).UpdateColumn("secret_hash", o.SecretHash).Error
+}
+
+func (s accountService) DeleteOauth(o *Oauth) error {
+	return s.db.Delete(o).Error
 }
 
-func (accountService) Valid(o *Oauth) bool { return o.Enabled && o.Account.Enabled }
+func (s accountService) Valid(o *Oauth) bool { return o.Enabled && o.Account.Enabled }
 
-func (accountService) CanView(o *Oauth, one *One) bool {
+func (s accountService) CanView(o *Oauth, one *One) bool {
 	r := &AccountOne{
 		AccountId: o.Account.ID,
 		OneId:     one.ID,
 	}
+	if s.db.Where(r).Where(aliveOne).First(r).Error == nil && r.ActiveAt(time.Now()) {
+		return true
+	}
+	return one.OrgId != 0 && s.OrgRole(one.OrgId, o.Account.ID) != ""
+}
+
+func (s accountService) RevokeToken(rt *RevokedToken) error {
+	return s.db.Save(rt).Error
+}
+
+func (s accountService) IsTokenRevoked(jti string) bool {
+	if jti == "" {
+		return false
+	}
+	var count uint
+	s.db.Model(&RevokedToken{}).Where("jti = ?", jti).Count(&count)
+	return count != 0
+}
+
+func (s accountService) PurgeRevokedTokens(before time.Time) error {
+	return s.db.Where("expires_at < ?", before).Delete(RevokedToken{}).Error
+}
+
+func (s accountService) CreateOrg(a *Account, org *Org) error {
+	tx := s.db.Begin()
+	if err := tx.Create(org).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	m := OrgMember{OrgId: org.ID, AccountId: a.ID, Role: OrgRoleOwner}
+	if err := tx.Create(&m).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	org.Members = []OrgMember{m}
+	return tx.Commit().Error
+}
+
+func (s accountService) FindOrg(org *Org, id uint) error {
+	q := s.db.Where("id = ?", id).Preload("Members").First(org)
+	if q.RecordNotFound() {
+		return ErrRecordNotFound
+	}
+	return q.Error
+}
+
+func (s accountService) SaveOrg(org *Org) error {
+	return s.db.Save(org).Error
+}
+
+// rooms go back to their owners, members are cascaded
+func (s accountService) DeleteOrg(org *Org) error {
+	tx := s.db.Begin()
+	if err := tx.Model(&One{}).Where("org_id = ?", org.ID).Update("org_id", 0).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Delete(org).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+func (s accountService) AccountOrgs(a *Account, ms *[]OrgMember) error {
+	return s.db.Where("account_id = ?", a.ID).Preload("Org").Find(ms).Error
+}
+
+// empty when not a member
+func (s accountService) OrgRole(orgId, accountId uint) string {
+	var m OrgMember
+	s.db.Where("org_id = ? and account_id = ?", orgId, accountId).First(&m)
+	return m.Role
+}
+
+func (s accountService) SaveOrgMember(m *OrgMember) error {
+	return s.db.Save(m).Error
+}
+
+func (s accountService) RemoveOrgMember(orgId, accountId uint) error {
+	return s.db.Where("org_id = ? and account_id = ?", orgId, accountId).Delete(OrgMember{}).Error
+}
+
+func (s accountService) SetOneOrg(o *One, orgId uint) error {
+	if err := s.db.Model(o).Update("org_id", orgId).Error; err != nil {
+		return err
+	}
+	o.OrgId = orgId
+	return nil
+}
+
+// SetOneAddr only saves addr, other columns may be changed since o loaded
+func (s accountService) SetOneAddr(o *One, addr string) error {
+	if err := s.db.Model(&One{}).Where("id = ?", o.ID).UpdateColumn("addr", addr).Error; err != nil {
+		return err
+	}
+	o.Addr = addr
+	return nil
+}
+
+const orgOnesOf = "org_id in (select org_id from org_members where account_id = ?)"
+
+func mergeOnes(ones, more []One) []One {
+	has := make(map[uint]bool, len(ones))
+	for _, one := range ones {
+		has[one.ID] = true
+	}
+	for _, one := range more {
+		if !has[one.ID] {
+			ones = append(ones, one)
+		}
+	}
+	return ones
+}
+
+func mergeAccounts(as, more []Account) []Account {
+	has := make(map[uint]bool, len(as))
+	for _, a := range as {
+		has[a.ID] = true
+	}
+	for _, a := range more {
+		if !has[a.ID] {
+			as = append(as, a)
+		}
+	}
+	return as
+}
+
+func (s accountService) AccountGroups(a *Account, gs *[]RoomGroup) error {
+	return s.db.Where("account_id = ?", a.ID).Order("sort, id").Find(gs).Error
+}
+
+func (s accountService) FindGroup(g *RoomGroup, id, accountId uint) error {
+	q := s.db.Where("id = ? and account_id = ?", id, accountId).First(g)
+	if q.RecordNotFound() {
+		return ErrRecordNotFound
+	}
+	return q.Error
+}
+
+func (s accountService) SaveGroup(g *RoomGroup) error {
+	return s.db.Save(g).Error
+}
+
+func (s accountService) DeleteGroup(g *RoomGroup) error {
+	tx := s.db.Begin()
+	err := tx.Model(&AccountOne{}).Where("account_id = ? and group_id = ?", g.AccountId, g.ID).
+		Update("group_id", 0).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Delete(g).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// existence is checked first, mysql reports 0 rows affected when nothing changed
+func (s accountService) UpdateView(ao *AccountOne) error {
+	if err := s.FindView(&AccountOne{}, ao.AccountId, ao.OneId); err != nil {
+		return err
+	}
+	return s.db.Model(&AccountOne{}).Where("account_id = ? and one_id = ?", ao.AccountId, ao.OneId).
+		Updates(map[string]interface{}{"group_id": ao.GroupId, "tags": ao.Tags}).Error
+}
+
+func (s accountService) FindView(ao *AccountOne, accountId, oneId uint) error {
+	q := s.db.Where("account_id = ? and one_id = ?", accountId, oneId).First(ao)
+	if q.RecordNotFound() {
+		return ErrRecordNotFound
+	}
+	return q.Error
+}
+
+func (s accountService) SetViewCameras(ao *AccountOne) error {
+	cameras := ao.Cameras
+	if err := s.FindView(ao, ao.AccountId, ao.OneId); err != nil {
+		return err
+	}
+	ao.Cameras = cameras
+	return s.db.Model(&AccountOne{}).Where("account_id = ? and one_id = ?", ao.AccountId, ao.OneId).
+		Update("cameras", cameras).Error
+}
+
+func (s accountService) SetViewSchedule(ao *AccountOne) error {
+	if err := s.FindView(&AccountOne{}, ao.AccountId, ao.OneId); err != nil {
+		return err
+	}
+	return s.db.Model(&AccountOne{}).Where("account_id = ? and one_id = ?", ao.AccountId, ao.OneId).
+		Updates(map[string]interface{}{"schedule": ao.Schedule, "expires_at": ao.ExpiresAt}).Error
+}
+
+func (s accountService) SaveTransfer(t *OneTransfer) error {
+	return s.db.Save(t).Error
+}
+
+func (s accountService) FindTransfer(t *OneTransfer, id uint) error {
+	q := s.db.Where("id = ?", id).First(t)
+	if q.RecordNotFound() {
+		return ErrRecordNotFound
+	}
+	return q.Error
+}
+
+func (s accountService) AccountTransfers(a *Account, ts *[]OneTransfer) error {
+	return s.db.Where("from_id = ? or to_id = ?", a.ID, a.ID).Find(ts).Error
+}
+
+func (s accountService) DeleteTransfer(t *OneTransfer) error {
+	return s.db.Delete(t).Error
+}
+
+// owner changes, old owner view removed, new owner view added
+func (s accountService) AcceptTransfer(t *OneTransfer) error {
+	tx := s.db.Begin()
+	to := &Account{}
+	if q := tx.Where("id = ? and enabled = ?", t.ToId, true).First(to); q.RecordNotFound() {
+		tx.Rollback()
+		return ErrRecordNotFound
+	} else if q.Error != nil {
+		tx.Rollback()
+		return q.Error
+	}
+	one := &One{}
+	if q := tx.Where("id = ?", t.OneId).First(one); q.RecordNotFound() {
+		tx.Rollback()
+		return ErrRecordNotFound
+	} else if q.Error != nil {
+		tx.Rollback()
+		return q.Error
+	}
+	if one.OwnerId != t.FromId || one.OrgId != 0 {
+		tx.Rollback()
+		return ErrTransferStale
+	}
+	err := tx.Model(one).Updates(map[string]interface{}{"owner_id": t.ToId, "org_id": 0, "addr": newOneAddr()}).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Where("account_id = ? and one_id = ?", t.FromId, t.OneId).Delete(AccountOne{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
 	var count uint
-	DB.Model(r).Where(r).Count(&count)
-	return count == 1
+	if err := tx.Model(&AccountOne{}).Where("account_id = ? and one_id = ?", t.ToId, t.OneId).Count(&count).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if count == 0 {
+		ao := &AccountOne{AccountId: to.ID, ViewByShare: to.Name, OneId: one.ID, ViewByViewer: one.Name}
+		if err := tx.Create(ao).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := tx.Where("one_id = ?", t.OneId).Delete(OneTransfer{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+func (s accountService) DeletedOnes(a *Account, ones *Ones, since time.Time) error {
+	return s.db.Unscoped().Where("deleted_at > ? and "+managedOne, since, a.ID, a.ID, manageRoles).Find(ones).Error
+}
+
+func (s accountService) RestoreOne(o *One, id, ownerId uint, since time.Time) error {
+	err := s.db.Unscoped().Where("id = ? and deleted_at > ? and "+managedOne, id, since, ownerId, ownerId, manageRoles).
+		First(o).Error
+	if err != nil {
+		return err
+	}
+	if err := s.db.Unscoped().Model(o).UpdateColumn("deleted_at", nil).Error; err != nil {
+		return err
+	}
+	o.DeletedAt = nil
+	return nil
+}
+
+// hard delete, cascades are done by database
+// org rooms are given to an heir first, or they are deleted by cascade
+func (s accountService) PurgeDeleted(before time.Time) error {
+	tx := s.db.Begin()
+	var ones []One
+	err := tx.Unscoped().Where("org_id <> 0 and owner_id in (select id from accounts where deleted_at < ?)", before).
+		Find(&ones).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, one := range ones {
+		heir := &OrgMember{}
+		for _, role := range manageRoles {
+			q := tx.Where("org_id = ? and role = ? and account_id in (select id from accounts where deleted_at is null)",
+				one.OrgId, role).Order("created_at").First(heir)
+			if q.Error == nil || !q.RecordNotFound() {
+				err = q.Error
+				break
+			}
+		}
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		// no heir, the room leaves the org and is purged with its owner
+		update := tx.Unscoped().Model(&one).UpdateColumn("org_id", 0)
+		if heir.AccountId != 0 {
+			update = tx.Unscoped().Model(&one).UpdateColumn("owner_id", heir.AccountId)
+		}
+		if err := update.Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := tx.Unscoped().Where("deleted_at < ?", before).Delete(Account{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Unscoped().Where("deleted_at < ?", before).Delete(One{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+func (s accountService) SaveGuestLink(l *GuestLink) error {
+	return s.db.Save(l).Error
+}
+
+func (s accountService) FindGuestLink(l *GuestLink, id uint) error {
+	q := s.db.Where("id = ?", id).First(l)
+	if q.RecordNotFound() {
+		return ErrRecordNotFound
+	}
+	return q.Error
+}
+
+func (s accountService) FindGuestLinkByHash(l *GuestLink, hash string) error {
+	q := s.db.Where("code_hash = ?", hash).First(l)
+	if q.RecordNotFound() {
+		return ErrRecordNotFound
+	}
+	return q.Error
+}
+
+func (s accountService) OneGuestLinks(o *One, ls *[]GuestLink) error {
+	return s.db.Where("one_id = ?", o.ID).Find(ls).Error
+}
+
+func (s accountService) DeleteGuestLink(l *GuestLink) error {
+	return s.db.Delete(l).Error
+}
+
+func (s accountService) PurgeGuestLinks(before time.Time) error {
+	return s.db.Where("expires_at < ?", before).Delete(GuestLink{}).Error
+}
+
+func (s accountService) FindTotp(t *AccountTotp, accountId uint) error {
+	q := s.db.Where("account_id = ?", accountId).First(t)
+	if q.RecordNotFound() {
+		return ErrRecordNotFound
+	}
+	return q.Error
+}
+
+// CreateTotp replaces the previous one of the account
+func (s accountService) CreateTotp(t *AccountTotp) error {
+	tx := s.db.Begin()
+	if err := tx.Where("account_id = ?", t.AccountId).Delete(AccountTotp{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Create(t).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// UpdateTotp saves t only when the saved one is still prev,
+// so a code or recovery code cannot be accepted twice.
+func (s accountService) UpdateTotp(t *AccountTotp, prev AccountTotp) error {
+	q := s.db.Model(&AccountTotp{}).
+		Where("account_id = ? and last_step = ? and recovery = ?", t.AccountId, prev.LastStep, prev.Recovery).
+		Updates(map[string]interface{}{"enabled": t.Enabled, "recovery": t.Recovery, "last_step": t.LastStep, "failures": 0})
+	if q.Error != nil {
+		return q.Error
+	}
+	if q.RowsAffected == 0 {
+		return ErrTotpChanged
+	}
+	return nil
+}
+
+// FailTotp counts a bad code, the TotpMaxFailures one locks until lockedUntil
+func (s accountService) FailTotp(accountId uint, lockedUntil time.Time) error {
+	err := s.db.Exec("UPDATE account_totps SET failures = failures + 1 WHERE account_id = ?", accountId).Error
+	if err != nil {
+		return err
+	}
+	return s.db.Model(&AccountTotp{}).Where("account_id = ? and failures >= ?", accountId, TotpMaxFailures).
+		UpdateColumns(map[string]interface{}{"failures": 0, "locked_until": lockedUntil}).Error
+}
+
+func (s accountService) DeleteTotp(accountId uint) error {
+	return s.db.Where("account_id = ?", accountId).Delete(AccountTotp{}).Error
+}
+
+func (s accountService) FindSession(ss *Session, jti string) error {
+	q := s.db.Where("jti = ?", jti).First(ss)
+	if q.RecordNotFound() {
+		return ErrRecordNotFound
+	}
+	return q.Error
+}
+
+// UpsertSession creates ss, or updates the columns of the session of the same jti
+func (s accountService) UpsertSession(ss *Session, columns ...string) error {
+	return s.db.Dialect.Upsert(&s.db.DB, &Session{}, "jti", map[string]interface{}{
+		"created_at": time.Now(),
+		"account_id": ss.AccountId,
+		"provider":   ss.Provider,
+		"jti":        ss.Jti,
+		"device":     ss.Device,
+		"user_agent": ss.UserAgent,
+		"last_seen":  ss.LastSeen,
+		"expires_at": ss.ExpiresAt,
+		"stepped_up": ss.SteppedUp,
+	}, columns...)
+}
+
+func (s accountService) AccountSessions(a *Account, ss *[]Session, now time.Time) error {
+	return s.db.Where("account_id = ? and expires_at > ?", a.ID, now).Order("last_seen desc").Find(ss).Error
+}
+
+func (s accountService) DeleteSession(ss *Session) error {
+	return s.db.Delete(ss).Error
+}
+
+func (s accountService) PurgeSessions(before time.Time) error {
+	return s.db.Where("expires_at < ?", before).Delete(Session{}).Error
 }
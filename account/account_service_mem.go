@@ -0,0 +1,1322 @@
+package account
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	. "github.com/empirefox/ic-server-conductor/gorm"
+)
+
+var (
+	ErrRecordNotFound = errors.New("As Record not found")
+	ErrDuplicated     = errors.New("As Record duplicated")
+)
+
+type viewKey struct {
+	AccountId uint
+	OneId     uint
+}
+
+type memberKey struct {
+	OrgId     uint
+	AccountId uint
+}
+
+// memService keeps the same semantics as the gorm one, including
+// cascades on Logoff/Delete. Relations are not stored, only ids.
+type memService struct {
+	mu        sync.RWMutex
+	lastId    uint
+	accounts  map[uint]Account
+	oauths    map[uint]Oauth
+	ones      map[uint]One
+	views     map[viewKey]AccountOne
+	providers map[uint]OauthProvider
+	revoked   map[string]RevokedToken
+	orgs      map[uint]Org
+	members   map[memberKey]OrgMember
+	groups    map[uint]RoomGroup
+	transfers map[uint]OneTransfer
+	guests    map[uint]GuestLink
+	totps     map[uint]AccountTotp
+	sessions  map[uint]Session
+	// soft deleted, kept until purged
+	trashAccounts map[uint]Account
+	trashOnes     map[uint]One
+}
+
+// NewMemAccountService is used when no database, data is lost on exit.
+func NewMemAccountService() AccountService {
+	s := &memService{}
+	s.reset()
+	return s
+}
+
+func (s *memService) reset() {
+	s.accounts = make(map[uint]Account)
+	s.oauths = make(map[uint]Oauth)
+	s.ones = make(map[uint]One)
+	s.views = make(map[viewKey]AccountOne)
+	s.providers = make(map[uint]OauthProvider)
+	s.revoked = make(map[string]RevokedToken)
+	s.orgs = make(map[uint]Org)
+	s.members = make(map[memberKey]OrgMember)
+	s.groups = make(map[uint]RoomGroup)
+	s.transfers = make(map[uint]OneTransfer)
+	s.guests = make(map[uint]GuestLink)
+	s.totps = make(map[uint]AccountTotp)
+	s.sessions = make(map[uint]Session)
+	s.trashAccounts = make(map[uint]Account)
+	s.trashOnes = make(map[uint]One)
+}
+
+func (s *memService) nextId() uint {
+	s.lastId++
+	return s.lastId
+}
+
+// strip relations before store
+func plainAccount(a *Account) Account {
+	p := *a
+	p.Oauths = nil
+	p.Ones = nil
+	return p
+}
+
+func plainOne(o *One) One {
+	p := *o
+	p.Owner = Account{}
+	p.Accounts = nil
+	return p
+}
+
+func plainOauth(o *Oauth) Oauth {
+	p := *o
+	p.Account = Account{}
+	return p
+}
+
+func (s *memService) CreateTables() error { return nil }
+
+// nothing to migrate, always latest
+func (s *memService) Migrate(dryRun bool) ([]Migration, error) { return nil, nil }
+func (s *memService) SchemaVersion() (int, error)              { return LatestVersion(), nil }
+
+func (s *memService) DropTables() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reset()
+	return nil
+}
+
+func (s *memService) FindOauthProviders(ops *OauthProviders) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	*ops = OauthProviders{}
+	for _, id := range s.sortedIds(len(s.providers), func(f func(uint)) {
+		for id := range s.providers {
+			f(id)
+		}
+	}) {
+		*ops = append(*ops, s.providers[id])
+	}
+	return nil
+}
+
+func (s *memService) SaveOauthProvider(op *OauthProvider) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if op.ID == 0 {
+		op.ID = s.nextId()
+	}
+	s.providers[op.ID] = *op
+	return nil
+}
+
+// keep the order of creation as database does
+func (s *memService) sortedIds(n int, each func(func(uint))) []uint {
+	ids := make(uintSlice, 0, n)
+	each(func(id uint) { ids = append(ids, id) })
+	sort.Sort(ids)
+	return ids
+}
+
+type uintSlice []uint
+
+func (p uintSlice) Len() int           { return len(p) }
+func (p uintSlice) Less(i, j int) bool { return p[i] < p[j] }
+func (p uintSlice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+func (s *memService) OnLogin(o *Oauth, provider, oid, name, pic string) error {
+	if provider == "" || oid == "" || name == "" {
+		return ErrParamsRequired
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, existed := range s.oauths {
+		if existed.Provider == provider && existed.Oid == oid && existed.Enabled {
+			*o = existed
+			if _, ok := s.trashAccounts[existed.AccountId]; ok {
+				// logged off, but not purged yet
+				s.restoreAccount(existed.AccountId)
+			}
+			o.Account = s.accounts[existed.AccountId]
+			return nil
+		}
+	}
+	now := time.Now()
+	a := Account{ID: s.nextId(), CreatedAt: now, UpdatedAt: now, Name: name, Enabled: true}
+	s.accounts[a.ID] = a
+	*o = Oauth{
+		ID:        s.nextId(),
+		CreatedAt: now,
+		UpdatedAt: now,
+		Name:      name,
+		AccountId: a.ID,
+		Oid:       oid,
+		Provider:  provider,
+		Picture:   pic,
+		Enabled:   true,
+	}
+	s.oauths[o.ID] = plainOauth(o)
+	o.Account = a
+	return nil
+}
+
+func (s *memService) SaveOauth(o *Oauth) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if o.ID == 0 {
+		o.ID = s.nextId()
+		o.CreatedAt = now
+		o.Enabled = true
+	}
+	o.UpdatedAt = now
+	s.oauths[o.ID] = plainOauth(o)
+	return nil
+}
+
+func (s *memService) UnlinkOauth(accountId uint, prd string) error {
+	if accountId == 0 || prd == "" {
+		return ErrParamsRequired
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, o := range s.oauths {
+		if o.AccountId == accountId && o.Provider == prd {
+			delete(s.oauths, id)
+		}
+	}
+	return nil
+}
+
+func (s *memService) SetOauthSecret(o *Oauth) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	existed, ok := s.oauths[o.ID]
+	if !ok {
+		return ErrRecordNotFound
+	}
+	existed.SecretHash = o.SecretHash
+	s.oauths[o.ID] = existed
+	return nil
+}
+
+func (s *memService) DeleteOauth(o *Oauth) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.oauths, o.ID)
+	return nil
+}
+
+func (s *memService) OauthExists(provider, oid string) (bool, error) {
+	if provider == "" || oid == "" {
+		return false, ErrParamsRequired
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, existed := range s.oauths {
+		if existed.Provider == provider && existed.Oid == oid {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *memService) FindOauth(o *Oauth, provider, oid string) error {
+	if provider == "" || oid == "" {
+		return ErrParamsRequired
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, existed := range s.oauths {
+		if existed.Provider == provider && existed.Oid == oid && existed.Enabled {
+			a, ok := s.accounts[existed.AccountId]
+			if !ok {
+				// account logged off
+				return ErrRecordNotFound
+			}
+			*o = existed
+			o.Account = a
+			return nil
+		}
+	}
+	return ErrRecordNotFound
+}
+
+func (s *memService) AccountOauths(a *Account, os *[]Oauth) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	*os = []Oauth{}
+	for _, id := range s.sortedIds(len(s.oauths), func(f func(uint)) {
+		for id, o := range s.oauths {
+			if o.AccountId == a.ID {
+				f(id)
+			}
+		}
+	}) {
+		*os = append(*os, s.oauths[id])
+	}
+	return nil
+}
+
+func (s *memService) SetOauthEnabled(o *Oauth, id uint, enabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	existed, ok := s.oauths[id]
+	if !ok {
+		return ErrRecordNotFound
+	}
+	existed.Enabled = enabled
+	s.oauths[id] = existed
+	*o = existed
+	return nil
+}
+
+func (s *memService) Valid(o *Oauth) bool { return o.Enabled && o.Account.Enabled }
+
+func (s *memService) CanView(o *Oauth, one *One) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if _, alive := s.ones[one.ID]; !alive {
+		return false
+	}
+	v, ok := s.views[viewKey{o.Account.ID, one.ID}]
+	return (ok && v.ActiveAt(time.Now())) || (one.OrgId != 0 && s.members[memberKey{one.OrgId, o.Account.ID}].Role != "")
+}
+
+func (s *memService) FindAccount(a *Account, id uint) error {
+	if id == 0 {
+		return ErrParamsRequired
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	existed, ok := s.accounts[id]
+	if !ok || !existed.Enabled {
+		return ErrRecordNotFound
+	}
+	*a = existed
+	return nil
+}
+
+func (s *memService) SetAccountEnabled(id uint, enabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	existed, ok := s.accounts[id]
+	if !ok {
+		return ErrRecordNotFound
+	}
+	existed.Enabled = enabled
+	s.accounts[id] = existed
+	return nil
+}
+
+func (s *memService) GetOnes(a *Account) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ones := []One{}
+	for _, id := range s.sortedIds(len(s.ones), func(f func(uint)) {
+		for k := range s.views {
+			if k.AccountId == a.ID {
+				f(k.OneId)
+			}
+		}
+		for id, one := range s.ones {
+			if _, viewed := s.views[viewKey{a.ID, id}]; !viewed && one.OrgId != 0 {
+				if _, ok := s.members[memberKey{one.OrgId, a.ID}]; ok {
+					f(id)
+				}
+			}
+		}
+	}) {
+		if one, ok := s.ones[id]; ok {
+			ones = append(ones, one)
+		}
+	}
+	a.Ones = ones
+	return nil
+}
+
+func (s *memService) RegOne(a *Account, one *One) error {
+	one.OwnerId = a.ID
+	one.Enabled = true
+	return s.ViewOne(a, one)
+}
+
+func (s *memService) ViewOne(a *Account, one *One) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if one.ID == 0 {
+		one.ID = s.nextId()
+		one.CreatedAt = now
+	}
+	k := viewKey{a.ID, one.ID}
+	if _, ok := s.views[k]; ok {
+		return ErrDuplicated
+	}
+	one.UpdatedAt = now
+	s.ones[one.ID] = plainOne(one)
+	s.views[k] = AccountOne{
+		AccountId:    a.ID,
+		OneId:        one.ID,
+		ViewByShare:  a.Name,
+		ViewByViewer: one.Name,
+		CreatedAt:    now,
+	}
+	return nil
+}
+
+func (s *memService) RemoveOne(a *Account, one *One) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if one.OwnerId == a.ID {
+		s.trashOne(one.ID, time.Now())
+		return nil
+	}
+	delete(s.views, viewKey{a.ID, one.ID})
+	return nil
+}
+
+// need lock
+func (s *memService) trashOne(id uint, at time.Time) {
+	one, ok := s.ones[id]
+	if !ok {
+		return
+	}
+	one.DeletedAt = &at
+	delete(s.ones, id)
+	s.trashOnes[id] = one
+}
+
+// need lock
+func (s *memService) deleteOne(id uint) {
+	delete(s.ones, id)
+	delete(s.trashOnes, id)
+	for k := range s.views {
+		if k.OneId == id {
+			delete(s.views, k)
+		}
+	}
+	for tid, t := range s.transfers {
+		if t.OneId == id {
+			delete(s.transfers, tid)
+		}
+	}
+	for gid, l := range s.guests {
+		if l.OneId == id {
+			delete(s.guests, gid)
+		}
+	}
+}
+
+func (s *memService) AccountProviders(a *Account, ps *[]string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, o := range s.oauths {
+		if o.AccountId == a.ID {
+			*ps = append(*ps, o.Provider)
+		}
+	}
+	return nil
+}
+
+// soft delete, personal rooms are deleted at the same time
+func (s *memService) Logoff(a *Account) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	existed, ok := s.accounts[a.ID]
+	if !ok {
+		return nil
+	}
+	now := time.Now()
+	existed.DeletedAt = &now
+	delete(s.accounts, a.ID)
+	s.trashAccounts[a.ID] = existed
+	for id, one := range s.ones {
+		if one.OwnerId == a.ID && one.OrgId == 0 {
+			s.trashOne(id, now)
+		}
+	}
+	return nil
+}
+
+// need lock
+func (s *memService) restoreAccount(id uint) {
+	a := s.trashAccounts[id]
+	for oid, one := range s.trashOnes {
+		if one.OwnerId == id && one.OrgId == 0 && one.DeletedAt.Equal(*a.DeletedAt) {
+			one.DeletedAt = nil
+			delete(s.trashOnes, oid)
+			s.ones[oid] = one
+		}
+	}
+	a.DeletedAt = nil
+	delete(s.trashAccounts, id)
+	s.accounts[id] = a
+}
+
+// need lock
+// cascade: oauths, owned ones and views
+func (s *memService) deleteAccount(id uint) {
+	delete(s.accounts, id)
+	delete(s.trashAccounts, id)
+	for oid, o := range s.oauths {
+		if o.AccountId == id {
+			delete(s.oauths, oid)
+		}
+	}
+	for oid, one := range s.ones {
+		if one.OwnerId == id {
+			s.deleteOne(oid)
+		}
+	}
+	for oid, one := range s.trashOnes {
+		if one.OwnerId == id {
+			s.deleteOne(oid)
+		}
+	}
+	for k := range s.views {
+		if k.AccountId == id {
+			delete(s.views, k)
+		}
+	}
+	for k := range s.members {
+		if k.AccountId == id {
+			delete(s.members, k)
+		}
+	}
+	for gid, g := range s.groups {
+		if g.AccountId == id {
+			delete(s.groups, gid)
+		}
+	}
+	for tid, t := range s.transfers {
+		if t.FromId == id || t.ToId == id {
+			delete(s.transfers, tid)
+		}
+	}
+	delete(s.totps, id)
+	for sid, ss := range s.sessions {
+		if ss.AccountId == id {
+			delete(s.sessions, sid)
+		}
+	}
+}
+
+func (s *memService) ViewsByViewer(a *Account, aos *AccountOnes) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	*aos = AccountOnes{}
+	for _, id := range s.sortedIds(len(s.views), func(f func(uint)) {
+		for k := range s.views {
+			if _, alive := s.ones[k.OneId]; alive && k.AccountId == a.ID {
+				f(k.OneId)
+			}
+		}
+	}) {
+		v := s.views[viewKey{a.ID, id}]
+		*aos = append(*aos, AccountOne{
+			OneId:        v.OneId,
+			ViewByViewer: v.ViewByViewer,
+			GroupId:      v.GroupId,
+			Tags:         v.Tags,
+			Cameras:      v.Cameras,
+			Schedule:     v.Schedule,
+			ExpiresAt:    v.ExpiresAt,
+			CreatedAt:    v.CreatedAt,
+		})
+	}
+	return nil
+}
+
+// need lock
+func (s *memService) findOne(o *One, id uint, match func(*One) bool) error {
+	existed, ok := s.ones[id]
+	if !ok || !match(&existed) {
+		return ErrRecordNotFound
+	}
+	*o = existed
+	o.Owner = s.accounts[existed.OwnerId]
+	return nil
+}
+
+func (s *memService) FindOne(o *One, id uint) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.findOne(o, id, func(*One) bool { return true })
+}
+
+func (s *memService) FindOneIfOwner(o *One, id, ownerId uint) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.findOne(o, id, func(one *One) bool { return s.manages(one, ownerId) })
+}
+
+func (s *memService) Save(o *One) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if o.ID == 0 {
+		o.ID = s.nextId()
+		o.CreatedAt = now
+	}
+	o.UpdatedAt = now
+	s.ones[o.ID] = plainOne(o)
+	return nil
+}
+
+func (s *memService) Viewers(o *One) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	viewers := []Account{}
+	for _, id := range s.sortedIds(len(s.views), func(f func(uint)) {
+		for k := range s.views {
+			if k.OneId == o.ID {
+				f(k.AccountId)
+			}
+		}
+		for k := range s.members {
+			if o.OrgId != 0 && k.OrgId == o.OrgId {
+				if _, viewed := s.views[viewKey{k.AccountId, o.ID}]; !viewed {
+					f(k.AccountId)
+				}
+			}
+		}
+	}) {
+		if a, ok := s.accounts[id]; ok {
+			viewers = append(viewers, a)
+		}
+	}
+	o.Accounts = viewers
+	return nil
+}
+
+func (s *memService) Delete(o *One) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.trashOne(o.ID, time.Now())
+	return nil
+}
+
+func (s *memService) ViewsByShare(o *One, aos *AccountOnes) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	*aos = AccountOnes{}
+	for _, id := range s.sortedIds(len(s.views), func(f func(uint)) {
+		for k := range s.views {
+			if k.OneId == o.ID {
+				f(k.AccountId)
+			}
+		}
+	}) {
+		v := s.views[viewKey{id, o.ID}]
+		*aos = append(*aos, AccountOne{
+			AccountId:   v.AccountId,
+			ViewByShare: v.ViewByShare,
+			Cameras:     v.Cameras,
+			Schedule:    v.Schedule,
+			ExpiresAt:   v.ExpiresAt,
+		})
+	}
+	return nil
+}
+
+func (s *memService) RevokeToken(rt *RevokedToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rt.CreatedAt = time.Now()
+	s.revoked[rt.Jti] = *rt
+	return nil
+}
+
+func (s *memService) IsTokenRevoked(jti string) bool {
+	if jti == "" {
+		return false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.revoked[jti]
+	return ok
+}
+
+func (s *memService) PurgeRevokedTokens(before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for jti, rt := range s.revoked {
+		if rt.ExpiresAt.Before(before) {
+			delete(s.revoked, jti)
+		}
+	}
+	return nil
+}
+
+func (s *memService) CreateOrg(a *Account, org *Org) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	org.ID = s.nextId()
+	org.CreatedAt = now
+	org.UpdatedAt = now
+	m := OrgMember{OrgId: org.ID, AccountId: a.ID, Role: OrgRoleOwner, CreatedAt: now}
+	s.members[memberKey{org.ID, a.ID}] = m
+	org.Members = []OrgMember{m}
+	s.orgs[org.ID] = plainOrg(org)
+	return nil
+}
+
+func plainOrg(org *Org) Org {
+	p := *org
+	p.Members = nil
+	return p
+}
+
+func (s *memService) FindOrg(org *Org, id uint) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	existed, ok := s.orgs[id]
+	if !ok {
+		return ErrRecordNotFound
+	}
+	*org = existed
+	for _, aid := range s.sortedIds(len(s.members), func(f func(uint)) {
+		for k := range s.members {
+			if k.OrgId == id {
+				f(k.AccountId)
+			}
+		}
+	}) {
+		org.Members = append(org.Members, s.members[memberKey{id, aid}])
+	}
+	return nil
+}
+
+func (s *memService) SaveOrg(org *Org) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if org.ID == 0 {
+		org.ID = s.nextId()
+		org.CreatedAt = now
+	}
+	org.UpdatedAt = now
+	s.orgs[org.ID] = plainOrg(org)
+	return nil
+}
+
+func (s *memService) DeleteOrg(org *Org) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, one := range s.ones {
+		if one.OrgId == org.ID {
+			one.OrgId = 0
+			s.ones[id] = one
+		}
+	}
+	for k := range s.members {
+		if k.OrgId == org.ID {
+			delete(s.members, k)
+		}
+	}
+	delete(s.orgs, org.ID)
+	return nil
+}
+
+func (s *memService) AccountOrgs(a *Account, ms *[]OrgMember) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	*ms = []OrgMember{}
+	for _, id := range s.sortedIds(len(s.members), func(f func(uint)) {
+		for k := range s.members {
+			if k.AccountId == a.ID {
+				f(k.OrgId)
+			}
+		}
+	}) {
+		m := s.members[memberKey{id, a.ID}]
+		org := s.orgs[id]
+		m.Org = &org
+		*ms = append(*ms, m)
+	}
+	return nil
+}
+
+func (s *memService) OrgRole(orgId, accountId uint) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.members[memberKey{orgId, accountId}].Role
+}
+
+func (s *memService) SaveOrgMember(m *OrgMember) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.orgs[m.OrgId]; !ok {
+		return ErrRecordNotFound
+	}
+	k := memberKey{m.OrgId, m.AccountId}
+	if existed, ok := s.members[k]; ok {
+		m.CreatedAt = existed.CreatedAt
+	} else {
+		m.CreatedAt = time.Now()
+	}
+	saved := *m
+	saved.Org = nil
+	s.members[k] = saved
+	return nil
+}
+
+func (s *memService) RemoveOrgMember(orgId, accountId uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.members, memberKey{orgId, accountId})
+	return nil
+}
+
+func (s *memService) SetOneOrg(o *One, orgId uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	one, ok := s.ones[o.ID]
+	if !ok {
+		return ErrRecordNotFound
+	}
+	one.OrgId = orgId
+	s.ones[o.ID] = one
+	o.OrgId = orgId
+	return nil
+}
+
+func (s *memService) SetOneAddr(o *One, addr string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	one, ok := s.ones[o.ID]
+	if !ok {
+		return ErrRecordNotFound
+	}
+	one.Addr = addr
+	s.ones[o.ID] = one
+	o.Addr = addr
+	return nil
+}
+
+func (s *memService) AccountGroups(a *Account, gs *[]RoomGroup) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	*gs = []RoomGroup{}
+	for _, id := range s.sortedIds(len(s.groups), func(f func(uint)) {
+		for id, g := range s.groups {
+			if g.AccountId == a.ID {
+				f(id)
+			}
+		}
+	}) {
+		*gs = append(*gs, s.groups[id])
+	}
+	sort.Stable(groupsBySort(*gs))
+	return nil
+}
+
+type groupsBySort []RoomGroup
+
+func (p groupsBySort) Len() int           { return len(p) }
+func (p groupsBySort) Less(i, j int) bool { return p[i].Sort < p[j].Sort }
+func (p groupsBySort) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+func (s *memService) FindGroup(g *RoomGroup, id, accountId uint) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	existed, ok := s.groups[id]
+	if !ok || existed.AccountId != accountId {
+		return ErrRecordNotFound
+	}
+	*g = existed
+	return nil
+}
+
+func (s *memService) SaveGroup(g *RoomGroup) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if g.ID == 0 {
+		g.ID = s.nextId()
+		g.CreatedAt = time.Now()
+	}
+	s.groups[g.ID] = *g
+	return nil
+}
+
+func (s *memService) DeleteGroup(g *RoomGroup) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, v := range s.views {
+		if k.AccountId == g.AccountId && v.GroupId == g.ID {
+			v.GroupId = 0
+			s.views[k] = v
+		}
+	}
+	delete(s.groups, g.ID)
+	return nil
+}
+
+func (s *memService) UpdateView(ao *AccountOne) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := viewKey{ao.AccountId, ao.OneId}
+	v, ok := s.views[k]
+	if !ok {
+		return ErrRecordNotFound
+	}
+	v.GroupId = ao.GroupId
+	v.Tags = ao.Tags
+	s.views[k] = v
+	return nil
+}
+
+func (s *memService) FindView(ao *AccountOne, accountId, oneId uint) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	v, ok := s.views[viewKey{accountId, oneId}]
+	if !ok {
+		return ErrRecordNotFound
+	}
+	*ao = v
+	return nil
+}
+
+func (s *memService) SetViewCameras(ao *AccountOne) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := viewKey{ao.AccountId, ao.OneId}
+	v, ok := s.views[k]
+	if !ok {
+		return ErrRecordNotFound
+	}
+	v.Cameras = ao.Cameras
+	s.views[k] = v
+	*ao = v
+	return nil
+}
+
+func (s *memService) SetViewSchedule(ao *AccountOne) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := viewKey{ao.AccountId, ao.OneId}
+	v, ok := s.views[k]
+	if !ok {
+		return ErrRecordNotFound
+	}
+	v.Schedule = ao.Schedule
+	v.ExpiresAt = ao.ExpiresAt
+	s.views[k] = v
+	return nil
+}
+
+func (s *memService) SaveTransfer(t *OneTransfer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t.ID == 0 {
+		t.ID = s.nextId()
+		t.CreatedAt = time.Now()
+	}
+	s.transfers[t.ID] = *t
+	return nil
+}
+
+func (s *memService) FindTransfer(t *OneTransfer, id uint) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	existed, ok := s.transfers[id]
+	if !ok {
+		return ErrRecordNotFound
+	}
+	*t = existed
+	return nil
+}
+
+func (s *memService) AccountTransfers(a *Account, ts *[]OneTransfer) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	*ts = []OneTransfer{}
+	for _, id := range s.sortedIds(len(s.transfers), func(f func(uint)) {
+		for id, t := range s.transfers {
+			if t.FromId == a.ID || t.ToId == a.ID {
+				f(id)
+			}
+		}
+	}) {
+		*ts = append(*ts, s.transfers[id])
+	}
+	return nil
+}
+
+func (s *memService) DeleteTransfer(t *OneTransfer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.transfers, t.ID)
+	return nil
+}
+
+func (s *memService) AcceptTransfer(t *OneTransfer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	to, ok := s.accounts[t.ToId]
+	if !ok || !to.Enabled {
+		return ErrRecordNotFound
+	}
+	one, ok := s.ones[t.OneId]
+	if !ok {
+		return ErrRecordNotFound
+	}
+	if one.OwnerId != t.FromId || one.OrgId != 0 {
+		return ErrTransferStale
+	}
+	one.OwnerId = t.ToId
+	one.Addr = newOneAddr()
+	s.ones[one.ID] = one
+	delete(s.views, viewKey{t.FromId, one.ID})
+	k := viewKey{t.ToId, one.ID}
+	if _, ok := s.views[k]; !ok {
+		s.views[k] = AccountOne{
+			AccountId:    to.ID,
+			OneId:        one.ID,
+			ViewByShare:  to.Name,
+			ViewByViewer: one.Name,
+			CreatedAt:    time.Now(),
+		}
+	}
+	for id, pending := range s.transfers {
+		if pending.OneId == one.ID {
+			delete(s.transfers, id)
+		}
+	}
+	return nil
+}
+
+func (s *memService) DeletedOnes(a *Account, ones *Ones, since time.Time) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	*ones = Ones{}
+	for _, id := range s.sortedIds(len(s.trashOnes), func(f func(uint)) {
+		for id, one := range s.trashOnes {
+			if one.DeletedAt.After(since) && s.manages(&one, a.ID) {
+				f(id)
+			}
+		}
+	}) {
+		*ones = append(*ones, s.trashOnes[id])
+	}
+	return nil
+}
+
+// need lock
+func (s *memService) manages(one *One, accountId uint) bool {
+	if one.OrgId == 0 {
+		return one.OwnerId == accountId
+	}
+	return CanManageOrg(s.members[memberKey{one.OrgId, accountId}].Role)
+}
+
+func (s *memService) RestoreOne(o *One, id, ownerId uint, since time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	existed, ok := s.trashOnes[id]
+	if !ok || !existed.DeletedAt.After(since) || !s.manages(&existed, ownerId) {
+		return ErrRecordNotFound
+	}
+	existed.DeletedAt = nil
+	delete(s.trashOnes, id)
+	s.ones[id] = existed
+	*o = existed
+	return nil
+}
+
+func (s *memService) PurgeDeleted(before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, a := range s.trashAccounts {
+		if a.DeletedAt.Before(before) {
+			s.giveOrgOnes(id)
+			s.deleteAccount(id)
+		}
+	}
+	for id, one := range s.trashOnes {
+		if one.DeletedAt.Before(before) {
+			s.deleteOne(id)
+		}
+	}
+	return nil
+}
+
+// need lock
+// giveOrgOnes gives org rooms of the account to the heir of the org,
+// rooms of an org without heir leave the org and are purged with the account.
+func (s *memService) giveOrgOnes(id uint) {
+	give := func(ones map[uint]One) {
+		for oid, one := range ones {
+			if one.OwnerId != id || one.OrgId == 0 {
+				continue
+			}
+			if heir := s.orgHeir(one.OrgId); heir != 0 {
+				one.OwnerId = heir
+			} else {
+				one.OrgId = 0
+			}
+			ones[oid] = one
+		}
+	}
+	give(s.ones)
+	give(s.trashOnes)
+}
+
+// need lock
+// orgHeir is the oldest owner, or the oldest admin, not logged off
+func (s *memService) orgHeir(orgId uint) uint {
+	for _, role := range manageRoles {
+		var heir *OrgMember
+		for k, m := range s.members {
+			if k.OrgId != orgId || m.Role != role {
+				continue
+			}
+			if _, off := s.trashAccounts[k.AccountId]; off {
+				continue
+			}
+			if heir == nil || m.CreatedAt.Before(heir.CreatedAt) {
+				m := m
+				heir = &m
+			}
+		}
+		if heir != nil {
+			return heir.AccountId
+		}
+	}
+	return 0
+}
+
+func (s *memService) SaveGuestLink(l *GuestLink) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if l.ID == 0 {
+		l.ID = s.nextId()
+		l.CreatedAt = time.Now()
+	}
+	s.guests[l.ID] = *l
+	return nil
+}
+
+func (s *memService) FindGuestLink(l *GuestLink, id uint) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	existed, ok := s.guests[id]
+	if !ok {
+		return ErrRecordNotFound
+	}
+	*l = existed
+	return nil
+}
+
+func (s *memService) FindGuestLinkByHash(l *GuestLink, hash string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, existed := range s.guests {
+		if existed.CodeHash == hash {
+			*l = existed
+			return nil
+		}
+	}
+	return ErrRecordNotFound
+}
+
+func (s *memService) OneGuestLinks(o *One, ls *[]GuestLink) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	*ls = []GuestLink{}
+	for _, id := range s.sortedIds(len(s.guests), func(f func(uint)) {
+		for id, l := range s.guests {
+			if l.OneId == o.ID {
+				f(id)
+			}
+		}
+	}) {
+		*ls = append(*ls, s.guests[id])
+	}
+	return nil
+}
+
+func (s *memService) DeleteGuestLink(l *GuestLink) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.guests, l.ID)
+	return nil
+}
+
+func (s *memService) PurgeGuestLinks(before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, l := range s.guests {
+		if l.ExpiresAt.Before(before) {
+			delete(s.guests, id)
+		}
+	}
+	return nil
+}
+
+func (s *memService) FindTotp(t *AccountTotp, accountId uint) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	existed, ok := s.totps[accountId]
+	if !ok {
+		return ErrRecordNotFound
+	}
+	*t = existed
+	return nil
+}
+
+func (s *memService) CreateTotp(t *AccountTotp) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t.CreatedAt = time.Now()
+	s.totps[t.AccountId] = *t
+	return nil
+}
+
+func (s *memService) UpdateTotp(t *AccountTotp, prev AccountTotp) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	existed, ok := s.totps[t.AccountId]
+	if !ok || existed.LastStep != prev.LastStep || existed.Recovery != prev.Recovery {
+		return ErrTotpChanged
+	}
+	existed.Enabled = t.Enabled
+	existed.Recovery = t.Recovery
+	existed.LastStep = t.LastStep
+	existed.Failures = 0
+	s.totps[t.AccountId] = existed
+	return nil
+}
+
+func (s *memService) FailTotp(accountId uint, lockedUntil time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	existed, ok := s.totps[accountId]
+	if !ok {
+		return nil
+	}
+	existed.Failures++
+	if existed.Failures >= TotpMaxFailures {
+		existed.Failures = 0
+		existed.LockedUntil = lockedUntil
+	}
+	s.totps[accountId] = existed
+	return nil
+}
+
+func (s *memService) DeleteTotp(accountId uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.totps, accountId)
+	return nil
+}
+
+func (s *memService) FindSession(ss *Session, jti string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, existed := range s.sessions {
+		if existed.Jti == jti {
+			*ss = existed
+			return nil
+		}
+	}
+	return ErrRecordNotFound
+}
+
+func (s *memService) UpsertSession(ss *Session, columns ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, existed := range s.sessions {
+		if existed.Jti != ss.Jti {
+			continue
+		}
+		for _, col := range columns {
+			switch col {
+			case "device":
+				existed.Device = ss.Device
+			case "user_agent":
+				existed.UserAgent = ss.UserAgent
+			case "last_seen":
+				existed.LastSeen = ss.LastSeen
+			case "stepped_up":
+				existed.SteppedUp = ss.SteppedUp
+			}
+		}
+		s.sessions[id] = existed
+		return nil
+	}
+	created := *ss
+	created.ID = s.nextId()
+	created.CreatedAt = time.Now()
+	s.sessions[created.ID] = created
+	return nil
+}
+
+// latest seen first
+func (s *memService) AccountSessions(a *Account, ss *[]Session, now time.Time) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	*ss = []Session{}
+	for _, id := range s.sortedIds(len(s.sessions), func(f func(uint)) {
+		for id, existed := range s.sessions {
+			if existed.AccountId == a.ID && existed.ExpiresAt.After(now) {
+				f(id)
+			}
+		}
+	}) {
+		*ss = append(*ss, s.sessions[id])
+	}
+	sort.Stable(sessionsBySeen(*ss))
+	return nil
+}
+
+type sessionsBySeen []Session
+
+func (p sessionsBySeen) Len() int           { return len(p) }
+func (p sessionsBySeen) Less(i, j int) bool { return p[i].LastSeen.After(p[j].LastSeen) }
+func (p sessionsBySeen) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+func (s *memService) DeleteSession(ss *Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, ss.ID)
+	return nil
+}
+
+func (s *memService) PurgeSessions(before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, ss := range s.sessions {
+		if ss.ExpiresAt.Before(before) {
+			delete(s.sessions, id)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,536 @@
+package account
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMemService(t *testing.T) {
+	Convey("memService", t, func() {
+		SetService(NewMemAccountService())
+
+		Convey("should login, link and unlink", func() {
+			o := &Oauth{}
+			So(o.OnLogin("L2m", "oid", "oname", ""), ShouldBeNil)
+			So(o.ID, ShouldNotEqual, 0)
+			So(o.Account.ID, ShouldNotEqual, 0)
+			So(o.Account.Name, ShouldEqual, "oname")
+
+			// login again
+			o1 := &Oauth{}
+			So(o1.OnLogin("L2m", "oid", "oname", ""), ShouldBeNil)
+			So(o1.ID, ShouldEqual, o.ID)
+			So(o1.Account.ID, ShouldEqual, o.Account.ID)
+
+			o2 := &Oauth{}
+			So(o2.OnLink(o, "gogogo", "goid", "goname", ""), ShouldBeNil)
+			var ps []string
+			So(o.GetProviders(&ps), ShouldBeNil)
+			So(ps, ShouldContain, "L2m")
+			So(ps, ShouldContain, "gogogo")
+
+			So(o2.Unlink("L2m"), ShouldBeNil)
+			var ps2 []string
+			So(o.GetProviders(&ps2), ShouldBeNil)
+			So(ps2, ShouldNotContain, "L2m")
+			So(o.Find("L2m", "oid"), ShouldEqual, ErrRecordNotFound)
+		})
+
+		Convey("should view and remove One", func() {
+			owner := &Oauth{}
+			So(owner.OnLogin("p", "owner", "owner", ""), ShouldBeNil)
+			viewer := &Oauth{}
+			So(viewer.OnLogin("p", "viewer", "viewer", ""), ShouldBeNil)
+
+			one := &One{Name: "room", Addr: "addr"}
+			So(owner.Account.RegOne(one), ShouldBeNil)
+			So(one.OwnerId, ShouldEqual, owner.Account.ID)
+			So(owner.CanView(one), ShouldBeTrue)
+			So(viewer.CanView(one), ShouldBeFalse)
+
+			found := &One{}
+			So(found.FindIfOwner(one.ID, viewer.Account.ID), ShouldEqual, ErrRecordNotFound)
+			So(found.FindIfOwner(one.ID, owner.Account.ID), ShouldBeNil)
+			So(found.Owner.ID, ShouldEqual, owner.Account.ID)
+
+			So(viewer.Account.ViewOne(found), ShouldBeNil)
+			So(viewer.Account.ViewOne(found), ShouldEqual, ErrDuplicated)
+			So(viewer.CanView(one), ShouldBeTrue)
+			So(viewer.GetOnes(), ShouldBeNil)
+			So(len(viewer.Account.Ones), ShouldEqual, 1)
+			So(found.Viewers(), ShouldBeNil)
+			So(len(found.Accounts), ShouldEqual, 2)
+
+			// viewer removes view only
+			So(viewer.Account.RemoveOne(found), ShouldBeNil)
+			So(viewer.CanView(one), ShouldBeFalse)
+			So(owner.CanView(one), ShouldBeTrue)
+
+			// owner removes the One with all views
+			So(viewer.Account.ViewOne(found), ShouldBeNil)
+			So(owner.Account.RemoveOne(found), ShouldBeNil)
+			So(found.Find(one.ID), ShouldEqual, ErrRecordNotFound)
+			So(viewer.CanView(one), ShouldBeFalse)
+			var aos AccountOnes
+			So(viewer.Account.ViewsByViewer(&aos), ShouldBeNil)
+			So(len(aos), ShouldEqual, 0)
+		})
+
+		Convey("should cascade on Logoff", func() {
+			owner := &Oauth{}
+			So(owner.OnLogin("p", "owner", "owner", ""), ShouldBeNil)
+			viewer := &Oauth{}
+			So(viewer.OnLogin("p", "viewer", "viewer", ""), ShouldBeNil)
+			one := &One{Name: "room", Addr: "addr"}
+			So(owner.Account.RegOne(one), ShouldBeNil)
+			So(viewer.Account.ViewOne(one), ShouldBeNil)
+
+			So(owner.Logoff(), ShouldBeNil)
+			So((&Account{}).Find(owner.Account.ID), ShouldEqual, ErrRecordNotFound)
+			So((&Oauth{}).Find("p", "owner"), ShouldEqual, ErrRecordNotFound)
+			So((&One{}).Find(one.ID), ShouldEqual, ErrRecordNotFound)
+			So(viewer.CanView(one), ShouldBeFalse)
+
+			So(PurgeDeleted(), ShouldBeNil)
+			So(aservice.PurgeDeleted(time.Now().Add(time.Minute)), ShouldBeNil)
+			again := &Oauth{}
+			So(again.OnLogin("p", "owner", "owner", ""), ShouldBeNil)
+			So(again.Account.ID, ShouldNotEqual, owner.Account.ID)
+		})
+
+		Convey("should restore and purge deleted", func() {
+			owner := &Oauth{}
+			So(owner.OnLogin("p", "owner", "owner", ""), ShouldBeNil)
+			viewer := &Oauth{}
+			So(viewer.OnLogin("p", "viewer", "viewer", ""), ShouldBeNil)
+			one := &One{Name: "room", Addr: "addr"}
+			So(owner.Account.RegOne(one), ShouldBeNil)
+			So(viewer.Account.ViewOne(one), ShouldBeNil)
+
+			So(one.Delete(), ShouldBeNil)
+			So((&One{}).Find(one.ID), ShouldEqual, ErrRecordNotFound)
+			So(viewer.CanView(one), ShouldBeFalse)
+			var ones Ones
+			So(owner.Account.DeletedOnes(&ones), ShouldBeNil)
+			So(len(ones), ShouldEqual, 1)
+			So(viewer.Account.RestoreOne(&One{}, one.ID), ShouldEqual, ErrRecordNotFound)
+			So(owner.Account.RestoreOne(&One{}, one.ID), ShouldBeNil)
+			So(viewer.CanView(one), ShouldBeTrue)
+
+			// login again restores the account and its rooms
+			So(owner.Logoff(), ShouldBeNil)
+			So((&One{}).Find(one.ID), ShouldEqual, ErrRecordNotFound)
+			again := &Oauth{}
+			So(again.OnLogin("p", "owner", "owner", ""), ShouldBeNil)
+			So(again.Account.ID, ShouldEqual, owner.Account.ID)
+			So((&One{}).Find(one.ID), ShouldBeNil)
+
+			So(one.Delete(), ShouldBeNil)
+			So(aservice.PurgeDeleted(time.Now().Add(time.Minute)), ShouldBeNil)
+			So(owner.Account.RestoreOne(&One{}, one.ID), ShouldEqual, ErrRecordNotFound)
+			var aos AccountOnes
+			So(viewer.Account.ViewsByViewer(&aos), ShouldBeNil)
+			So(len(aos), ShouldEqual, 0)
+		})
+
+		Convey("should keep org rooms when purging their creator", func() {
+			creator := &Oauth{}
+			So(creator.OnLogin("p", "creator", "creator", ""), ShouldBeNil)
+			admin := &Oauth{}
+			So(admin.OnLogin("p", "admin", "admin", ""), ShouldBeNil)
+			org := &Org{Name: "org"}
+			So(creator.Account.CreateOrg(org), ShouldBeNil)
+			one := &One{Name: "room", Addr: "addr"}
+			So(creator.Account.RegOne(one), ShouldBeNil)
+			So(one.SetOrg(org.ID), ShouldBeNil)
+			orphan := &One{Name: "orphan", Addr: "orphan-addr"}
+			So(creator.Account.RegOne(orphan), ShouldBeNil)
+			other := &Org{Name: "other"}
+			So(creator.Account.CreateOrg(other), ShouldBeNil)
+			So(orphan.SetOrg(other.ID), ShouldBeNil)
+
+			So(org.SaveMember(&OrgMember{AccountId: admin.Account.ID, Role: OrgRoleAdmin}), ShouldBeNil)
+			So(creator.Logoff(), ShouldBeNil)
+			So(aservice.PurgeDeleted(time.Now().Add(time.Minute)), ShouldBeNil)
+			// no heir in other, its room is purged with the creator
+			So((&One{}).Find(orphan.ID), ShouldEqual, ErrRecordNotFound)
+			found := &One{}
+			So(found.FindIfOwner(one.ID, admin.Account.ID), ShouldBeNil)
+			So(found.OrgId, ShouldEqual, org.ID)
+			So((&Account{}).Find(creator.Account.ID), ShouldEqual, ErrRecordNotFound)
+		})
+
+		Convey("should share org rooms with members", func() {
+			owner := &Oauth{}
+			So(owner.OnLogin("p", "owner", "owner", ""), ShouldBeNil)
+			member := &Oauth{}
+			So(member.OnLogin("p", "member", "member", ""), ShouldBeNil)
+			one := &One{Name: "room", Addr: "addr"}
+			So(owner.Account.RegOne(one), ShouldBeNil)
+
+			org := &Org{Name: "org"}
+			So(owner.Account.CreateOrg(org), ShouldBeNil)
+			So(org.Role(owner.Account.ID), ShouldEqual, OrgRoleOwner)
+			So(org.SaveMember(&OrgMember{AccountId: member.Account.ID, Role: OrgRoleMember}), ShouldBeNil)
+			So(one.SetOrg(org.ID), ShouldBeNil)
+
+			So(member.CanView(one), ShouldBeTrue)
+			So(member.GetOnes(), ShouldBeNil)
+			So(len(member.Account.Ones), ShouldEqual, 1)
+			So(one.Viewers(), ShouldBeNil)
+			So(len(one.Accounts), ShouldEqual, 2)
+			So((&One{}).FindIfOwner(one.ID, member.Account.ID), ShouldEqual, ErrRecordNotFound)
+
+			// admin can manage
+			So(org.Find(org.ID), ShouldBeNil)
+			So(org.SaveMember(&OrgMember{AccountId: member.Account.ID, Role: OrgRoleAdmin}), ShouldBeNil)
+			So((&One{}).FindIfOwner(one.ID, member.Account.ID), ShouldBeNil)
+
+			// last owner cannot leave
+			So(org.Find(org.ID), ShouldBeNil)
+			So(org.RemoveMember(owner.Account.ID), ShouldEqual, ErrLastOrgOwner)
+
+			// rooms go back to the owner
+			So(org.Delete(), ShouldBeNil)
+			So(member.CanView(one), ShouldBeFalse)
+			found := &One{}
+			So(found.FindIfOwner(one.ID, owner.Account.ID), ShouldBeNil)
+			So(found.OrgId, ShouldEqual, 0)
+		})
+
+		Convey("should group and tag views", func() {
+			owner := &Oauth{}
+			So(owner.OnLogin("p", "owner", "owner", ""), ShouldBeNil)
+			one1 := &One{Name: "room1", Addr: "addr1"}
+			So(owner.Account.RegOne(one1), ShouldBeNil)
+			one2 := &One{Name: "room2", Addr: "addr2"}
+			So(owner.Account.RegOne(one2), ShouldBeNil)
+
+			g := &RoomGroup{AccountId: owner.Account.ID, Name: "home"}
+			So(g.Save(), ShouldBeNil)
+			So(owner.Account.SetView(one1.ID, g.ID, []string{" office", "office", "a,b"}), ShouldBeNil)
+			So(owner.Account.SetView(one2.ID, 0, []string{"garden"}), ShouldBeNil)
+			So(owner.Account.SetView(one2.ID, g.ID+100, nil), ShouldEqual, ErrRecordNotFound)
+
+			var aos AccountOnes
+			So(owner.Account.ViewsByViewer(&aos), ShouldBeNil)
+			So(aos[0].GroupId, ShouldEqual, g.ID)
+			So(aos[0].Tags, ShouldEqual, "office,a b")
+
+			raw, err := owner.RawRoomsFilter(RoomFilter{Tag: "garden"})
+			So(err, ShouldBeNil)
+			So(string(*raw), ShouldContainSubstring, "room2")
+			So(string(*raw), ShouldNotContainSubstring, "room1")
+
+			So(g.Delete(), ShouldBeNil)
+			So(owner.Account.ViewsByViewer(&aos), ShouldBeNil)
+			So(aos[0].GroupId, ShouldEqual, 0)
+		})
+
+		Convey("should view by schedule", func() {
+			owner := &Oauth{}
+			So(owner.OnLogin("p", "owner", "owner", ""), ShouldBeNil)
+			viewer := &Oauth{}
+			So(viewer.OnLogin("p", "viewer", "viewer", ""), ShouldBeNil)
+			one := &One{Name: "room", Addr: "addr"}
+			So(owner.Account.RegOne(one), ShouldBeNil)
+			So(viewer.Account.ViewOne(one), ShouldBeNil)
+			So(viewer.CanView(one), ShouldBeTrue)
+
+			bad := &ViewSchedule{Windows: []ScheduleWindow{{Days: []time.Weekday{time.Monday}, From: "25:00", To: "12:00"}}}
+			So(one.SetViewSchedule(viewer.Account.ID, bad, nil), ShouldEqual, ErrBadSchedule)
+
+			// Monday 22:00 to Tuesday 06:00 in Berlin
+			night := &ViewSchedule{
+				Timezone: "Europe/Berlin",
+				Windows:  []ScheduleWindow{{Days: []time.Weekday{time.Monday}, From: "22:00", To: "06:00"}},
+			}
+			berlin, _ := time.LoadLocation("Europe/Berlin")
+			So(night.ActiveAt(time.Date(2016, 1, 4, 23, 0, 0, 0, berlin)), ShouldBeTrue)
+			So(night.ActiveAt(time.Date(2016, 1, 5, 5, 59, 0, 0, berlin)), ShouldBeTrue)
+			So(night.ActiveAt(time.Date(2016, 1, 5, 6, 0, 0, 0, berlin)), ShouldBeFalse)
+			So(night.ActiveAt(time.Date(2016, 1, 4, 21, 0, 0, 0, time.UTC)), ShouldBeTrue)
+			So(night.ActiveAt(time.Date(2016, 1, 5, 23, 0, 0, 0, berlin)), ShouldBeFalse)
+
+			expired := time.Now().Add(-time.Minute)
+			So(one.SetViewSchedule(viewer.Account.ID, nil, &expired), ShouldBeNil)
+			So(one.SetViewSchedule(owner.Account.ID, nil, &expired), ShouldEqual, ErrViewOwner)
+			_, err := one.SetViewCameras(owner.Account.ID, []string{"door"})
+			So(err, ShouldEqual, ErrViewOwner)
+			So(viewer.CanView(one), ShouldBeFalse)
+			So(owner.CanView(one), ShouldBeTrue)
+			So(viewer.Account.GetActiveOnes(), ShouldBeNil)
+			So(len(viewer.Account.Ones), ShouldEqual, 0)
+			So(one.ActiveViewers(), ShouldBeNil)
+			So(len(one.Accounts), ShouldEqual, 1)
+			So(one.Accounts[0].ID, ShouldEqual, owner.Account.ID)
+
+			So(one.SetViewSchedule(viewer.Account.ID, nil, nil), ShouldBeNil)
+			So(viewer.CanView(one), ShouldBeTrue)
+			So(viewer.Account.GetActiveOnes(), ShouldBeNil)
+			So(len(viewer.Account.Ones), ShouldEqual, 1)
+		})
+
+		Convey("should step up by totp and recovery codes", func() {
+			o := &Oauth{}
+			So(o.OnLogin("L2m", "oid", "oname", ""), ShouldBeNil)
+			a := &o.Account
+			So(a.StepUp(""), ShouldBeNil)
+
+			t, err := a.EnrollTotp()
+			So(err, ShouldBeNil)
+			So(a.TotpEnabled(), ShouldBeFalse)
+			So(a.StepUp(""), ShouldBeNil)
+			_, err = a.ConfirmTotp("000000x")
+			So(err, ShouldEqual, ErrBadTotp)
+
+			step := time.Now().Unix() / TotpPeriod
+			codes, err := a.ConfirmTotp(totpCode(t.Secret, step))
+			So(err, ShouldBeNil)
+			So(len(codes), ShouldEqual, RecoveryCodeCount)
+			So(a.TotpEnabled(), ShouldBeTrue)
+			_, err = a.EnrollTotp()
+			So(err, ShouldEqual, ErrTotpEnrolled)
+
+			So(a.StepUp(""), ShouldEqual, ErrTotpRequired)
+			// used by confirm
+			So(a.StepUp(totpCode(t.Secret, step)), ShouldEqual, ErrBadTotp)
+			So(a.StepUp(totpCode(t.Secret, step+1)), ShouldBeNil)
+			So(a.StepUp(codes[0]), ShouldBeNil)
+			So(a.StepUp(codes[0]), ShouldEqual, ErrBadTotp)
+			So(a.StepUp(codes[1]), ShouldBeNil)
+
+			So(a.DisableTotp(), ShouldBeNil)
+			So(a.StepUp(""), ShouldBeNil)
+			_, err = a.NewRecoveryCodes()
+			So(err, ShouldEqual, ErrTotpNotEnrolled)
+		})
+
+		Convey("should lock step up after too many bad codes", func() {
+			o := &Oauth{}
+			So(o.OnLogin("L2m", "oid", "oname", ""), ShouldBeNil)
+			a := &o.Account
+			t, err := a.EnrollTotp()
+			So(err, ShouldBeNil)
+			step := time.Now().Unix() / TotpPeriod
+			_, err = a.ConfirmTotp(totpCode(t.Secret, step))
+			So(err, ShouldBeNil)
+
+			for i := 0; i < TotpMaxFailures; i++ {
+				So(a.StepUp("000000"), ShouldEqual, ErrBadTotp)
+			}
+			So(a.StepUp(totpCode(t.Secret, step+1)), ShouldEqual, ErrTotpLocked)
+
+			saved := &AccountTotp{}
+			So(a.Totp(saved), ShouldBeNil)
+			saved.LockedUntil = time.Now()
+			So(aservice.CreateTotp(saved), ShouldBeNil)
+			So(a.StepUp(totpCode(t.Secret, step+1)), ShouldBeNil)
+		})
+
+		Convey("should not accept a totp code twice concurrently", func() {
+			o := &Oauth{}
+			So(o.OnLogin("L2m", "oid", "oname", ""), ShouldBeNil)
+			a := &o.Account
+			t, err := a.EnrollTotp()
+			So(err, ShouldBeNil)
+			step := time.Now().Unix() / TotpPeriod
+			_, err = a.ConfirmTotp(totpCode(t.Secret, step))
+			So(err, ShouldBeNil)
+
+			prev := &AccountTotp{}
+			So(a.Totp(prev), ShouldBeNil)
+			So(a.StepUp(totpCode(t.Secret, step+1)), ShouldBeNil)
+			stale := *prev
+			So(stale.checkCode(totpCode(t.Secret, step+1), time.Now()), ShouldBeTrue)
+			So(aservice.UpdateTotp(&stale, *prev), ShouldEqual, ErrTotpChanged)
+		})
+
+		Convey("should keep tokens pre-auth until stepped up", func() {
+			o := &Oauth{}
+			So(o.OnLogin("L2m", "oid", "oname", ""), ShouldBeNil)
+			pre, err := o.PreAuth("t1")
+			So(err, ShouldBeNil)
+			So(pre, ShouldBeFalse)
+
+			t, err := o.Account.EnrollTotp()
+			So(err, ShouldBeNil)
+			_, err = o.Account.ConfirmTotp(totpCode(t.Secret, time.Now().Unix()/TotpPeriod))
+			So(err, ShouldBeNil)
+			pre, err = o.PreAuth("t1")
+			So(err, ShouldBeNil)
+			So(pre, ShouldBeTrue)
+			So(o.SeenSession("t1", "ua", time.Time{}), ShouldBeNil)
+			pre, err = o.PreAuth("t1")
+			So(err, ShouldBeNil)
+			So(pre, ShouldBeTrue)
+
+			So(o.RecordSession("t2", "phone", "ua", time.Time{}, true), ShouldBeNil)
+			pre, err = o.PreAuth("t2")
+			So(err, ShouldBeNil)
+			So(pre, ShouldBeFalse)
+		})
+
+		Convey("should touch sessions at most once a period", func() {
+			o := &Oauth{}
+			So(o.OnLogin("L2m", "oid", "oname", ""), ShouldBeNil)
+			So(o.RecordSession("s1", "phone", "ua1", time.Time{}, false), ShouldBeNil)
+			So(o.RecordSession("s1", "tablet", "ua1", time.Time{}, true), ShouldBeNil)
+			So(o.SeenSession("s1", "ua2", time.Time{}), ShouldBeNil)
+			So(o.SeenSession("s2", "ua2", time.Time{}), ShouldBeNil)
+			So(o.SeenSession("s2", "ua3", time.Time{}), ShouldBeNil)
+
+			var ss []Session
+			So(o.Account.Sessions(&ss), ShouldBeNil)
+			So(len(ss), ShouldEqual, 2)
+			for _, s := range ss {
+				switch s.Jti {
+				case TokenJti("s1"):
+					So(s.Device, ShouldEqual, "tablet")
+					So(s.UserAgent, ShouldEqual, "ua1")
+					So(s.SteppedUp, ShouldBeTrue)
+				case TokenJti("s2"):
+					So(s.UserAgent, ShouldEqual, "ua2")
+					So(s.SteppedUp, ShouldBeFalse)
+				}
+			}
+
+			touches[TokenJti("s2")] = time.Now().Add(-SessionTouchPeriod)
+			So(o.SeenSession("s2", "ua3", time.Time{}), ShouldBeNil)
+			So(o.Account.Sessions(&ss), ShouldBeNil)
+			for _, s := range ss {
+				if s.Jti == TokenJti("s2") {
+					So(s.UserAgent, ShouldEqual, "ua3")
+				}
+			}
+		})
+
+		Convey("should record, list and revoke sessions", func() {
+			o := &Oauth{}
+			So(o.OnLogin("L2m", "oid", "oname", ""), ShouldBeNil)
+			exp := time.Now().Add(time.Hour)
+			So(o.RecordSession("t1", "phone", "ua1", exp, true), ShouldBeNil)
+			So(o.SeenSession("t2", "ua2", time.Time{}), ShouldBeNil)
+			So(o.SeenSession("t1", "ua3", exp), ShouldBeNil)
+
+			var ss []Session
+			So(o.Account.Sessions(&ss), ShouldBeNil)
+			So(len(ss), ShouldEqual, 2)
+			var t1 Session
+			for _, s := range ss {
+				if s.Jti == TokenJti("t1") {
+					t1 = s
+				}
+			}
+			So(t1.Device, ShouldEqual, "phone")
+			So(t1.UserAgent, ShouldEqual, "ua1")
+			So(t1.Provider, ShouldEqual, "L2m")
+
+			other := &Account{ID: o.AccountId + 100}
+			_, err := other.RevokeSession(t1.ID)
+			So(err, ShouldEqual, ErrRecordNotFound)
+			revoked, err := o.Account.RevokeSession(t1.ID)
+			So(err, ShouldBeNil)
+			So(revoked.Jti, ShouldEqual, TokenJti("t1"))
+			So(IsTokenRevoked(TokenJti("t1")), ShouldBeTrue)
+			So(IsTokenRevoked(TokenJti("t2")), ShouldBeFalse)
+			So(o.Account.Sessions(&ss), ShouldBeNil)
+			So(len(ss), ShouldEqual, 1)
+		})
+
+		Convey("should login by local credentials and api keys", func() {
+			_, err := NewLocalUser("alice", "short", "")
+			So(err, ShouldEqual, ErrWeakPassword)
+			o, err := NewLocalUser("alice", "password1", "")
+			So(err, ShouldBeNil)
+			So(o.Account.Name, ShouldEqual, "alice")
+			_, err = NewLocalUser("alice", "password2", "")
+			So(err, ShouldEqual, ErrUsernameTaken)
+
+			login := &Oauth{}
+			So(login.LoginLocal("alice", "wrong pass"), ShouldEqual, ErrBadCredentials)
+			So(login.LoginLocal("bob", "password1"), ShouldEqual, ErrBadCredentials)
+			So(login.LoginLocal("alice", "password1"), ShouldBeNil)
+			So(login.AccountId, ShouldEqual, o.AccountId)
+			So(login.ChangePassword("wrong pass", "password2"), ShouldEqual, ErrBadCredentials)
+			So(login.ChangePassword("password1", "password2"), ShouldBeNil)
+			So((&Oauth{}).LoginLocal("alice", "password1"), ShouldEqual, ErrBadCredentials)
+
+			k, key, err := o.Account.NewApiKey("ci")
+			So(err, ShouldBeNil)
+			So(IsApiKey(key), ShouldBeTrue)
+			So(k.SetPassword("password3"), ShouldEqual, ErrNotLocal)
+			byKey := &Oauth{}
+			So(byKey.FindByApiKey(key), ShouldBeNil)
+			So(byKey.AccountId, ShouldEqual, o.AccountId)
+			So((&Oauth{}).FindByApiKey(key+"x"), ShouldEqual, ErrBadApiKey)
+
+			var keys []Oauth
+			So(o.Account.ApiKeys(&keys), ShouldBeNil)
+			So(len(keys), ShouldEqual, 1)
+			So(o.Account.RevokeApiKey(o.ID), ShouldEqual, ErrRecordNotFound)
+			So(o.Account.RevokeApiKey(k.ID), ShouldBeNil)
+			So((&Oauth{}).FindByApiKey(key), ShouldEqual, ErrBadApiKey)
+		})
+
+		Convey("should keep local usernames taken while disabled or logged off", func() {
+			o, err := NewLocalUser("carol", "password1", "")
+			So(err, ShouldBeNil)
+			So((&Oauth{}).SetEnabled(o.ID, false), ShouldBeNil)
+			_, err = NewLocalUser("carol", "password2", "")
+			So(err, ShouldEqual, ErrUsernameTaken)
+			So(o.Logoff(), ShouldBeNil)
+			_, err = NewLocalUser("carol", "password2", "")
+			So(err, ShouldEqual, ErrUsernameTaken)
+		})
+
+		Convey("should transfer One to another account", func() {
+			owner := &Oauth{}
+			So(owner.OnLogin("p", "owner", "owner", ""), ShouldBeNil)
+			target := &Oauth{}
+			So(target.OnLogin("p", "target", "target", ""), ShouldBeNil)
+			viewer := &Oauth{}
+			So(viewer.OnLogin("p", "viewer", "viewer", ""), ShouldBeNil)
+			one := &One{Name: "room", Addr: "addr"}
+			So(owner.Account.RegOne(one), ShouldBeNil)
+			So(viewer.Account.ViewOne(one), ShouldBeNil)
+
+			_, err := owner.Account.StartTransfer(one, owner.Account.ID)
+			So(err, ShouldEqual, ErrTransferSelf)
+			_, err = target.Account.StartTransfer(one, viewer.Account.ID)
+			So(err, ShouldEqual, ErrTransferOwner)
+
+			t, err := owner.Account.StartTransfer(one, target.Account.ID)
+			So(err, ShouldBeNil)
+			_, err = viewer.Account.AcceptTransfer(t.ID)
+			So(err, ShouldEqual, ErrRecordNotFound)
+
+			_, err = target.Account.AcceptTransfer(t.ID)
+			So(err, ShouldBeNil)
+			found := &One{}
+			So(found.FindIfOwner(one.ID, target.Account.ID), ShouldBeNil)
+			// token of the old owner is invalid
+			So(found.Addr, ShouldNotEqual, "addr")
+			So(target.CanView(one), ShouldBeTrue)
+			So(viewer.CanView(one), ShouldBeTrue)
+			So(owner.CanView(one), ShouldBeFalse)
+
+			var ts []OneTransfer
+			So(target.Account.Transfers(&ts), ShouldBeNil)
+			So(len(ts), ShouldEqual, 0)
+			_, err = target.Account.AcceptTransfer(t.ID)
+			So(err, ShouldEqual, ErrRecordNotFound)
+		})
+
+		Convey("should revoke and purge tokens", func() {
+			So(RevokeToken("old", time.Now().Add(-time.Minute)), ShouldBeNil)
+			So(RevokeToken("new", time.Now().Add(time.Hour)), ShouldBeNil)
+			So(IsTokenRevoked("old"), ShouldBeTrue)
+			So(PurgeRevokedTokens(), ShouldBeNil)
+			So(IsTokenRevoked("old"), ShouldBeFalse)
+			So(IsTokenRevoked("new"), ShouldBeTrue)
+		})
+	})
+}
@@ -6,6 +6,8 @@
 package account
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	fflib "github.com/pquerna/ffjson/fflib/v1"
 )
@@ -110,6 +112,35 @@ func (mj *AccountOne) MarshalTagJSON_ViewByViewer(buf fflib.EncodingBuffer) erro
 		fflib.WriteJsonString(buf, string(mj.ViewByViewer))
 		buf.WriteByte(',')
 	}
+	if mj.GroupId != 0 {
+		buf.WriteString(`"GroupId":`)
+		fflib.FormatBits2(buf, uint64(mj.GroupId), 10, false)
+		buf.WriteByte(',')
+	}
+	if len(mj.Tags) != 0 {
+		buf.WriteString(`"Tags":`)
+		fflib.WriteJsonString(buf, string(mj.Tags))
+		buf.WriteByte(',')
+	}
+	if len(mj.Schedule) != 0 {
+		buf.WriteString(`"Schedule":`)
+		fflib.WriteJsonString(buf, string(mj.Schedule))
+		buf.WriteByte(',')
+	}
+	if mj.ExpiresAt != nil {
+		buf.WriteString(`"ExpiresAt":`)
+
+		{
+
+			obj, err = mj.ExpiresAt.MarshalJSON()
+			if err != nil {
+				return err
+			}
+			buf.Write(obj)
+
+		}
+		buf.WriteByte(',')
+	}
 	if true {
 		buf.WriteString(`"CreatedAt":`)
 
@@ -149,6 +180,30 @@ func (mj *AccountOne) MarshalTagJSON_ViewByShare(buf fflib.EncodingBuffer) error
 		fflib.WriteJsonString(buf, string(mj.ViewByShare))
 		buf.WriteByte(',')
 	}
+	if len(mj.Cameras) != 0 {
+		buf.WriteString(`"Cameras":`)
+		fflib.WriteJsonString(buf, string(mj.Cameras))
+		buf.WriteByte(',')
+	}
+	if len(mj.Schedule) != 0 {
+		buf.WriteString(`"Schedule":`)
+		fflib.WriteJsonString(buf, string(mj.Schedule))
+		buf.WriteByte(',')
+	}
+	if mj.ExpiresAt != nil {
+		buf.WriteString(`"ExpiresAt":`)
+
+		{
+
+			obj, err = mj.ExpiresAt.MarshalJSON()
+			if err != nil {
+				return err
+			}
+			buf.Write(obj)
+
+		}
+		buf.WriteByte(',')
+	}
 	if true {
 		buf.WriteString(`"CreatedAt":`)
 
@@ -232,6 +287,51 @@ func (mj *Oauth) MarshalTagJSON_UserInfo(buf fflib.EncodingBuffer) error {
 	return nil
 }
 
+func (vj *One) ValidateTag(t int) (map[string][]string, bool) {
+	switch t {
+
+	case Upd:
+		return vj.ValidateTag_Upd()
+
+	}
+	return map[string][]string{"invalid_validation_tag": {"Validate tag not found"}}, false
+}
+
+func (vj *One) ValidateTag_Upd() (map[string][]string, bool) {
+	errs := make(map[string][]string, 0)
+
+	errs_Name := make([]string, 0)
+	if length := len(vj.Name); length != 0 {
+
+		if length > 32 {
+			errs_Name = append(errs_Name, "lmax(32)")
+		}
+
+	} else {
+		errs_Name = append(errs_Name, "required")
+	}
+	if len(errs_Name) != 0 {
+		errs["Name"] = errs_Name
+	}
+
+	errs_Dsc := make([]string, 0)
+	if length := len(vj.Dsc); length != 0 {
+
+		if length > 128 {
+			errs_Dsc = append(errs_Dsc, "lmax(128)")
+		}
+
+	}
+	if len(errs_Dsc) != 0 {
+		errs["Dsc"] = errs_Dsc
+	}
+
+	if len(errs) != 0 {
+		return errs, false
+	}
+	return nil, true
+}
+
 // Must be []One or []*One
 func (ms Ones) MarshalTagJSON(t int) ([]byte, error) {
 	var buf fflib.Buffer
@@ -324,11 +424,24 @@ func (mj *One) MarshalTagJSON_UserRooms(buf fflib.EncodingBuffer) error {
 		}
 		buf.WriteByte(',')
 	}
+	if mj.DisabledBySys != false {
+		if mj.DisabledBySys {
+			buf.WriteString(`"DisabledBySys":true`)
+		} else {
+			buf.WriteString(`"DisabledBySys":false`)
+		}
+		buf.WriteByte(',')
+	}
 	if mj.OwnerId != 0 {
 		buf.WriteString(`"OwnerId":`)
 		fflib.FormatBits2(buf, uint64(mj.OwnerId), 10, false)
 		buf.WriteByte(',')
 	}
+	if mj.OrgId != 0 {
+		buf.WriteString(`"OrgId":`)
+		fflib.FormatBits2(buf, uint64(mj.OrgId), 10, false)
+		buf.WriteByte(',')
+	}
 	if len(mj.Ver) != 0 {
 		buf.WriteString(`"Ver":`)
 		fflib.WriteJsonString(buf, string(mj.Ver))
@@ -338,3 +451,274 @@ func (mj *One) MarshalTagJSON_UserRooms(buf fflib.EncodingBuffer) error {
 	buf.WriteByte('}')
 	return nil
 }
+
+func (uj *One) UnmarshalTagJSON(input []byte, t int) error {
+	fs := fflib.NewFFLexer(input)
+	return uj.UnmarshalTagJSONLexer(t, fs, fflib.FFParse_map_start)
+}
+
+func (uj *One) UnmarshalTagJSONLexer(t int, fs *fflib.FFLexer, state fflib.FFParseState) error {
+	switch t {
+
+	case Upd:
+		return uj.UnmarshalTagJSON_Upd(fs, fflib.FFParse_map_start)
+
+	}
+	return fmt.Errorf("Unmarshal tag not found")
+}
+
+const (
+	ffj_t_One_Updbase = iota
+	ffj_t_One_Updno_such_key
+	ffj_t_One_Upd_Name
+	ffj_t_One_Upd_Dsc
+	ffj_t_One_Upd_Enabled
+)
+
+var (
+	ffj_k_One_Upd_Name    = []byte("Name")
+	ffj_k_One_Upd_Dsc     = []byte("Dsc")
+	ffj_k_One_Upd_Enabled = []byte("Enabled")
+)
+
+func (uj *One) UnmarshalTagJSON_Upd(fs *fflib.FFLexer, state fflib.FFParseState) error {
+	var err error = nil
+	currentKey := ffj_t_One_Updbase
+	_ = currentKey
+	tok := fflib.FFTok_init
+	wantedTok := fflib.FFTok_init
+
+mainparse:
+	for {
+		tok = fs.Scan()
+		//	println(fmt.Sprintf("debug: tok: %v  state: %v", tok, state))
+		if tok == fflib.FFTok_error {
+			goto tokerror
+		}
+
+		switch state {
+
+		case fflib.FFParse_map_start:
+			if tok != fflib.FFTok_left_bracket {
+				wantedTok = fflib.FFTok_left_bracket
+				goto wrongtokenerror
+			}
+			state = fflib.FFParse_want_key
+			continue
+
+		case fflib.FFParse_after_value:
+			if tok == fflib.FFTok_comma {
+				state = fflib.FFParse_want_key
+			} else if tok == fflib.FFTok_right_bracket {
+				goto done
+			} else {
+				wantedTok = fflib.FFTok_comma
+				goto wrongtokenerror
+			}
+
+		case fflib.FFParse_want_key:
+			// json {} ended. goto exit. woo.
+			if tok == fflib.FFTok_right_bracket {
+				goto done
+			}
+			if tok != fflib.FFTok_string {
+				wantedTok = fflib.FFTok_string
+				goto wrongtokenerror
+			}
+
+			kn := fs.Output.Bytes()
+			if len(kn) <= 0 {
+				// "" case. hrm.
+				currentKey = ffj_t_One_Updno_such_key
+				state = fflib.FFParse_want_colon
+				goto mainparse
+			} else {
+				switch kn[0] {
+
+				case 'D':
+
+					if bytes.Equal(ffj_k_One_Upd_Dsc, kn) {
+						currentKey = ffj_t_One_Upd_Dsc
+						state = fflib.FFParse_want_colon
+						goto mainparse
+					}
+
+				case 'E':
+
+					if bytes.Equal(ffj_k_One_Upd_Enabled, kn) {
+						currentKey = ffj_t_One_Upd_Enabled
+						state = fflib.FFParse_want_colon
+						goto mainparse
+					}
+
+				case 'N':
+
+					if bytes.Equal(ffj_k_One_Upd_Name, kn) {
+						currentKey = ffj_t_One_Upd_Name
+						state = fflib.FFParse_want_colon
+						goto mainparse
+					}
+
+				}
+
+				if fflib.SimpleLetterEqualFold(ffj_k_One_Upd_Enabled, kn) {
+					currentKey = ffj_t_One_Upd_Enabled
+					state = fflib.FFParse_want_colon
+					goto mainparse
+				}
+
+				if fflib.SimpleLetterEqualFold(ffj_k_One_Upd_Dsc, kn) {
+					currentKey = ffj_t_One_Upd_Dsc
+					state = fflib.FFParse_want_colon
+					goto mainparse
+				}
+
+				if fflib.SimpleLetterEqualFold(ffj_k_One_Upd_Name, kn) {
+					currentKey = ffj_t_One_Upd_Name
+					state = fflib.FFParse_want_colon
+					goto mainparse
+				}
+
+				currentKey = ffj_t_One_Updno_such_key
+				state = fflib.FFParse_want_colon
+				goto mainparse
+			}
+
+		case fflib.FFParse_want_colon:
+			if tok != fflib.FFTok_colon {
+				wantedTok = fflib.FFTok_colon
+				goto wrongtokenerror
+			}
+			state = fflib.FFParse_want_value
+			continue
+		case fflib.FFParse_want_value:
+
+			if tok == fflib.FFTok_left_brace || tok == fflib.FFTok_left_bracket || tok == fflib.FFTok_integer || tok == fflib.FFTok_double || tok == fflib.FFTok_string || tok == fflib.FFTok_bool || tok == fflib.FFTok_null {
+				switch currentKey {
+
+				case ffj_t_One_Upd_Name:
+					goto handle_Name
+
+				case ffj_t_One_Upd_Dsc:
+					goto handle_Dsc
+
+				case ffj_t_One_Upd_Enabled:
+					goto handle_Enabled
+
+				case ffj_t_One_Updno_such_key:
+					err = fs.SkipField(tok)
+					if err != nil {
+						return fs.WrapErr(err)
+					}
+					state = fflib.FFParse_after_value
+					goto mainparse
+				}
+			} else {
+				goto wantedvalue
+			}
+		}
+	}
+
+handle_Name:
+
+	/* handler: uj.Name type=string kind=string quoted=false*/
+
+	{
+
+		{
+			if tok != fflib.FFTok_string && tok != fflib.FFTok_null {
+				return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for string", tok))
+			}
+		}
+
+		if tok == fflib.FFTok_null {
+
+		} else {
+
+			outBuf := fs.Output.Bytes()
+
+			uj.Name = string(string(outBuf))
+
+		}
+	}
+
+	state = fflib.FFParse_after_value
+	goto mainparse
+
+handle_Dsc:
+
+	/* handler: uj.Dsc type=string kind=string quoted=false*/
+
+	{
+
+		{
+			if tok != fflib.FFTok_string && tok != fflib.FFTok_null {
+				return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for string", tok))
+			}
+		}
+
+		if tok == fflib.FFTok_null {
+
+		} else {
+
+			outBuf := fs.Output.Bytes()
+
+			uj.Dsc = string(string(outBuf))
+
+		}
+	}
+
+	state = fflib.FFParse_after_value
+	goto mainparse
+
+handle_Enabled:
+
+	/* handler: uj.Enabled type=bool kind=bool quoted=false*/
+
+	{
+		if tok != fflib.FFTok_bool && tok != fflib.FFTok_null {
+			return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for bool", tok))
+		}
+	}
+
+	{
+		if tok == fflib.FFTok_null {
+
+		} else {
+			tmpb := fs.Output.Bytes()
+
+			if bytes.Compare([]byte{'t', 'r', 'u', 'e'}, tmpb) == 0 {
+
+				uj.Enabled = true
+
+			} else if bytes.Compare([]byte{'f', 'a', 'l', 's', 'e'}, tmpb) == 0 {
+
+				uj.Enabled = false
+
+			} else {
+				err = errors.New("unexpected bytes for true/false value")
+				return fs.WrapErr(err)
+			}
+
+		}
+	}
+
+	state = fflib.FFParse_after_value
+	goto mainparse
+
+wantedvalue:
+	return fs.WrapErr(fmt.Errorf("wanted value token, but got token: %v", tok))
+wrongtokenerror:
+	return fs.WrapErr(fmt.Errorf("ffjson: wanted token: %v, but got token: %v output=%s", wantedTok, tok, fs.Output.String()))
+tokerror:
+	if fs.BigError != nil {
+		return fs.WrapErr(fs.BigError)
+	}
+	err = fs.Error.ToError()
+	if err != nil {
+		return fs.WrapErr(err)
+	}
+	panic("ffjson-generated: unreachable, please report bug.")
+done:
+	return nil
+}
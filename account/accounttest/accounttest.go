@@ -0,0 +1,23 @@
+// Package accounttest sets up the mem AccountService for tests of other
+// packages, it is only imported by _test files.
+package accounttest
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/empirefox/ic-server-conductor/account"
+)
+
+// Setup sets a new mem AccountService and logs in the owner,
+// call it at the top of Convey.
+func Setup() *account.Oauth {
+	account.SetService(account.NewMemAccountService())
+	return Login("owner")
+}
+
+// Login logs in a new account with name as oid and name
+func Login(name string) *account.Oauth {
+	o := &account.Oauth{}
+	So(o.OnLogin("p", name, name, ""), ShouldBeNil)
+	return o
+}
@@ -0,0 +1,75 @@
+package account
+
+import (
+	"errors"
+	"strings"
+)
+
+var ErrCamerasTooLong = errors.New("Cameras too long")
+
+// Cameras is the allow-list of a shared view, empty allows all cameras.
+type Cameras []string
+
+func (cs Cameras) Allow(camera string) bool {
+	if len(cs) == 0 {
+		return true
+	}
+	for _, c := range cs {
+		if c == camera {
+			return true
+		}
+	}
+	return false
+}
+
+// CameraList splits the saved Cameras, nil when all cameras allowed.
+func (ao *AccountOne) CameraList() Cameras {
+	if ao.Cameras == "" {
+		return nil
+	}
+	return Cameras(strings.Split(ao.Cameras, ","))
+}
+
+// ViewCameras returns the allow-list of the viewer in the room,
+// nil when the room is not shared with the viewer, like owners and org members.
+func (o *Oauth) ViewCameras(one *One) (Cameras, error) {
+	ao := &AccountOne{}
+	if err := aservice.FindView(ao, o.AccountId, one.ID); err != nil {
+		if err == ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return ao.CameraList(), nil
+}
+
+// CanViewCamera checks the allow-list, CanView must be checked before.
+// Empty camera means the whole room, only allowed to unrestricted viewers.
+func (o *Oauth) CanViewCamera(one *One, camera string) bool {
+	cs, err := o.ViewCameras(one)
+	if err != nil {
+		return false
+	}
+	if camera == "" {
+		return len(cs) == 0
+	}
+	return cs.Allow(camera)
+}
+
+// SetViewCameras limits the cameras the viewer can see in the room,
+// empty cameras allows all.
+// The owner sees all cameras, ErrViewOwner is returned.
+func (o *One) SetViewCameras(accountId uint, cameras []string) (Cameras, error) {
+	if accountId == o.OwnerId {
+		return nil, ErrViewOwner
+	}
+	joined := joinClean(cameras)
+	if len(joined) > 1024 {
+		return nil, ErrCamerasTooLong
+	}
+	ao := &AccountOne{AccountId: accountId, OneId: o.ID, Cameras: joined}
+	if err := aservice.SetViewCameras(ao); err != nil {
+		return nil, err
+	}
+	return ao.CameraList(), nil
+}
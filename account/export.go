@@ -0,0 +1,156 @@
+package account
+
+import (
+	"strings"
+	"time"
+)
+
+/////////////////////////////////////////
+//             AccountExport
+/////////////////////////////////////////
+
+// AccountExport is everything stored for an Account.
+// Secrets are never exported: room tokens(Addr), password and api key
+// hashes, guest link codes, totp secret, recovery codes and session jtis.
+type AccountExport struct {
+	ExportedAt time.Time        `json:"exported_at"`
+	Account    ExportedAccount  `json:"account"`
+	Oauths     []ExportedOauth  `json:"oauths"`
+	ApiKeys    []ExportedApiKey `json:"api_keys"`
+	Totp       *ExportedTotp    `json:"totp,omitempty"`
+	Sessions   []Session        `json:"sessions"`
+	Ones       []ExportedOne    `json:"ones"`
+	Views      AccountOnes      `json:"views"`
+	Orgs       []OrgMember      `json:"orgs"`
+	Groups     []RoomGroup      `json:"groups"`
+	Transfers  []OneTransfer    `json:"transfers"`
+}
+
+type ExportedAccount struct {
+	ID        uint      `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Name      string    `json:"name"`
+	Dsc       string    `json:"dsc"`
+}
+
+type ExportedOauth struct {
+	CreatedAt time.Time `json:"created_at"`
+	Provider  string    `json:"provider"`
+	Oid       string    `json:"oid"`
+	Name      string    `json:"name"`
+	Picture   string    `json:"picture"`
+}
+
+type ExportedApiKey struct {
+	ID        uint      `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Name      string    `json:"name"`
+	Enabled   bool      `json:"enabled"`
+}
+
+type ExportedTotp struct {
+	CreatedAt     time.Time `json:"created_at"`
+	Enabled       bool      `json:"enabled"`
+	RecoveryCodes int       `json:"recovery_codes"`
+}
+
+// ExportedOne has the views shared to others and the guest links
+type ExportedOne struct {
+	ID         uint        `json:"id"`
+	CreatedAt  time.Time   `json:"created_at"`
+	Name       string      `json:"name"`
+	Dsc        string      `json:"dsc"`
+	OrgId      uint        `json:"org"`
+	Enabled    bool        `json:"enabled"`
+	Shares     AccountOnes `json:"shares"`
+	GuestLinks []GuestLink `json:"guest_links"`
+}
+
+// Export collects the data of a through AccountService.
+// Owned rooms only, viewed rooms are listed in Views.
+func (a *Account) Export() (*AccountExport, error) {
+	ex := &AccountExport{
+		ExportedAt: time.Now(),
+		Account:    ExportedAccount{ID: a.ID, CreatedAt: a.CreatedAt, Name: a.Name, Dsc: a.Dsc},
+	}
+
+	var os []Oauth
+	if err := aservice.AccountOauths(a, &os); err != nil {
+		return nil, err
+	}
+	ex.Oauths = make([]ExportedOauth, 0, len(os))
+	ex.ApiKeys = []ExportedApiKey{}
+	for _, o := range os {
+		if o.Provider == ProviderApiKey {
+			ex.ApiKeys = append(ex.ApiKeys, ExportedApiKey{
+				ID:        o.ID,
+				CreatedAt: o.CreatedAt,
+				Name:      o.Name,
+				Enabled:   o.Enabled,
+			})
+			continue
+		}
+		ex.Oauths = append(ex.Oauths, ExportedOauth{
+			CreatedAt: o.CreatedAt,
+			Provider:  o.Provider,
+			Oid:       o.Oid,
+			Name:      o.Name,
+			Picture:   o.Picture,
+		})
+	}
+
+	t := &AccountTotp{}
+	switch err := a.Totp(t); err {
+	case nil:
+		ex.Totp = &ExportedTotp{CreatedAt: t.CreatedAt, Enabled: t.Enabled}
+		if t.Recovery != "" {
+			ex.Totp.RecoveryCodes = len(strings.Split(t.Recovery, ","))
+		}
+	case ErrRecordNotFound:
+	default:
+		return nil, err
+	}
+	if err := a.Sessions(&ex.Sessions); err != nil {
+		return nil, err
+	}
+
+	if err := a.GetOnes(); err != nil {
+		return nil, err
+	}
+	ex.Ones = []ExportedOne{}
+	for i := range a.Ones {
+		one := &a.Ones[i]
+		if one.OwnerId != a.ID {
+			continue
+		}
+		eo := ExportedOne{
+			ID:        one.ID,
+			CreatedAt: one.CreatedAt,
+			Name:      one.Name,
+			Dsc:       one.Dsc,
+			OrgId:     one.OrgId,
+			Enabled:   one.Enabled,
+		}
+		if err := aservice.ViewsByShare(one, &eo.Shares); err != nil {
+			return nil, err
+		}
+		if err := aservice.OneGuestLinks(one, &eo.GuestLinks); err != nil {
+			return nil, err
+		}
+		ex.Ones = append(ex.Ones, eo)
+	}
+
+	if err := a.ViewsByViewer(&ex.Views); err != nil {
+		return nil, err
+	}
+	if err := a.Orgs(&ex.Orgs); err != nil {
+		return nil, err
+	}
+	if err := a.Groups(&ex.Groups); err != nil {
+		return nil, err
+	}
+	if err := a.Transfers(&ex.Transfers); err != nil {
+		return nil, err
+	}
+	return ex, nil
+}
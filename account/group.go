@@ -0,0 +1,91 @@
+package account
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+var ErrTagsTooLong = errors.New("Tags too long")
+
+/////////////////////////////////////////
+//              RoomGroup
+/////////////////////////////////////////
+
+// RoomGroup is a folder of the viewer, AccountOne.GroupId refers to it.
+type RoomGroup struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	AccountId uint   `sql:"index"`
+	Name      string `sql:"type:varchar(64);not null"`
+	Sort      int
+}
+
+func (g *RoomGroup) FindIfOwner(id, accountId uint) error {
+	return aservice.FindGroup(g, id, accountId)
+}
+
+func (g *RoomGroup) Save() error { return aservice.SaveGroup(g) }
+
+// views in the group will be ungrouped
+func (g *RoomGroup) Delete() error { return aservice.DeleteGroup(g) }
+
+func (a *Account) Groups(gs *[]RoomGroup) error { return aservice.AccountGroups(a, gs) }
+
+// SetView changes group and tags of an existed view of a
+func (a *Account) SetView(oneId, groupId uint, tags []string) error {
+	joined, err := JoinTags(tags)
+	if err != nil {
+		return err
+	}
+	if groupId != 0 {
+		if err := (&RoomGroup{}).FindIfOwner(groupId, a.ID); err != nil {
+			return err
+		}
+	}
+	return aservice.UpdateView(&AccountOne{AccountId: a.ID, OneId: oneId, GroupId: groupId, Tags: joined})
+}
+
+// JoinTags trims and dedups tags, tags are saved comma separated.
+func JoinTags(tags []string) (string, error) {
+	joined := joinClean(tags)
+	if len(joined) > 255 {
+		return "", ErrTagsTooLong
+	}
+	return joined, nil
+}
+
+func joinClean(items []string) string {
+	seen := make(map[string]bool, len(items))
+	var clean []string
+	for _, item := range items {
+		item = strings.TrimSpace(strings.Replace(item, ",", " ", -1))
+		if item != "" && !seen[item] {
+			seen[item] = true
+			clean = append(clean, item)
+		}
+	}
+	return strings.Join(clean, ",")
+}
+
+func (ao *AccountOne) HasTag(tag string) bool {
+	for _, t := range strings.Split(ao.Tags, ",") {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// RoomFilter selects views by group or tag, zero value selects all.
+type RoomFilter struct {
+	GroupId uint
+	Tag     string
+}
+
+func (f RoomFilter) Match(ao *AccountOne) bool {
+	if f.GroupId != 0 && ao.GroupId != f.GroupId {
+		return false
+	}
+	return f.Tag == "" || ao.HasTag(f.Tag)
+}
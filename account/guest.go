@@ -0,0 +1,81 @@
+package account
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/dchest/uniuri"
+)
+
+var (
+	ErrGuestExpired  = errors.New("Guest link expired")
+	ErrGuestSessions = errors.New("Guest link max sessions required")
+)
+
+/////////////////////////////////////////
+//              GuestLink
+/////////////////////////////////////////
+
+// GuestLink lets anyone with the code view the room without an account.
+// Only the hash of the code is saved, the code is shown once when created.
+type GuestLink struct {
+	ID          uint `gorm:"primary_key"`
+	CreatedAt   time.Time
+	OneId       uint `sql:"index"`
+	CreatorId   uint
+	CodeHash    string `sql:"type:varchar(64);unique_index" json:"-"`
+	Camera      string `sql:"type:varchar(128)" json:",omitempty"` // empty for all cameras
+	MaxSessions int
+	ExpiresAt   time.Time `sql:"index"`
+}
+
+func guestCodeHash(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+func (l *GuestLink) Expired() bool { return !time.Now().Before(l.ExpiresAt) }
+
+// NewGuestLink saves the link of the One created by a, returns the code.
+func (a *Account) NewGuestLink(one *One, camera string, maxSessions int, expiresAt time.Time) (*GuestLink, string, error) {
+	if maxSessions < 1 {
+		return nil, "", ErrGuestSessions
+	}
+	if !time.Now().Before(expiresAt) {
+		return nil, "", ErrGuestExpired
+	}
+	code := uniuri.NewLen(32)
+	l := &GuestLink{
+		OneId:       one.ID,
+		CreatorId:   a.ID,
+		CodeHash:    guestCodeHash(code),
+		Camera:      camera,
+		MaxSessions: maxSessions,
+		ExpiresAt:   expiresAt,
+	}
+	if err := aservice.SaveGuestLink(l); err != nil {
+		return nil, "", err
+	}
+	return l, code, nil
+}
+
+func (l *GuestLink) Find(id uint) error { return aservice.FindGuestLink(l, id) }
+
+// FindByCode only finds links not expired
+func (l *GuestLink) FindByCode(code string) error {
+	if err := aservice.FindGuestLinkByHash(l, guestCodeHash(code)); err != nil {
+		return err
+	}
+	if l.Expired() {
+		return ErrGuestExpired
+	}
+	return nil
+}
+
+func (l *GuestLink) Delete() error { return aservice.DeleteGuestLink(l) }
+
+func (o *One) GuestLinks(ls *[]GuestLink) error { return aservice.OneGuestLinks(o, ls) }
+
+func PurgeGuestLinks() error { return aservice.PurgeGuestLinks(time.Now()) }
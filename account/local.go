@@ -0,0 +1,175 @@
+package account
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"github.com/dchest/uniuri"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Providers of Oauth not from goauth, both are verified by SecretHash.
+const (
+	ProviderLocal  = "local"
+	ProviderApiKey = "apikey"
+
+	apiKeyPrefix = "ick_"
+)
+
+var (
+	ErrBadCredentials = errors.New("Bad username or password")
+	ErrUsernameTaken  = errors.New("Username taken")
+	ErrWeakPassword   = errors.New("Password must be at least 8 characters")
+	ErrBadApiKey      = errors.New("Bad api key")
+	ErrNotLocal       = errors.New("Not a local account")
+)
+
+// compared when username not found, so timing does not tell if it exists
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
+func hashPassword(password string) (string, error) {
+	if len(password) < 8 {
+		return "", ErrWeakPassword
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// NewLocalUser creates an Account with a local Oauth,
+// used where no external provider can be reached.
+func NewLocalUser(username, password, name string) (*Oauth, error) {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	if exists, err := aservice.OauthExists(ProviderLocal, username); err != nil {
+		return nil, err
+	} else if exists {
+		return nil, ErrUsernameTaken
+	}
+	if name == "" {
+		name = username
+	}
+	o := &Oauth{}
+	if err := o.OnLogin(ProviderLocal, username, name, ""); err != nil {
+		return nil, err
+	}
+	o.SecretHash = hash
+	if err := aservice.SetOauthSecret(o); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// LoginLocal finds the valid local Oauth matched the password
+func (o *Oauth) LoginLocal(username, password string) error {
+	if err := o.Find(ProviderLocal, username); err != nil {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return ErrBadCredentials
+	}
+	if bcrypt.CompareHashAndPassword([]byte(o.SecretHash), []byte(password)) != nil || !o.Valid() {
+		return ErrBadCredentials
+	}
+	return nil
+}
+
+// SetPassword changes the password of a local Oauth
+func (o *Oauth) SetPassword(password string) error {
+	if o.Provider != ProviderLocal {
+		return ErrNotLocal
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	o.SecretHash = hash
+	return aservice.SetOauthSecret(o)
+}
+
+// ChangePassword checks the current password before SetPassword
+func (o *Oauth) ChangePassword(current, password string) error {
+	if o.Provider != ProviderLocal {
+		return ErrNotLocal
+	}
+	if err := (&Oauth{}).LoginLocal(o.Oid, current); err != nil {
+		return err
+	}
+	return o.SetPassword(password)
+}
+
+func apiKeyHash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// IsApiKey tells api keys from jwt tokens
+func IsApiKey(token string) bool { return strings.HasPrefix(token, apiKeyPrefix) }
+
+// NewApiKey adds an api key Oauth to a for automation.
+// The key is ick_[oid]_[secret], only returned here.
+func (a *Account) NewApiKey(name string) (*Oauth, string, error) {
+	if name == "" {
+		return nil, "", ErrParamsRequired
+	}
+	oid := uniuri.NewLen(12)
+	secret := uniuri.NewLen(32)
+	o := &Oauth{
+		Name:       name,
+		AccountId:  a.ID,
+		Oid:        oid,
+		Provider:   ProviderApiKey,
+		Enabled:    true,
+		SecretHash: apiKeyHash(secret),
+	}
+	if err := aservice.SaveOauth(o); err != nil {
+		return nil, "", err
+	}
+	return o, apiKeyPrefix + oid + "_" + secret, nil
+}
+
+// FindByApiKey finds the valid Oauth of the key, Account preloaded
+func (o *Oauth) FindByApiKey(key string) error {
+	parts := strings.SplitN(strings.TrimPrefix(key, apiKeyPrefix), "_", 2)
+	if !IsApiKey(key) || len(parts) != 2 {
+		return ErrBadApiKey
+	}
+	if err := o.Find(ProviderApiKey, parts[0]); err != nil {
+		return ErrBadApiKey
+	}
+	if subtle.ConstantTimeCompare([]byte(o.SecretHash), []byte(apiKeyHash(parts[1]))) != 1 || !o.Valid() {
+		return ErrBadApiKey
+	}
+	return nil
+}
+
+// ApiKeys lists api key Oauths of a
+func (a *Account) ApiKeys(keys *[]Oauth) error {
+	var os []Oauth
+	if err := aservice.AccountOauths(a, &os); err != nil {
+		return err
+	}
+	*keys = []Oauth{}
+	for _, o := range os {
+		if o.Provider == ProviderApiKey {
+			*keys = append(*keys, o)
+		}
+	}
+	return nil
+}
+
+// RevokeApiKey deletes the api key Oauth of a by id
+func (a *Account) RevokeApiKey(id uint) error {
+	var keys []Oauth
+	if err := a.ApiKeys(&keys); err != nil {
+		return err
+	}
+	for i := range keys {
+		if keys[i].ID == id {
+			return aservice.DeleteOauth(&keys[i])
+		}
+	}
+	return ErrRecordNotFound
+}
@@ -0,0 +1,130 @@
+package account
+
+import (
+	. "github.com/empirefox/ic-server-conductor/gorm"
+)
+
+// Append only, never change a released Migration.
+var migrations = []Migration{
+	{Version: 1, Name: "init", Up: migrateInit},
+	{Version: 2, Name: "revoked_tokens", Up: func(tx Tx) error {
+		return tx.AutoMigrate(&RevokedToken{}).Error
+	}},
+	{Version: 3, Name: "orgs", Up: migrateOrgs},
+	{Version: 4, Name: "room_groups", Up: migrateRoomGroups},
+	{Version: 5, Name: "one_transfers", Up: migrateOneTransfers},
+	{Version: 6, Name: "soft_delete", Up: func(tx Tx) error {
+		return tx.AutoMigrate(&Account{}, &One{}).Error
+	}},
+	{Version: 7, Name: "view_cameras", Up: func(tx Tx) error {
+		return tx.AutoMigrate(&AccountOne{}).Error
+	}},
+	{Version: 8, Name: "view_schedules", Up: func(tx Tx) error {
+		return tx.AutoMigrate(&AccountOne{}).Error
+	}},
+	{Version: 9, Name: "guest_links", Up: migrateGuestLinks},
+	{Version: 10, Name: "oauth_secrets", Up: func(tx Tx) error {
+		return tx.AutoMigrate(&Oauth{}).Error
+	}},
+	{Version: 11, Name: "account_totps", Up: migrateAccountTotps},
+	{Version: 12, Name: "sessions", Up: migrateSessions},
+	{Version: 13, Name: "oauth_unique", Up: func(tx Tx) error {
+		return tx.Model(&Oauth{}).AddUniqueIndex("uix_oauths_provider_oid", "provider", "oid").Error
+	}},
+	{Version: 14, Name: "step_up", Up: func(tx Tx) error {
+		return tx.AutoMigrate(&AccountTotp{}, &Session{}).Error
+	}},
+	{Version: 15, Name: "one_disabled_by_sys", Up: func(tx Tx) error {
+		return tx.AutoMigrate(&One{}).Error
+	}},
+}
+
+// LatestVersion is the schema version the code works with.
+func LatestVersion() int { return migrations[len(migrations)-1].Version }
+
+// Databases created before migrations already have the tables.
+func migrateInit(tx Tx) error {
+	if tx.HasTable(&Account{}) {
+		return nil
+	}
+	ao := &AccountOne{}
+	one := &One{}
+	oauth := &Oauth{}
+	err := tx.CreateTable(ao).CreateTable(&Account{}).CreateTable(one).
+		CreateTable(oauth).CreateTable(&OauthProvider{}).Error
+	if err != nil {
+		return err
+	}
+	return tx.Dialect.AddCascades(tx.DB,
+		Cascade{Model: ao, Field: "account_id", Parent: "accounts"},
+		Cascade{Model: ao, Field: "one_id", Parent: "ones"},
+		Cascade{Model: one, Field: "owner_id", Parent: "accounts"},
+		Cascade{Model: oauth, Field: "account_id", Parent: "accounts"},
+	)
+}
+
+func migrateOrgs(tx Tx) error {
+	err := tx.AutoMigrate(&Org{}, &OrgMember{}, &One{}).
+		Model(&One{}).Where("org_id is null").Update("org_id", 0).Error
+	if err != nil {
+		return err
+	}
+	m := &OrgMember{}
+	return tx.Dialect.AddCascades(tx.DB,
+		Cascade{Model: m, Field: "org_id", Parent: "orgs"},
+		Cascade{Model: m, Field: "account_id", Parent: "accounts"},
+	)
+}
+
+func migrateRoomGroups(tx Tx) error {
+	err := tx.AutoMigrate(&RoomGroup{}, &AccountOne{}).
+		Model(&AccountOne{}).Where("group_id is null").Update("group_id", 0).Error
+	if err != nil {
+		return err
+	}
+	return tx.Dialect.AddCascades(tx.DB,
+		Cascade{Model: &RoomGroup{}, Field: "account_id", Parent: "accounts"},
+	)
+}
+
+func migrateOneTransfers(tx Tx) error {
+	t := &OneTransfer{}
+	if err := tx.AutoMigrate(t).Error; err != nil {
+		return err
+	}
+	return tx.Dialect.AddCascades(tx.DB,
+		Cascade{Model: t, Field: "one_id", Parent: "ones"},
+		Cascade{Model: t, Field: "from_id", Parent: "accounts"},
+		Cascade{Model: t, Field: "to_id", Parent: "accounts"},
+	)
+}
+
+func migrateGuestLinks(tx Tx) error {
+	l := &GuestLink{}
+	if err := tx.AutoMigrate(l).Error; err != nil {
+		return err
+	}
+	return tx.Dialect.AddCascades(tx.DB,
+		Cascade{Model: l, Field: "one_id", Parent: "ones"},
+	)
+}
+
+func migrateAccountTotps(tx Tx) error {
+	t := &AccountTotp{}
+	if err := tx.AutoMigrate(t).Error; err != nil {
+		return err
+	}
+	return tx.Dialect.AddCascades(tx.DB,
+		Cascade{Model: t, Field: "account_id", Parent: "accounts"},
+	)
+}
+
+func migrateSessions(tx Tx) error {
+	ss := &Session{}
+	if err := tx.AutoMigrate(ss).Error; err != nil {
+		return err
+	}
+	return tx.Dialect.AddCascades(tx.DB,
+		Cascade{Model: ss, Field: "account_id", Parent: "accounts"},
+	)
+}
@@ -0,0 +1,93 @@
+package account
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	OrgRoleOwner  = "owner"
+	OrgRoleAdmin  = "admin"
+	OrgRoleMember = "member"
+)
+
+var (
+	ErrOrgRole      = errors.New("Unknown org role")
+	ErrLastOrgOwner = errors.New("Org must have an owner")
+)
+
+/////////////////////////////////////////
+//                Org
+/////////////////////////////////////////
+
+// Org owns rooms together with its members.
+// Owners and admins manage the rooms, all members can view them.
+type Org struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Name      string      `sql:"type:varchar(128);not null"`
+	Dsc       string      `sql:"type:varchar(128);default:''"`
+	Members   []OrgMember `json:",omitempty"`
+}
+
+func (org *Org) Find(id uint) error            { return aservice.FindOrg(org, id) }
+func (org *Org) Save() error                   { return aservice.SaveOrg(org) }
+func (org *Org) Delete() error                 { return aservice.DeleteOrg(org) }
+func (org *Org) Role(accountId uint) string    { return aservice.OrgRole(org.ID, accountId) }
+func (org *Org) CanManage(accountId uint) bool { return CanManageOrg(org.Role(accountId)) }
+func (org *Org) RemoveMember(accountId uint) error {
+	if org.Role(accountId) == OrgRoleOwner && org.owners() == 1 {
+		return ErrLastOrgOwner
+	}
+	return aservice.RemoveOrgMember(org.ID, accountId)
+}
+
+func (org *Org) SaveMember(m *OrgMember) error {
+	if !ValidOrgRole(m.Role) {
+		return ErrOrgRole
+	}
+	if m.Role != OrgRoleOwner && org.Role(m.AccountId) == OrgRoleOwner && org.owners() == 1 {
+		return ErrLastOrgOwner
+	}
+	m.OrgId = org.ID
+	return aservice.SaveOrgMember(m)
+}
+
+// Members must be loaded
+func (org *Org) owners() int {
+	n := 0
+	for _, m := range org.Members {
+		if m.Role == OrgRoleOwner {
+			n++
+		}
+	}
+	return n
+}
+
+/////////////////////////////////////////
+//              OrgMember
+/////////////////////////////////////////
+
+type OrgMember struct {
+	OrgId     uint   `gorm:"primary_key" sql:"auto_increment:false"`
+	Org       *Org   `json:",omitempty"`
+	AccountId uint   `gorm:"primary_key" sql:"auto_increment:false"`
+	Role      string `sql:"type:varchar(16);not null"`
+	CreatedAt time.Time
+}
+
+func ValidOrgRole(role string) bool {
+	return role == OrgRoleOwner || role == OrgRoleAdmin || role == OrgRoleMember
+}
+
+func CanManageOrg(role string) bool { return role == OrgRoleOwner || role == OrgRoleAdmin }
+
+// CreateOrg saves org with a as the owner
+func (a *Account) CreateOrg(org *Org) error { return aservice.CreateOrg(a, org) }
+
+// Orgs returns all memberships of a, with Org loaded
+func (a *Account) Orgs(ms *[]OrgMember) error { return aservice.AccountOrgs(a, ms) }
+
+// SetOrg moves the One to org, 0 moves it back to the owner.
+func (o *One) SetOrg(orgId uint) error { return aservice.SetOneOrg(o, orgId) }
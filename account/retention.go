@@ -0,0 +1,22 @@
+package account
+
+import "time"
+
+// DeleteGrace is how long a deleted room or logged off account
+// can be restored, it is purged after that.
+var DeleteGrace = 30 * 24 * time.Hour
+
+// DeletedOnes lists deleted rooms managed by a, which can be restored.
+func (a *Account) DeletedOnes(ones *Ones) error {
+	return aservice.DeletedOnes(a, ones, time.Now().Add(-DeleteGrace))
+}
+
+// RestoreOne restores a deleted room managed by a, the One can login again.
+func (a *Account) RestoreOne(o *One, id uint) error {
+	return aservice.RestoreOne(o, id, a.ID, time.Now().Add(-DeleteGrace))
+}
+
+// PurgeDeleted finalizes deletions older than DeleteGrace.
+// Org rooms of a purged account are given to the oldest owner or admin
+// of the org, the account is kept until the org has one.
+func PurgeDeleted() error { return aservice.PurgeDeleted(time.Now().Add(-DeleteGrace)) }
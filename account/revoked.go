@@ -0,0 +1,26 @@
+package account
+
+import "time"
+
+/////////////////////////////////////////
+//             RevokedToken
+/////////////////////////////////////////
+
+// Revoked token ids(jti), checked when a token is presented.
+// Record can be purged after ExpiresAt, the token is invalid then.
+type RevokedToken struct {
+	Jti       string    `gorm:"primary_key" sql:"type:varchar(64)"`
+	ExpiresAt time.Time `sql:"index"`
+	CreatedAt time.Time
+}
+
+func RevokeToken(jti string, exp time.Time) error {
+	if jti == "" {
+		return ErrParamsRequired
+	}
+	return aservice.RevokeToken(&RevokedToken{Jti: jti, ExpiresAt: exp})
+}
+
+func IsTokenRevoked(jti string) bool { return aservice.IsTokenRevoked(jti) }
+
+func PurgeRevokedTokens() error { return aservice.PurgeRevokedTokens(time.Now()) }
@@ -0,0 +1,194 @@
+package account
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+var (
+	ErrBadSchedule = errors.New("Bad schedule")
+	ErrViewOwner   = errors.New("Owner view cannot be limited")
+)
+
+// ViewSchedule limits when a shared view is active,
+// no windows means active all the time.
+type ViewSchedule struct {
+	// IANA name, UTC when empty
+	Timezone string           `json:"timezone,omitempty"`
+	Windows  []ScheduleWindow `json:"windows,omitempty"`
+}
+
+// ScheduleWindow is weekly, From and To are "15:04" in the timezone.
+// To before From spans midnight, Days are the days the window starts.
+type ScheduleWindow struct {
+	Days []time.Weekday `json:"days"`
+	From string         `json:"from"`
+	To   string         `json:"to"`
+}
+
+func (s *ViewSchedule) Validate() error {
+	if _, err := time.LoadLocation(s.Timezone); err != nil {
+		return ErrBadSchedule
+	}
+	for _, w := range s.Windows {
+		from, err1 := dayMinutes(w.From)
+		to, err2 := dayMinutes(w.To)
+		if err1 != nil || err2 != nil || from == to || len(w.Days) == 0 {
+			return ErrBadSchedule
+		}
+		for _, d := range w.Days {
+			if d < time.Sunday || d > time.Saturday {
+				return ErrBadSchedule
+			}
+		}
+	}
+	return nil
+}
+
+// ActiveAt is false for an invalid schedule
+func (s *ViewSchedule) ActiveAt(t time.Time) bool {
+	if len(s.Windows) == 0 {
+		return true
+	}
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return false
+	}
+	t = t.In(loc)
+	now := t.Hour()*60 + t.Minute()
+	for _, w := range s.Windows {
+		from, err1 := dayMinutes(w.From)
+		to, err2 := dayMinutes(w.To)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		if from < to {
+			if w.hasDay(t.Weekday()) && from <= now && now < to {
+				return true
+			}
+			continue
+		}
+		if (w.hasDay(t.Weekday()) && now >= from) || (w.hasDay((t.Weekday()+6)%7) && now < to) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *ScheduleWindow) hasDay(d time.Weekday) bool {
+	for _, day := range w.Days {
+		if day == d {
+			return true
+		}
+	}
+	return false
+}
+
+func dayMinutes(hm string) (int, error) {
+	t, err := time.Parse("15:04", hm)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// ActiveAt checks the expiry and the schedule of the view
+func (ao *AccountOne) ActiveAt(t time.Time) bool {
+	if ao.ExpiresAt != nil && !t.Before(*ao.ExpiresAt) {
+		return false
+	}
+	if ao.Schedule == "" {
+		return true
+	}
+	var s ViewSchedule
+	if err := json.Unmarshal([]byte(ao.Schedule), &s); err != nil {
+		return false
+	}
+	return s.ActiveAt(t)
+}
+
+// SetViewSchedule limits when the viewer can view the room,
+// nil schedule and expiresAt remove the limits.
+// The owner can view all the time, ErrViewOwner is returned.
+func (o *One) SetViewSchedule(accountId uint, s *ViewSchedule, expiresAt *time.Time) error {
+	if accountId == o.OwnerId {
+		return ErrViewOwner
+	}
+	ao := &AccountOne{AccountId: accountId, OneId: o.ID, ExpiresAt: expiresAt}
+	if s != nil && len(s.Windows) != 0 {
+		if err := s.Validate(); err != nil {
+			return err
+		}
+		raw, err := json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		if len(raw) > 1024 {
+			return ErrBadSchedule
+		}
+		ao.Schedule = string(raw)
+	}
+	return aservice.SetViewSchedule(ao)
+}
+
+// GetActiveOnes loads Ones like GetOnes, without the views out of schedule.
+// Org rooms are active to the members all the time, same as CanView.
+func (a *Account) GetActiveOnes() error {
+	if err := a.GetOnes(); err != nil {
+		return err
+	}
+	var aos AccountOnes
+	if err := a.ViewsByViewer(&aos); err != nil {
+		return err
+	}
+	var ms []OrgMember
+	if err := a.Orgs(&ms); err != nil {
+		return err
+	}
+	now := time.Now()
+	closed := make(map[uint]bool)
+	for i := range aos {
+		if !aos[i].ActiveAt(now) {
+			closed[aos[i].OneId] = true
+		}
+	}
+	orgs := make(map[uint]bool)
+	for _, m := range ms {
+		orgs[m.OrgId] = true
+	}
+	active := a.Ones[:0]
+	for _, one := range a.Ones {
+		if !closed[one.ID] || (one.OrgId != 0 && orgs[one.OrgId]) {
+			active = append(active, one)
+		}
+	}
+	a.Ones = active
+	return nil
+}
+
+// ActiveViewers loads Viewers, without the views out of schedule.
+func (o *One) ActiveViewers() error {
+	if err := o.Viewers(); err != nil {
+		return err
+	}
+	var aos AccountOnes
+	if err := aservice.ViewsByShare(o, &aos); err != nil {
+		return err
+	}
+	now := time.Now()
+	closed := make(map[uint]bool)
+	for i := range aos {
+		if !aos[i].ActiveAt(now) {
+			closed[aos[i].AccountId] = true
+		}
+	}
+	active := o.Accounts[:0]
+	for _, a := range o.Accounts {
+		if !closed[a.ID] || (o.OrgId != 0 && aservice.OrgRole(o.OrgId, a.ID) != "") {
+			active = append(active, a)
+		}
+	}
+	o.Accounts = active
+	return nil
+}
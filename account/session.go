@@ -0,0 +1,166 @@
+package account
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+var (
+	// LastSeen is saved at most once in the period
+	SessionTouchPeriod = time.Minute
+	// used as expiry of tokens without exp claim
+	SessionMaxAge = 30 * 24 * time.Hour
+)
+
+// touches are the jtis seen in SessionTouchPeriod by this process,
+// so busy tokens are not queried on every request.
+var (
+	touchesMu sync.Mutex
+	touches   = make(map[string]time.Time)
+)
+
+func resetTouches() {
+	touchesMu.Lock()
+	touches = make(map[string]time.Time)
+	touchesMu.Unlock()
+}
+
+// touchDue marks jti seen at now, false when seen in SessionTouchPeriod
+func touchDue(jti string, now time.Time) bool {
+	touchesMu.Lock()
+	defer touchesMu.Unlock()
+	if seen, ok := touches[jti]; ok && now.Sub(seen) < SessionTouchPeriod {
+		return false
+	}
+	touches[jti] = now
+	return true
+}
+
+func purgeTouches(now time.Time) {
+	touchesMu.Lock()
+	defer touchesMu.Unlock()
+	for jti, seen := range touches {
+		if now.Sub(seen) >= SessionTouchPeriod {
+			delete(touches, jti)
+		}
+	}
+}
+
+/////////////////////////////////////////
+//               Session
+/////////////////////////////////////////
+
+// Session is a token issued to or used by the account.
+// Jti is the sha256 of the token, goauth tokens have no jti claim,
+// it is also the id saved as RevokedToken when revoked.
+type Session struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	AccountId uint   `sql:"index"`
+	Provider  string `sql:"type:varchar(32)"`
+	Jti       string `sql:"type:varchar(64);unique_index" json:"-"`
+	Device    string `sql:"type:varchar(128)"`
+	UserAgent string `sql:"type:varchar(255)"`
+	LastSeen  time.Time
+	ExpiresAt time.Time `sql:"index"`
+	// issued by RecordSession after StepUp, see PreAuth
+	SteppedUp bool
+}
+
+func TokenJti(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func newSession(o *Oauth, token string, exp time.Time) *Session {
+	if exp.IsZero() {
+		exp = time.Now().Add(SessionMaxAge)
+	}
+	return &Session{
+		AccountId: o.AccountId,
+		Provider:  o.Provider,
+		Jti:       TokenJti(token),
+		LastSeen:  time.Now(),
+		ExpiresAt: exp,
+	}
+}
+
+// RecordSession saves the token issued to o, labeled by device and user-agent.
+// steppedUp is true when issued after StepUp passed, see PreAuth.
+func (o *Oauth) RecordSession(token, device, ua string, exp time.Time, steppedUp bool) error {
+	s := newSession(o, token, exp)
+	s.Device, s.UserAgent, s.SteppedUp = device, ua, steppedUp
+	if err := aservice.UpsertSession(s, "device", "user_agent", "last_seen", "stepped_up"); err != nil {
+		return err
+	}
+	touchDue(s.Jti, s.LastSeen)
+	return nil
+}
+
+// SeenSession updates LastSeen of the token verified for o, at most once
+// in SessionTouchPeriod without any query. Tokens not recorded when issued
+// are recorded when first seen.
+func (o *Oauth) SeenSession(token, ua string, exp time.Time) error {
+	s := newSession(o, token, exp)
+	if !touchDue(s.Jti, s.LastSeen) {
+		return nil
+	}
+	s.UserAgent = ua
+	if ua == "" {
+		return aservice.UpsertSession(s, "last_seen")
+	}
+	return aservice.UpsertSession(s, "user_agent", "last_seen")
+}
+
+// PreAuth reports whether the token verified for o needs a step-up first,
+// that is the account enabled totp and the token was not issued by
+// RecordSession, as tokens of provider logins. They only get a new token.
+func (o *Oauth) PreAuth(token string) (bool, error) {
+	if o.Provider == ProviderApiKey {
+		return false, nil
+	}
+	t := &AccountTotp{}
+	if err := o.Account.Totp(t); err == ErrRecordNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if !t.Enabled {
+		return false, nil
+	}
+	s := &Session{}
+	if err := aservice.FindSession(s, TokenJti(token)); err == ErrRecordNotFound {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	return !s.SteppedUp, nil
+}
+
+// Sessions lists the sessions of a not expired
+func (a *Account) Sessions(ss *[]Session) error { return aservice.AccountSessions(a, ss, time.Now()) }
+
+// RevokeSession revokes the token of the session, returns the deleted session
+func (a *Account) RevokeSession(id uint) (*Session, error) {
+	var ss []Session
+	if err := a.Sessions(&ss); err != nil {
+		return nil, err
+	}
+	for i := range ss {
+		if ss[i].ID == id {
+			if err := RevokeToken(ss[i].Jti, ss[i].ExpiresAt); err != nil {
+				return nil, err
+			}
+			return &ss[i], aservice.DeleteSession(&ss[i])
+		}
+	}
+	return nil, ErrRecordNotFound
+}
+
+func PurgeSessions() error {
+	now := time.Now()
+	purgeTouches(now)
+	return aservice.PurgeSessions(now)
+}
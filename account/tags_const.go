@@ -6,6 +6,7 @@ const (
 	UserRooms
 	ViewByViewer
 	ViewByShare
+	Upd
 
 	// OauthProvider
 	PrdSave
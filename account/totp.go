@@ -0,0 +1,231 @@
+package account
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dchest/uniuri"
+)
+
+var (
+	ErrTotpRequired    = errors.New("Totp code required")
+	ErrBadTotp         = errors.New("Bad totp code")
+	ErrTotpEnrolled    = errors.New("Totp already enabled")
+	ErrTotpNotEnrolled = errors.New("Totp not enabled")
+	ErrTotpLocked      = errors.New("Too many bad totp codes, try later")
+	ErrTotpChanged     = errors.New("Totp changed by another request")
+)
+
+var (
+	// RFC 6238 defaults, supported by most authenticator apps
+	TotpPeriod int64 = 30
+	// steps accepted before and after now, for clock drift
+	TotpSkew          int64 = 1
+	RecoveryCodeCount       = 10
+	// bad codes in a row before StepUp is locked for TotpLockout
+	TotpMaxFailures = 5
+	TotpLockout     = 5 * time.Minute
+)
+
+/////////////////////////////////////////
+//             AccountTotp
+/////////////////////////////////////////
+
+// AccountTotp is the optional second factor of an Account.
+// It is checked only after Enabled, which is set by the first valid code.
+type AccountTotp struct {
+	AccountId uint `gorm:"primary_key" sql:"auto_increment:false"`
+	CreatedAt time.Time
+	Secret    string `sql:"type:varchar(64);not null"`
+	Enabled   bool
+	// comma joined sha256 of unused recovery codes
+	Recovery string `sql:"type:varchar(1024)"`
+	// last accepted time step, a code cannot be used twice
+	LastStep int64
+	// bad codes in a row, reset by a good one or the lockout
+	Failures    int
+	LockedUntil time.Time
+}
+
+func totpCode(secret string, step int64) string {
+	key, err := base32.StdEncoding.DecodeString(secret)
+	if err != nil {
+		return ""
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	off := sum[len(sum)-1] & 0xf
+	v := binary.BigEndian.Uint32(sum[off:off+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", v%1000000)
+}
+
+// Url is the otpauth url shown as qr code when enrolling
+func (t *AccountTotp) Url(issuer, name string) string {
+	v := url.Values{}
+	v.Set("secret", t.Secret)
+	v.Set("issuer", issuer)
+	v.Set("period", fmt.Sprint(TotpPeriod))
+	return fmt.Sprintf("otpauth://totp/%s:%s?%s", url.QueryEscape(issuer), url.QueryEscape(name), v.Encode())
+}
+
+// checkCode accepts a code of the steps around now, newer than LastStep
+func (t *AccountTotp) checkCode(code string, now time.Time) bool {
+	if len(code) != 6 {
+		return false
+	}
+	step := now.Unix() / TotpPeriod
+	for i := step - TotpSkew; i <= step+TotpSkew; i++ {
+		if i > t.LastStep && hmac.Equal([]byte(totpCode(t.Secret, i)), []byte(code)) {
+			t.LastStep = i
+			return true
+		}
+	}
+	return false
+}
+
+func recoveryHash(code string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(code))))
+	return hex.EncodeToString(sum[:])
+}
+
+// useRecovery removes the matched recovery code
+func (t *AccountTotp) useRecovery(code string) bool {
+	hash := recoveryHash(code)
+	hashes := strings.Split(t.Recovery, ",")
+	for i, h := range hashes {
+		if h == hash {
+			t.Recovery = strings.Join(append(hashes[:i], hashes[i+1:]...), ",")
+			return true
+		}
+	}
+	return false
+}
+
+// newRecovery replaces all recovery codes, returns the codes
+func (t *AccountTotp) newRecovery() []string {
+	codes := make([]string, RecoveryCodeCount)
+	hashes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		codes[i] = strings.ToLower(uniuri.NewLen(10))
+		hashes[i] = recoveryHash(codes[i])
+	}
+	t.Recovery = strings.Join(hashes, ",")
+	return codes
+}
+
+func (a *Account) Totp(t *AccountTotp) error { return aservice.FindTotp(t, a.ID) }
+
+func (a *Account) TotpEnabled() bool {
+	t := &AccountTotp{}
+	return a.Totp(t) == nil && t.Enabled
+}
+
+// EnrollTotp creates a new secret, replacing an unconfirmed one
+func (a *Account) EnrollTotp() (*AccountTotp, error) {
+	if a.TotpEnabled() {
+		return nil, ErrTotpEnrolled
+	}
+	key := make([]byte, 20)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	t := &AccountTotp{
+		AccountId: a.ID,
+		Secret:    base32.StdEncoding.EncodeToString(key),
+	}
+	if err := aservice.CreateTotp(t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// ConfirmTotp enables totp by the first valid code, returns recovery codes.
+func (a *Account) ConfirmTotp(code string) ([]string, error) {
+	t := &AccountTotp{}
+	if err := a.Totp(t); err != nil {
+		return nil, err
+	}
+	if t.Enabled {
+		return nil, ErrTotpEnrolled
+	}
+	prev := *t
+	if !t.checkCode(code, time.Now()) {
+		return nil, ErrBadTotp
+	}
+	t.Enabled = true
+	codes := t.newRecovery()
+	if err := aservice.UpdateTotp(t, prev); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// StepUp verifies the totp or recovery code when totp enabled.
+// It passes directly when not enabled. After TotpMaxFailures bad codes
+// in a row it fails with ErrTotpLocked until TotpLockout passed.
+func (a *Account) StepUp(code string) error {
+	t := &AccountTotp{}
+	if err := a.Totp(t); err != nil {
+		if err == ErrRecordNotFound {
+			return nil
+		}
+		return err
+	}
+	if !t.Enabled {
+		return nil
+	}
+	if code == "" {
+		return ErrTotpRequired
+	}
+	now := time.Now()
+	if now.Before(t.LockedUntil) {
+		return ErrTotpLocked
+	}
+	prev := *t
+	if !t.checkCode(code, now) && !t.useRecovery(code) {
+		if err := aservice.FailTotp(a.ID, now.Add(TotpLockout)); err != nil {
+			return err
+		}
+		return ErrBadTotp
+	}
+	err := aservice.UpdateTotp(t, prev)
+	if err == ErrTotpChanged {
+		// the same code used by a concurrent request
+		return ErrBadTotp
+	}
+	return err
+}
+
+// NewRecoveryCodes replaces the recovery codes of enabled totp
+func (a *Account) NewRecoveryCodes() ([]string, error) {
+	t := &AccountTotp{}
+	if err := a.Totp(t); err == ErrRecordNotFound {
+		return nil, ErrTotpNotEnrolled
+	} else if err != nil {
+		return nil, err
+	}
+	if !t.Enabled {
+		return nil, ErrTotpNotEnrolled
+	}
+	prev := *t
+	codes := t.newRecovery()
+	if err := aservice.UpdateTotp(t, prev); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func (a *Account) DisableTotp() error { return aservice.DeleteTotp(a.ID) }
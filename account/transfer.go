@@ -0,0 +1,91 @@
+package account
+
+import (
+	"errors"
+	"time"
+
+	"github.com/dchest/uniuri"
+)
+
+// TransferTTL is how long the target can accept a transfer.
+var TransferTTL = 7 * 24 * time.Hour
+
+var (
+	ErrTransferSelf    = errors.New("Cannot transfer to self")
+	ErrTransferOwner   = errors.New("Only the owner of a personal room can transfer it")
+	ErrTransferExpired = errors.New("Transfer expired")
+	ErrTransferStale   = errors.New("Room owner changed after transfer started")
+)
+
+// newOneAddr is set when accepted, same as utils.NewRandom.
+// Token of the old owner is invalid then, even if the One is offline.
+func newOneAddr() string { return uniuri.NewLen(36) }
+
+/////////////////////////////////////////
+//             OneTransfer
+/////////////////////////////////////////
+
+// OneTransfer is a pending ownership transfer, deleted when accepted.
+// The old owner loses the view, other viewers are kept.
+type OneTransfer struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	OneId     uint `sql:"index"`
+	FromId    uint `sql:"index"`
+	ToId      uint `sql:"index"`
+	ExpiresAt time.Time
+}
+
+func (t *OneTransfer) Expired() bool { return time.Now().After(t.ExpiresAt) }
+
+// StartTransfer offers the One to another account
+func (a *Account) StartTransfer(one *One, toId uint) (*OneTransfer, error) {
+	if one.OwnerId != a.ID || one.OrgId != 0 {
+		return nil, ErrTransferOwner
+	}
+	if toId == a.ID {
+		return nil, ErrTransferSelf
+	}
+	if err := (&Account{}).Find(toId); err != nil {
+		return nil, err
+	}
+	t := &OneTransfer{OneId: one.ID, FromId: a.ID, ToId: toId, ExpiresAt: time.Now().Add(TransferTTL)}
+	if err := aservice.SaveTransfer(t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// AcceptTransfer makes a the owner of the One
+func (a *Account) AcceptTransfer(id uint) (*OneTransfer, error) {
+	t := &OneTransfer{}
+	if err := aservice.FindTransfer(t, id); err != nil {
+		return nil, err
+	}
+	if t.ToId != a.ID {
+		return nil, ErrRecordNotFound
+	}
+	if t.Expired() {
+		aservice.DeleteTransfer(t)
+		return nil, ErrTransferExpired
+	}
+	if err := aservice.AcceptTransfer(t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// CancelTransfer is used by both sides, cancel or decline.
+func (a *Account) CancelTransfer(id uint) error {
+	t := &OneTransfer{}
+	if err := aservice.FindTransfer(t, id); err != nil {
+		return err
+	}
+	if t.FromId != a.ID && t.ToId != a.ID {
+		return ErrRecordNotFound
+	}
+	return aservice.DeleteTransfer(t)
+}
+
+// Transfers returns pending transfers from or to a
+func (a *Account) Transfers(ts *[]OneTransfer) error { return aservice.AccountTransfers(a, ts) }
@@ -1,7 +1,10 @@
 package conn
 
 import (
+	"encoding/json"
 	"errors"
+	"strings"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/empirefox/ic-server-conductor/account"
@@ -35,3 +38,48 @@ func AuthWs(ws Ws, kf jwt.Keyfunc) (*jwt.Token, error) {
 	}
 	return token, nil
 }
+
+// TokenKid reads the kid header without verifying,
+// empty for api keys and tokens signed by goauth.
+func TokenKid(token []byte) string {
+	parts := strings.Split(string(token), ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	seg, err := jwt.DecodeSegment(parts[0])
+	if err != nil {
+		return ""
+	}
+	var header struct {
+		Kid string `json:"kid"`
+	}
+	json.Unmarshal(seg, &header)
+	return header.Kid
+}
+
+// TokenExp reads the exp claim without verifying, so the token must be
+// verified before. Zero time is returned when no exp claim found,
+// and for api keys which never expire.
+func TokenExp(token []byte) (time.Time, error) {
+	if account.IsApiKey(string(token)) {
+		return time.Time{}, nil
+	}
+	parts := strings.Split(string(token), ".")
+	if len(parts) != 3 {
+		return time.Time{}, ErrInvalidToken
+	}
+	seg, err := jwt.DecodeSegment(parts[1])
+	if err != nil {
+		return time.Time{}, err
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err = json.Unmarshal(seg, &claims); err != nil {
+		return time.Time{}, err
+	}
+	if claims.Exp == 0 {
+		return time.Time{}, nil
+	}
+	return time.Unix(claims.Exp, 0), nil
+}
@@ -0,0 +1,54 @@
+package conn
+
+import (
+	"encoding/json"
+
+	"github.com/empirefox/ic-server-conductor/account"
+)
+
+// FilterT2M filters ipcam parts sent by One with the allow-list of a viewer,
+// ok is false when the viewer should not get the part at all.
+// Parts of other names and unrestricted viewers are not changed.
+//
+//	IcIds:  ["id",...]
+//	Ic:     Ipcam
+//	XIc:    "id"
+//	IcIdCh: dropped, the renamed camera comes with Ic when allowed
+func FilterT2M(cs account.Cameras, k []byte, part json.RawMessage) (json.RawMessage, bool) {
+	if len(cs) == 0 {
+		return part, true
+	}
+	switch string(k) {
+	case "IcIds":
+		var ids []string
+		if err := json.Unmarshal(part, &ids); err != nil {
+			return nil, false
+		}
+		allowed := []string{}
+		for _, id := range ids {
+			if cs.Allow(id) {
+				allowed = append(allowed, id)
+			}
+		}
+		filtered, err := json.Marshal(allowed)
+		if err != nil {
+			return nil, false
+		}
+		return filtered, true
+	case "Ic":
+		var ipcam Ipcam
+		if err := json.Unmarshal(part, &ipcam); err != nil {
+			return nil, false
+		}
+		return part, cs.Allow(ipcam.Id)
+	case "XIc":
+		var id string
+		if err := json.Unmarshal(part, &id); err != nil {
+			return nil, false
+		}
+		return part, cs.Allow(id)
+	case "IcIdCh":
+		return nil, false
+	}
+	return part, true
+}
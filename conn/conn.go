@@ -2,6 +2,7 @@ package conn
 
 import (
 	"encoding/json"
+	"time"
 
 	"github.com/empirefox/ic-server-conductor/account"
 	"github.com/gorilla/websocket"
@@ -22,23 +23,53 @@ type Connection interface {
 
 type ControlUser interface {
 	Connection
+	// one account can have many sessions, ex: phone and laptop
+	SessionId() string
 	Tag() string
+	// co-viewers can see this user only when shared
+	SharePresence() bool
 	T2M(oneId uint, k []byte, part *json.RawMessage)
+	// RoomOnes are the rooms the user can view now, out of schedule excluded
 	RoomOnes() ([]account.One, error)
 	GetOauth() *account.Oauth
+	// Kick sends the reason then closes the socket
+	Kick(reason string)
+	// Jti identifies the token of the last auth, see account.TokenJti
+	Jti() string
 }
 
+// Sessions of one account, key is SessionId
+type Sessions map[string]ControlUser
+
 type ControlRoom interface {
 	Connection
 	Tag() string
 	Broadcast(msg []byte)
 	BroadcastT2M(k []byte, part json.RawMessage)
+	// Friends are the viewers who can view the room now
 	Friends() ([]account.Account, error)
 	AddOnline(id uint, cu ControlUser, tag string)
-	GetOnline(id uint) (ControlUser, bool)
-	RemoveOnline(id uint)
+	GetOnline(id uint) (Sessions, bool)
+	RemoveOnline(id uint, cu ControlUser)
+	AddSignaling(s *Signaling)
+	// AddGuestSignaling adds s when the guest link has less than max signalings
+	AddGuestSignaling(s *Signaling, max int) bool
+	RemoveSignaling(reciever string)
+	// EndSignalings stops the matched signalings
+	EndSignalings(match func(s *Signaling) bool)
+	// all viewers for owner, only shared viewers for others
+	Presence(forAccount uint) *Presence
 	GetOne() *account.One
 	Remove()
+	// RotateToken sends new token to One, old token will be revoked
+	RotateToken() error
+	// Transferred reloads the owner and sends a token for the new owner
+	Transferred() error
+	// Disable disconnects the room, One cannot login until enabled.
+	// Only hub calls it, use Hub.OnDisable.
+	Disable()
+	// SetCameras updates the allow-list of an online viewer
+	SetCameras(accountId uint, cs account.Cameras)
 }
 
 type Hub interface {
@@ -51,9 +82,17 @@ type Hub interface {
 	OnMsg(msg *Message)
 	OnJoin(many ControlUser)
 	OnLeave(many ControlUser)
+	OnKick(kick *Kick)
+	// OnTransfer updates the online room and notifies both accounts
+	OnTransfer(t *Transfer)
+	// OnDisable disconnects the online room
+	OnDisable(room uint)
 
 	WaitForProcess(reciever string) (chan *websocket.Conn, error)
 	ProcessFromWait(reciever string) (chan *websocket.Conn, error)
 	NewInviteCode(room uint) string
 	ValidateInviteCode(room uint, code string) bool
+	// pairing code is used once to reg a One for the account
+	NewPairCode(accountId uint) (code string, exp time.Time)
+	ConsumePairCode(code string) (accountId uint, ok bool)
 }
@@ -0,0 +1,79 @@
+package conn
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/empirefox/ic-server-conductor/account"
+)
+
+var ErrRoomDisabledBySys = errors.New("Room disabled by sys")
+
+// Flags are shared by admin and owner api, except that a room disabled
+// by admin can only be enabled by admin.
+// Live connections are closed at once when disabled.
+
+// SetRoomEnabled is used by the owner, the online room is disconnected when disabled.
+func SetRoomEnabled(h Hub, o *account.Oauth, one *account.One, enabled bool) (int, ErrorCode, error) {
+	if status, code, err := NoApiKey(o); err != nil {
+		return status, code, err
+	}
+	if enabled && one.DisabledBySys {
+		return http.StatusForbidden, ErrCodeNotPermitted, ErrRoomDisabledBySys
+	}
+	one.Enabled = enabled
+	return saveEnabled(h, one)
+}
+
+// SetSysRoomEnabled is used by admin, it also locks or unlocks the owner flag.
+func SetSysRoomEnabled(h Hub, one *account.One, enabled bool) (int, ErrorCode, error) {
+	one.Enabled = enabled
+	one.DisabledBySys = !enabled
+	return saveEnabled(h, one)
+}
+
+func saveEnabled(h Hub, one *account.One) (int, ErrorCode, error) {
+	if err := one.Save(); err != nil {
+		return http.StatusInternalServerError, ErrCodeDbError, err
+	}
+	disableRoom(h, one)
+	return http.StatusOK, "", nil
+}
+
+func disableRoom(h Hub, one *account.One) {
+	if !one.Usable() {
+		h.OnDisable(one.ID)
+	}
+}
+
+// SetAccountEnabled kicks all sessions and signalings of the account when disabled.
+func SetAccountEnabled(h Hub, id uint, enabled bool) (int, ErrorCode, error) {
+	a := &account.Account{ID: id}
+	switch err := a.SetEnabled(enabled); err {
+	case nil:
+	case account.ErrRecordNotFound:
+		return http.StatusNotFound, ErrCodeNotFound, err
+	default:
+		return http.StatusInternalServerError, ErrCodeDbError, err
+	}
+	if !enabled {
+		h.OnKick(&Kick{AccountId: id, Reason: "Disabled", Signalings: true})
+	}
+	return http.StatusOK, "", nil
+}
+
+// SetOauthEnabled kicks sessions logged in by the oauth and their signalings when disabled.
+func SetOauthEnabled(h Hub, id uint, enabled bool) (*account.Oauth, int, ErrorCode, error) {
+	o := &account.Oauth{}
+	switch err := o.SetEnabled(id, enabled); err {
+	case nil:
+	case account.ErrRecordNotFound:
+		return nil, http.StatusNotFound, ErrCodeNotFound, err
+	default:
+		return nil, http.StatusInternalServerError, ErrCodeDbError, err
+	}
+	if !enabled {
+		h.OnKick(&Kick{AccountId: o.AccountId, Provider: o.Provider, Reason: "Disabled", Signalings: true})
+	}
+	return o, http.StatusOK, "", nil
+}
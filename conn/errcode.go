@@ -0,0 +1,74 @@
+package conn
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Stable error codes shared by websocket replies and rest api.
+// Clients should switch on Code, Content is only for human.
+type ErrorCode string
+
+const (
+	ErrCodeBadRequest     ErrorCode = "bad_request"
+	ErrCodeBadToken       ErrorCode = "bad_token"
+	ErrCodeNotOwner       ErrorCode = "not_owner"
+	ErrCodeNotPermitted   ErrorCode = "not_permitted"
+	ErrCodeNotFound       ErrorCode = "not_found"
+	ErrCodeRoomOffline    ErrorCode = "room_offline"
+	ErrCodeDbError        ErrorCode = "db_error"
+	ErrCodeUnknownCommand ErrorCode = "unknown_command"
+	ErrCodeBadInviteCode  ErrorCode = "bad_invite_code"
+	ErrCodeInternal       ErrorCode = "internal_error"
+	ErrCodeTotpRequired   ErrorCode = "totp_required"
+	ErrCodeBadTotp        ErrorCode = "bad_totp"
+	ErrCodeTotpLocked     ErrorCode = "totp_locked"
+)
+
+// ErrorReply is the only error format sent to clients.
+// Many clients dispatch by Type, One clients dispatch by Name.
+type ErrorReply struct {
+	Type    string    `json:"type,omitempty"`
+	Name    string    `json:"name,omitempty"`
+	Error   int       `json:"error"`
+	Code    ErrorCode `json:"code"`
+	Content string    `json:"content,omitempty"`
+
+	// validation errors by field name
+	Fields map[string][]string `json:"fields,omitempty"`
+}
+
+func (e *ErrorReply) Bytes() []byte {
+	b, _ := json.Marshal(e)
+	return b
+}
+
+// ManyError is sent to many clients as {"type":"Error",...}
+func ManyError(code ErrorCode, content string) []byte {
+	return (&ErrorReply{Type: "Error", Error: 1, Code: code, Content: content}).Bytes()
+}
+
+// ManyFieldsError is sent to many clients when tagjson validation failed.
+func ManyFieldsError(fields map[string][]string) []byte {
+	return (&ErrorReply{Type: "Error", Error: 1, Code: ErrCodeBadRequest, Fields: fields}).Bytes()
+}
+
+// OneError keeps the name based protocol of One clients,
+// ex: {"name":"BadRegToken","error":1,"code":"bad_token"}
+func OneError(name string, code ErrorCode) []byte {
+	return (&ErrorReply{Name: name, Error: 1, Code: code}).Bytes()
+}
+
+// AbortWithCode is used by rest api, same body format as websocket.
+func AbortWithCode(c *gin.Context, status int, code ErrorCode, content string) {
+	c.JSON(status, &ErrorReply{Error: 1, Code: code, Content: content})
+	c.Abort()
+}
+
+// AbortWithFields is used by rest api when tagjson validation failed.
+func AbortWithFields(c *gin.Context, fields map[string][]string) {
+	c.JSON(http.StatusBadRequest, &ErrorReply{Error: 1, Code: ErrCodeBadRequest, Fields: fields})
+	c.Abort()
+}
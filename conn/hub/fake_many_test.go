@@ -1,6 +1,10 @@
 package hub
 
-import "github.com/empirefox/ic-server-conductor/account"
+import (
+	"encoding/json"
+
+	"github.com/empirefox/ic-server-conductor/account"
+)
 
 func newFakeDbOauth() *account.Oauth {
 	o := &account.Oauth{}
@@ -9,11 +13,21 @@ func newFakeDbOauth() *account.Oauth {
 
 type fakeMany struct {
 	fakeConn
+	sid             string
 	ipcamsSentTimes int
 	ones            []account.One
 	oauth           *account.Oauth
+	kicked          []string
+	jti             string
 }
 
-func (many *fakeMany) SendIpcams()                      { many.ipcamsSentTimes++ }
-func (many *fakeMany) RoomOnes() ([]account.One, error) { return many.ones, nil }
-func (many *fakeMany) GetOauth() *account.Oauth         { return many.oauth }
+func (many *fakeMany) SessionId() string                               { return many.sid }
+func (many *fakeMany) SharePresence() bool                             { return false }
+func (many *fakeMany) Tag() string                                     { return "user" }
+func (many *fakeMany) T2M(oneId uint, k []byte, part *json.RawMessage) {}
+func (many *fakeMany) SendIpcams()                                     { many.ipcamsSentTimes++ }
+func (many *fakeMany) RoomOnes() ([]account.One, error)                { return many.ones, nil }
+func (many *fakeMany) GetOauth() *account.Oauth                        { return many.oauth }
+func (many *fakeMany) Kick(reason string)                              { many.kicked = append(many.kicked, reason) }
+
+func (many *fakeMany) Jti() string { return many.jti }
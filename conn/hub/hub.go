@@ -3,6 +3,7 @@ package hub
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"sync"
 	"time"
 
@@ -10,6 +11,7 @@ import (
 	"github.com/golang/glog"
 	"github.com/gorilla/websocket"
 
+	"github.com/empirefox/ic-server-conductor/account"
 	. "github.com/empirefox/ic-server-conductor/conn"
 	"github.com/empirefox/ic-server-conductor/utils"
 )
@@ -19,47 +21,70 @@ var (
 	RecieverDuplicated = errors.New("Reciever duplicated")
 )
 
+var (
+	PairCodeTTL = 10 * time.Minute
+	// viewers are removed from rooms in a period after their schedules closed
+	SweepPeriod = time.Minute
+	// no 0/O/1/I, code is typed on the One
+	pairCodeChars = []byte("ABCDEFGHJKLMNPQRSTUVWXYZ23456789")
+)
+
 type hub struct {
 	rooms         map[uint]ControlRoom
-	clients       map[uint]ControlUser
+	clients       map[uint]Sessions
 	msg           chan *Message
 	cmd           chan *Command
 	reg           chan ControlRoom
 	unreg         chan ControlRoom
 	join          chan ControlUser
 	leave         chan ControlUser
+	kick          chan *Kick
+	transfer      chan *Transfer
+	disable       chan uint
+	views         chan map[uint]map[uint]bool
+	sweeping      bool
 	sigResWaitMap map[string]chan *websocket.Conn
 	sigResMutex   sync.Mutex
 	inviteCodes   map[uint]codes
 	inviteMutex   sync.Mutex
+	pairCodes     map[string]uint
+	pairMutex     sync.Mutex
 	tokenSecret   []byte
 }
 
 func NewHub() Hub {
 	return &hub{
 		rooms:         make(map[uint]ControlRoom),
-		clients:       make(map[uint]ControlUser),
+		clients:       make(map[uint]Sessions),
 		msg:           make(chan *Message, 64),
 		cmd:           make(chan *Command, 64),
 		reg:           make(chan ControlRoom, 64),
 		unreg:         make(chan ControlRoom, 64),
 		join:          make(chan ControlUser, 64),
 		leave:         make(chan ControlUser, 64),
+		kick:          make(chan *Kick, 64),
+		transfer:      make(chan *Transfer, 64),
+		disable:       make(chan uint, 64),
+		views:         make(chan map[uint]map[uint]bool, 1),
 		sigResWaitMap: make(map[string]chan *websocket.Conn),
 		sigResMutex:   sync.Mutex{},
 		inviteCodes:   make(map[uint]codes),
 		inviteMutex:   sync.Mutex{},
+		pairCodes:     make(map[string]uint),
+		pairMutex:     sync.Mutex{},
 		tokenSecret:   []byte(uniuri.New()),
 	}
 }
 
 func (h *hub) Run() {
+	sweep := time.NewTicker(SweepPeriod)
+	defer sweep.Stop()
 	for {
-		h.run()
+		h.run(sweep.C)
 	}
 }
 
-func (h *hub) run() {
+func (h *hub) run(sweep <-chan time.Time) {
 	defer func() {
 		if err := recover(); err != nil {
 			glog.Errorln(err)
@@ -83,6 +108,22 @@ func (h *hub) run() {
 
 	case many := <-h.leave:
 		h.onLeave(many)
+
+	case kick := <-h.kick:
+		h.onKick(kick)
+
+	case t := <-h.transfer:
+		h.onTransfer(t)
+
+	case id := <-h.disable:
+		h.onDisable(id)
+
+	case <-sweep:
+		h.sweepViews()
+
+	case active := <-h.views:
+		h.sweeping = false
+		h.onViews(active)
 	}
 }
 
@@ -95,7 +136,7 @@ func (h *hub) onReg(room ControlRoom) {
 		return
 	}
 	for _, friend := range friends {
-		if many, ok := h.clients[friend.ID]; ok {
+		for _, many := range h.clients[friend.ID] {
 			room.AddOnline(friend.ID, many, room.Tag())
 		}
 	}
@@ -138,7 +179,12 @@ func (h *hub) onCmd(cmd *Command) {
 
 func (h *hub) OnJoin(many ControlUser) { h.join <- many }
 func (h *hub) onJoin(many ControlUser) {
-	h.clients[many.Id()] = many
+	ss, ok := h.clients[many.Id()]
+	if !ok {
+		ss = make(Sessions)
+		h.clients[many.Id()] = ss
+	}
+	ss[many.SessionId()] = many
 	ones, err := many.RoomOnes()
 	if err != nil {
 		return
@@ -155,14 +201,150 @@ func (h *hub) onLeave(many ControlUser) {
 	if many.GetOauth() == nil {
 		return
 	}
-	delete(h.clients, many.Id())
-	ones, err := many.RoomOnes()
+	if ss, ok := h.clients[many.Id()]; ok {
+		delete(ss, many.SessionId())
+		if len(ss) == 0 {
+			delete(h.clients, many.Id())
+		}
+	}
+	// not by RoomOnes, the schedule may be closed after joined
+	for _, room := range h.rooms {
+		if ss, ok := room.GetOnline(many.Id()); ok && ss[many.SessionId()] != nil {
+			room.RemoveOnline(many.Id(), many)
+		}
+	}
+}
+
+func (h *hub) OnKick(kick *Kick) { h.kick <- kick }
+func (h *hub) onKick(kick *Kick) {
+	jtis := make(map[string]bool)
+	if kick.Jti != "" {
+		jtis[kick.Jti] = true
+	}
+	for _, many := range h.clients[kick.AccountId] {
+		if kick.Provider != "" && many.GetOauth().Provider != kick.Provider {
+			continue
+		}
+		if kick.Jti != "" && many.Jti() != kick.Jti {
+			continue
+		}
+		if kick.Signalings && many.Jti() != "" {
+			jtis[many.Jti()] = true
+		}
+		many.Kick(kick.Reason)
+	}
+	byAccount := kick.Signalings && kick.Provider == "" && kick.Jti == ""
+	if !byAccount && len(jtis) == 0 {
+		return
+	}
+	for _, room := range h.rooms {
+		room.EndSignalings(func(s *Signaling) bool {
+			return byAccount && s.AccountId == kick.AccountId || jtis[s.Jti]
+		})
+	}
+}
+
+func (h *hub) OnTransfer(t *Transfer) { h.transfer <- t }
+func (h *hub) onTransfer(t *Transfer) {
+	if room, ok := h.rooms[t.Room]; ok {
+		for _, many := range h.clients[t.From] {
+			room.RemoveOnline(t.From, many)
+		}
+		for _, many := range h.clients[t.To] {
+			room.AddOnline(t.To, many, many.Tag())
+		}
+		// db io and the send to One must not block hub
+		go func() {
+			if err := room.Transferred(); err != nil {
+				glog.Errorln(err)
+			}
+		}()
+	}
+	msg, err := utils.GetTypedMsg("RoomTransferred", t)
 	if err != nil {
+		glog.Errorln(err)
 		return
 	}
-	for _, one := range ones {
-		if room, ok := h.rooms[one.ID]; ok {
-			room.RemoveOnline(many.Id())
+	for _, id := range []uint{t.From, t.To} {
+		for _, many := range h.clients[id] {
+			many.Send(msg)
+		}
+	}
+}
+
+func (h *hub) OnDisable(id uint) { h.disable <- id }
+func (h *hub) onDisable(id uint) {
+	if room, ok := h.rooms[id]; ok {
+		delete(h.rooms, id)
+		room.Disable()
+	}
+}
+
+// sweepViews ends signalings of expired guest links, then loads the rooms
+// every online account can view now, the results are posted back to the
+// hub goroutine, see onViews.
+func (h *hub) sweepViews() {
+	now := time.Now()
+	for _, room := range h.rooms {
+		room.EndSignalings(func(s *Signaling) bool { return !s.ExpiresAt.IsZero() && now.After(s.ExpiresAt) })
+	}
+	if h.sweeping {
+		return
+	}
+	h.sweeping = true
+	ids := make([]uint, 0, len(h.clients))
+	for id := range h.clients {
+		ids = append(ids, id)
+	}
+	go func() { h.views <- activeViews(ids) }()
+}
+
+// activeViews does db io, never call it on the hub goroutine.
+func activeViews(ids []uint) map[uint]map[uint]bool {
+	active := make(map[uint]map[uint]bool, len(ids))
+	for _, id := range ids {
+		a := &account.Account{ID: id}
+		if err := a.GetActiveOnes(); err != nil {
+			glog.Errorln("Load active views:", err)
+			continue
+		}
+		rooms := make(map[uint]bool, len(a.Ones))
+		for _, one := range a.Ones {
+			rooms[one.ID] = true
+		}
+		active[id] = rooms
+	}
+	return active
+}
+
+// onViews removes online viewers who cannot view the room any more,
+// such as when the schedule closed, and ends their signalings.
+// Viewers are added again when the schedule opened.
+func (h *hub) onViews(active map[uint]map[uint]bool) {
+	for id, rooms := range active {
+		ss, ok := h.clients[id]
+		if !ok {
+			continue
+		}
+		for roomId, room := range h.rooms {
+			online, isOnline := room.GetOnline(id)
+			if rooms[roomId] {
+				for sid, many := range ss {
+					if _, added := online[sid]; !added {
+						room.AddOnline(id, many, many.Tag())
+					}
+				}
+				continue
+			}
+			if !isOnline {
+				continue
+			}
+			room.EndSignalings(func(s *Signaling) bool { return s.AccountId == id })
+			msg := []byte(fmt.Sprintf(`{"type":"ViewClosed","ID":%d}`, roomId))
+			for _, many := range ss {
+				room.RemoveOnline(id, many)
+				many.Send(msg)
+			}
 		}
 	}
 }
@@ -242,3 +424,35 @@ func (h *hub) NewInviteCode(room uint) string {
 	go h.waitForStop(cs, code, stop)
 	return code
 }
+
+// need lock
+func (h *hub) genPairCode() string {
+	code := uniuri.NewLenChars(8, pairCodeChars)
+	if _, ok := h.pairCodes[code]; ok {
+		return h.genPairCode()
+	}
+	return code
+}
+
+func (h *hub) NewPairCode(accountId uint) (string, time.Time) {
+	h.pairMutex.Lock()
+	defer h.pairMutex.Unlock()
+	code := h.genPairCode()
+	h.pairCodes[code] = accountId
+	time.AfterFunc(PairCodeTTL, func() {
+		h.pairMutex.Lock()
+		defer h.pairMutex.Unlock()
+		delete(h.pairCodes, code)
+	})
+	return code, time.Now().Add(PairCodeTTL)
+}
+
+func (h *hub) ConsumePairCode(code string) (uint, bool) {
+	h.pairMutex.Lock()
+	defer h.pairMutex.Unlock()
+	accountId, ok := h.pairCodes[code]
+	if ok {
+		delete(h.pairCodes, code)
+	}
+	return accountId, ok
+}
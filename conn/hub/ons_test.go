@@ -2,14 +2,16 @@ package hub
 
 import (
 	"testing"
+	"time"
 
 	"github.com/empirefox/ic-server-conductor/account"
+	"github.com/empirefox/ic-server-conductor/account/accounttest"
 	. "github.com/empirefox/ic-server-conductor/conn"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
-func newFakeClient(id uint) ControlUser {
-	return &fakeMany{fakeConn: fakeConn{id: id}}
+func newFakeClient(id uint, sid string) ControlUser {
+	return &fakeMany{fakeConn: fakeConn{id: id}, sid: sid}
 }
 
 func Test__reg(t *testing.T) {
@@ -17,9 +19,9 @@ func Test__reg(t *testing.T) {
 		h := NewHub().(*hub)
 
 		// user 601/602 already online
-		h.clients = map[uint]ControlUser{
-			601: newFakeClient(601),
-			602: newFakeClient(602),
+		h.clients = map[uint]Sessions{
+			601: {"s1": newFakeClient(601, "s1"), "s2": newFakeClient(601, "s2")},
+			602: {"s1": newFakeClient(602, "s1")},
 		}
 
 		// this room only know 601/603
@@ -29,7 +31,7 @@ func Test__reg(t *testing.T) {
 				newFakeFriend(601),
 				newFakeFriend(603),
 			},
-			onlines: make(map[uint]ControlUser),
+			onlines: make(map[uint]Sessions),
 			one:     newFakeDbOne(101),
 		}
 
@@ -37,9 +39,9 @@ func Test__reg(t *testing.T) {
 		h.onReg(room)
 		// room reg ok
 		So(h.rooms[101], ShouldNotBeNil)
-		// online friends added ok
+		// online friends added ok, with all sessions
 		So(len(room.onlines), ShouldEqual, 1)
-		So(room.onlines[601], ShouldNotBeNil)
+		So(len(room.onlines[601]), ShouldEqual, 2)
 
 		// unreg
 		h.onUnreg(room)
@@ -59,13 +61,14 @@ func Test__join_leave(t *testing.T) {
 				newFakeFriend(601),
 				newFakeFriend(603),
 			},
-			onlines: make(map[uint]ControlUser),
+			onlines: make(map[uint]Sessions),
 			one:     newFakeDbOne(101),
 		}
 		h.rooms[101] = room
 
 		many := &fakeMany{
 			fakeConn: fakeConn{id: 601},
+			sid:      "s1",
 			ones:     []account.One{*newFakeDbOne(101), *newFakeDbOne(102)},
 			oauth:    newFakeDbOauth(),
 		}
@@ -88,3 +91,283 @@ func Test__join_leave(t *testing.T) {
 		So(len(room.onlines), ShouldEqual, 0)
 	})
 }
+
+func Test__kick(t *testing.T) {
+	Convey("onKick should kick by account and provider", t, func() {
+		h := NewHub().(*hub)
+
+		o := newFakeDbOauth()
+		o.Provider = "github"
+		many := &fakeMany{
+			fakeConn: fakeConn{id: 601},
+			sid:      "s1",
+			oauth:    o,
+		}
+		h.clients[601] = Sessions{"s1": many}
+
+		// other provider
+		h.onKick(&Kick{AccountId: 601, Provider: "google", Reason: "Unlink"})
+		So(len(many.kicked), ShouldEqual, 0)
+
+		// other account
+		h.onKick(&Kick{AccountId: 602, Reason: "Logoff"})
+		So(len(many.kicked), ShouldEqual, 0)
+
+		h.onKick(&Kick{AccountId: 601, Provider: "github", Reason: "Unlink"})
+		So(many.kicked, ShouldResemble, []string{"Unlink"})
+
+		h.onKick(&Kick{AccountId: 601, Reason: "Logoff"})
+		So(many.kicked, ShouldResemble, []string{"Unlink", "Logoff"})
+	})
+}
+
+func Test__kickJti(t *testing.T) {
+	Convey("onKick should kick sockets and signalings of the token", t, func() {
+		h := NewHub().(*hub)
+		room := &fakeRoom{fakeConn: fakeConn{id: 701}}
+		h.rooms[701] = room
+
+		s1 := &fakeMany{fakeConn: fakeConn{id: 601}, sid: "s1", oauth: newFakeDbOauth(), jti: "j1"}
+		s2 := &fakeMany{fakeConn: fakeConn{id: 601}, sid: "s2", oauth: newFakeDbOauth(), jti: "j2"}
+		h.clients[601] = Sessions{"s1": s1, "s2": s2}
+
+		h.onKick(&Kick{AccountId: 601, Jti: "j1", Reason: "SessionRevoked"})
+		So(s1.kicked, ShouldResemble, []string{"SessionRevoked"})
+		So(len(s2.kicked), ShouldEqual, 0)
+		So(len(room.ended), ShouldEqual, 1)
+		So(room.ended[0](&Signaling{Jti: "j1"}), ShouldBeTrue)
+		So(room.ended[0](&Signaling{Jti: "j2"}), ShouldBeFalse)
+
+		// no signalings ended when kicked by account
+		h.onKick(&Kick{AccountId: 601, Reason: "Logoff"})
+		So(len(room.ended), ShouldEqual, 1)
+	})
+}
+
+func Test__kickSignalings(t *testing.T) {
+	Convey("onKick should end signalings of the disabled account", t, func() {
+		h := NewHub().(*hub)
+		room := &fakeRoom{fakeConn: fakeConn{id: 701}}
+		h.rooms[701] = room
+
+		o := newFakeDbOauth()
+		o.Provider = "github"
+		s1 := &fakeMany{fakeConn: fakeConn{id: 601}, sid: "s1", oauth: o, jti: "j1"}
+		h.clients[601] = Sessions{"s1": s1}
+
+		h.onKick(&Kick{AccountId: 601, Provider: "github", Reason: "Disabled", Signalings: true})
+		So(s1.kicked, ShouldResemble, []string{"Disabled"})
+		So(len(room.ended), ShouldEqual, 1)
+		So(room.ended[0](&Signaling{AccountId: 601, Jti: "j1"}), ShouldBeTrue)
+		So(room.ended[0](&Signaling{AccountId: 601, Jti: "j2"}), ShouldBeFalse)
+
+		h.onKick(&Kick{AccountId: 601, Reason: "Disabled", Signalings: true})
+		So(len(room.ended), ShouldEqual, 2)
+		So(room.ended[1](&Signaling{AccountId: 601, Jti: "j2"}), ShouldBeTrue)
+		So(room.ended[1](&Signaling{AccountId: 602}), ShouldBeFalse)
+		So(room.ended[1](&Signaling{GuestId: 1}), ShouldBeFalse)
+	})
+}
+
+func Test__sweepGuests(t *testing.T) {
+	Convey("sweepViews should end signalings of expired guest links", t, func() {
+		h := NewHub().(*hub)
+		room := &fakeRoom{fakeConn: fakeConn{id: 101}, one: newFakeDbOne(101)}
+		h.rooms[101] = room
+		h.sweeping = true
+
+		h.sweepViews()
+		So(len(room.ended), ShouldEqual, 1)
+		So(room.ended[0](&Signaling{GuestId: 1, ExpiresAt: time.Now().Add(-time.Minute)}), ShouldBeTrue)
+		So(room.ended[0](&Signaling{GuestId: 1, ExpiresAt: time.Now().Add(time.Minute)}), ShouldBeFalse)
+		So(room.ended[0](&Signaling{AccountId: 601}), ShouldBeFalse)
+	})
+}
+
+func Test__disable(t *testing.T) {
+	Convey("onDisable should remove and disable the online room", t, func() {
+		h := NewHub().(*hub)
+		room := &fakeRoom{fakeConn: fakeConn{id: 101}, one: newFakeDbOne(101)}
+		h.rooms[101] = room
+
+		h.onDisable(102)
+		So(room.disabled, ShouldBeFalse)
+
+		h.onDisable(101)
+		So(room.disabled, ShouldBeTrue)
+		_, ok := h.GetRoom(101)
+		So(ok, ShouldBeFalse)
+	})
+}
+
+func Test__transfer(t *testing.T) {
+	Convey("onTransfer should move sessions and notify both accounts", t, func() {
+		h := NewHub().(*hub)
+		from := &fakeMany{fakeConn: fakeConn{id: 601}, sid: "s1"}
+		to := &fakeMany{fakeConn: fakeConn{id: 602}, sid: "s1"}
+		h.clients[601] = Sessions{"s1": from}
+		h.clients[602] = Sessions{"s1": to}
+
+		room := &fakeRoom{
+			fakeConn:    fakeConn{id: 101},
+			onlines:     map[uint]Sessions{601: {"s1": from}},
+			one:         newFakeDbOne(101),
+			transferred: make(chan bool, 1),
+		}
+		h.rooms[101] = room
+
+		h.onTransfer(&Transfer{Room: 101, From: 601, To: 602})
+		select {
+		case <-room.transferred:
+		case <-time.After(time.Second):
+			So("room not transferred", ShouldBeEmpty)
+		}
+		So(room.onlines[601], ShouldBeNil)
+		So(len(room.onlines[602]), ShouldEqual, 1)
+		So(string(from.dataSent), ShouldContainSubstring, "RoomTransferred")
+		So(string(to.dataSent), ShouldContainSubstring, "RoomTransferred")
+	})
+}
+
+func Test__pair_code(t *testing.T) {
+	Convey("pair code should be used only once", t, func() {
+		h := NewHub().(*hub)
+
+		code, exp := h.NewPairCode(601)
+		So(len(code), ShouldEqual, 8)
+		So(exp.After(time.Now()), ShouldBeTrue)
+
+		_, ok := h.ConsumePairCode("NOTEXIST")
+		So(ok, ShouldBeFalse)
+
+		id, ok := h.ConsumePairCode(code)
+		So(ok, ShouldBeTrue)
+		So(id, ShouldEqual, 601)
+
+		_, ok = h.ConsumePairCode(code)
+		So(ok, ShouldBeFalse)
+	})
+}
+
+func Test__multi_session(t *testing.T) {
+	Convey("sessions of one account should join/leave separately", t, func() {
+		h := NewHub().(*hub)
+
+		room := &fakeRoom{
+			fakeConn: fakeConn{id: 101},
+			onlines:  make(map[uint]Sessions),
+			one:      newFakeDbOne(101),
+		}
+		h.rooms[101] = room
+
+		newSession := func(sid string) *fakeMany {
+			return &fakeMany{
+				fakeConn: fakeConn{id: 601},
+				sid:      sid,
+				ones:     []account.One{*newFakeDbOne(101)},
+				oauth:    newFakeDbOauth(),
+			}
+		}
+		phone := newSession("phone")
+		laptop := newSession("laptop")
+
+		h.onJoin(phone)
+		h.onJoin(laptop)
+		So(len(h.clients[601]), ShouldEqual, 2)
+		So(len(room.onlines[601]), ShouldEqual, 2)
+
+		Convey("first leave should keep the other", func() {
+			h.onLeave(phone)
+			So(len(h.clients[601]), ShouldEqual, 1)
+			So(h.clients[601]["laptop"], ShouldEqual, laptop)
+			So(len(room.onlines[601]), ShouldEqual, 1)
+
+			h.onLeave(laptop)
+			So(len(h.clients), ShouldEqual, 0)
+			So(len(room.onlines), ShouldEqual, 0)
+		})
+
+		Convey("rejoin after leave should work", func() {
+			h.onLeave(laptop)
+			h.onJoin(laptop)
+			h.onLeave(phone)
+			So(len(h.clients[601]), ShouldEqual, 1)
+			So(room.onlines[601]["laptop"], ShouldEqual, laptop)
+		})
+
+		Convey("kick should reach every session", func() {
+			h.onKick(&Kick{AccountId: 601, Reason: "Logoff"})
+			So(phone.kicked, ShouldResemble, []string{"Logoff"})
+			So(laptop.kicked, ShouldResemble, []string{"Logoff"})
+		})
+	})
+}
+
+func Test__sweepViews(t *testing.T) {
+	Convey("sweepViews should remove viewers out of schedule and add them back", t, func() {
+		owner := accounttest.Setup()
+		viewer := accounttest.Login("viewer")
+		h := NewHub().(*hub)
+		one := &account.One{Name: "room", Addr: "addr"}
+		So(owner.Account.RegOne(one), ShouldBeNil)
+		So(viewer.Account.ViewOne(one), ShouldBeNil)
+
+		room := &fakeRoom{
+			fakeConn: fakeConn{id: one.ID},
+			onlines:  make(map[uint]Sessions),
+			one:      one,
+		}
+		h.rooms[one.ID] = room
+		join := func(o *account.Oauth) *fakeMany {
+			many := &fakeMany{
+				fakeConn: fakeConn{id: o.AccountId},
+				sid:      "s1",
+				ones:     []account.One{*one},
+				oauth:    o,
+			}
+			h.onJoin(many)
+			return many
+		}
+		ownerMany := join(owner)
+		viewerMany := join(viewer)
+		// loads off the hub goroutine, then posts back
+		sweep := func() {
+			h.sweepViews()
+			So(h.sweeping, ShouldBeTrue)
+			select {
+			case active := <-h.views:
+				h.sweeping = false
+				h.onViews(active)
+			case <-time.After(time.Second):
+				So("views not loaded", ShouldBeEmpty)
+			}
+		}
+
+		sweep()
+		So(len(room.onlines), ShouldEqual, 2)
+
+		expired := time.Now().Add(-time.Minute)
+		So(one.SetViewSchedule(viewer.AccountId, nil, &expired), ShouldBeNil)
+		sweep()
+		So(room.onlines[owner.AccountId]["s1"], ShouldEqual, ownerMany)
+		_, ok := room.onlines[viewer.AccountId]
+		So(ok, ShouldBeFalse)
+		// after the guest links ended by both sweeps
+		So(len(room.ended), ShouldEqual, 3)
+		So(room.ended[2](&Signaling{AccountId: viewer.AccountId}), ShouldBeTrue)
+		So(room.ended[2](&Signaling{AccountId: owner.AccountId}), ShouldBeFalse)
+		So(string(viewerMany.dataSent), ShouldContainSubstring, "ViewClosed")
+
+		// added again when the schedule opened
+		So(one.SetViewSchedule(viewer.AccountId, nil, nil), ShouldBeNil)
+		sweep()
+		So(room.onlines[viewer.AccountId]["s1"], ShouldEqual, viewerMany)
+		So(len(room.onlines[owner.AccountId]), ShouldEqual, 1)
+
+		// leave removes the session out of schedule too
+		So(one.SetViewSchedule(viewer.AccountId, nil, &expired), ShouldBeNil)
+		h.onLeave(viewerMany)
+		_, ok = room.onlines[viewer.AccountId]
+		So(ok, ShouldBeFalse)
+	})
+}
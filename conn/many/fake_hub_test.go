@@ -2,6 +2,7 @@ package many
 
 import (
 	"encoding/json"
+	"time"
 
 	. "github.com/empirefox/ic-server-conductor/conn"
 	"github.com/empirefox/ic-server-conductor/utils"
@@ -11,7 +12,7 @@ import (
 
 type fakeHub struct {
 	rooms   map[uint]ControlRoom
-	clients map[uint]ControlUser
+	clients map[uint]Sessions
 }
 
 func (h *fakeHub) Run() {}
@@ -31,8 +32,8 @@ func (h *fakeHub) OnReg(room ControlRoom) {
 		return
 	}
 	for _, friend := range friends {
-		if many, ok := h.clients[friend.ID]; ok {
-			room.AddOnline(friend.ID, many)
+		for _, many := range h.clients[friend.ID] {
+			room.AddOnline(friend.ID, many, room.Tag())
 		}
 	}
 }
@@ -66,14 +67,19 @@ func (h *fakeHub) OnMsg(msg *Message) {
 	}
 }
 func (h *fakeHub) OnJoin(many ControlUser) {
-	h.clients[many.Id()] = many
+	ss, ok := h.clients[many.Id()]
+	if !ok {
+		ss = make(Sessions)
+		h.clients[many.Id()] = ss
+	}
+	ss[many.SessionId()] = many
 	ones, err := many.RoomOnes()
 	if err != nil {
 		return
 	}
 	for _, one := range ones {
 		if room, ok := h.rooms[one.ID]; ok {
-			room.AddOnline(many.Id(), many)
+			room.AddOnline(many.Id(), many, many.Tag())
 		}
 	}
 }
@@ -81,19 +87,35 @@ func (h *fakeHub) OnLeave(many ControlUser) {
 	if many.GetOauth() == nil {
 		return
 	}
-	delete(h.clients, many.Id())
+	if ss, ok := h.clients[many.Id()]; ok {
+		delete(ss, many.SessionId())
+		if len(ss) == 0 {
+			delete(h.clients, many.Id())
+		}
+	}
 	ones, err := many.RoomOnes()
 	if err != nil {
 		return
 	}
 	for _, one := range ones {
 		if room, ok := h.rooms[one.ID]; ok {
-			room.RemoveOnline(many.Id())
+			room.RemoveOnline(many.Id(), many)
 		}
 	}
 }
 
+func (h *fakeHub) OnKick(kick *Kick) {
+	for _, many := range h.clients[kick.AccountId] {
+		many.Kick(kick.Reason)
+	}
+}
+
+func (h *fakeHub) OnTransfer(t *Transfer) {}
+func (h *fakeHub) OnDisable(room uint)    {}
+
 func (h *fakeHub) WaitForProcess(reciever string) (chan *websocket.Conn, error)  { return nil, nil }
 func (h *fakeHub) ProcessFromWait(reciever string) (chan *websocket.Conn, error) { return nil, nil }
 func (h *fakeHub) NewInviteCode(room uint) string                                { return "" }
 func (h *fakeHub) ValidateInviteCode(room uint, code string) bool                { return false }
+func (h *fakeHub) NewPairCode(accountId uint) (string, time.Time)                { return "", time.Time{} }
+func (h *fakeHub) ConsumePairCode(code string) (uint, bool)                      { return 0, false }
@@ -31,26 +31,44 @@ type fakeRoom struct {
 	dataBroadcasted []byte
 	ipcams          Ipcams
 	friends         []account.Account
-	onlines         map[uint]ControlUser
+	onlines         map[uint]Sessions
 	one             *account.One
 }
 
-func (room *fakeRoom) Broadcast(msg []byte)                { room.dataBroadcasted = msg }
-func (room *fakeRoom) Ipcams() Ipcams                      { return room.ipcams }
-func (room *fakeRoom) Friends() ([]account.Account, error) { return room.friends, nil }
-func (room *fakeRoom) GetOne() *account.One                { return room.one }
+func (room *fakeRoom) Broadcast(msg []byte)                         { room.dataBroadcasted = msg }
+func (room *fakeRoom) Ipcams() Ipcams                               { return room.ipcams }
+func (room *fakeRoom) Friends() ([]account.Account, error)          { return room.friends, nil }
+func (room *fakeRoom) GetOne() *account.One                         { return room.one }
+func (room *fakeRoom) AddSignaling(s *Signaling)                    {}
+func (room *fakeRoom) AddGuestSignaling(s *Signaling, max int) bool { return true }
+func (room *fakeRoom) RemoveSignaling(reciever string)              {}
+func (room *fakeRoom) Presence(forAccount uint) *Presence           { return &Presence{} }
+func (room *fakeRoom) RotateToken() error                           { return nil }
+func (room *fakeRoom) Transferred() error                           { return nil }
+func (room *fakeRoom) Disable()                                     {}
 
-func (room *fakeRoom) AddOnline(id uint, cu ControlUser) {
-	room.onlines[id] = cu
+func (room *fakeRoom) SetCameras(accountId uint, cs account.Cameras) {}
+func (room *fakeRoom) EndSignalings(match func(s *Signaling) bool)   {}
+
+func (room *fakeRoom) AddOnline(id uint, cu ControlUser, tag string) {
+	ss, ok := room.onlines[id]
+	if !ok {
+		ss = make(Sessions)
+		room.onlines[id] = ss
+	}
+	ss[cu.SessionId()] = cu
 }
 
-func (room *fakeRoom) GetOnline(id uint) (cu ControlUser, ok bool) {
-	cu, ok = room.onlines[id]
+func (room *fakeRoom) GetOnline(id uint) (ss Sessions, ok bool) {
+	ss, ok = room.onlines[id]
 	return
 }
 
-func (room *fakeRoom) RemoveOnline(id uint) {
-	if room.onlines != nil {
-		delete(room.onlines, id)
+func (room *fakeRoom) RemoveOnline(id uint, cu ControlUser) {
+	if ss, ok := room.onlines[id]; ok {
+		delete(ss, cu.SessionId())
+		if len(ss) == 0 {
+			delete(room.onlines, id)
+		}
 	}
 }
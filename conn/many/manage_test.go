@@ -0,0 +1,66 @@
+package many
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	. "github.com/empirefox/ic-server-conductor/account"
+	"github.com/empirefox/ic-server-conductor/account/accounttest"
+	"github.com/empirefox/ic-server-conductor/conn"
+)
+
+func readError(many *controlUser) *conn.ErrorReply {
+	select {
+	case msg := <-many.send:
+		var reply conn.ErrorReply
+		So(json.Unmarshal(msg, &reply), ShouldBeNil)
+		return &reply
+	default:
+		So("no msg sent", ShouldBeEmpty)
+		return nil
+	}
+}
+
+func Test_ManageByApiKey(t *testing.T) {
+	Convey("Manage commands by api key", t, func() {
+		o := accounttest.Setup()
+		one := &One{Name: "room", Addr: "addr"}
+		So(o.Account.RegOne(one), ShouldBeNil)
+		k, _, err := o.Account.NewApiKey("ci")
+		So(err, ShouldBeNil)
+		k.Account = o.Account
+
+		h := &fakeHub{rooms: make(map[uint]conn.ControlRoom), clients: make(map[uint]conn.Sessions)}
+		many := newControlUser(h, nil, nil)
+		many.Oauth = k
+
+		Convey("should not delete the room", func() {
+			many.onManyCommand([]byte(fmt.Sprintf(`{"name":"ManageDelRoom","room":%d}`, one.ID)))
+			So(readError(many).Code, ShouldEqual, conn.ErrCodeNotPermitted)
+			So((&One{}).Find(one.ID), ShouldBeNil)
+		})
+
+		Convey("should not delete ipcams", func() {
+			many.onManyCommand([]byte(fmt.Sprintf(`{"name":"ManageDelIpcam","room":%d,"content":"cam1"}`, one.ID)))
+			So(readError(many).Code, ShouldEqual, conn.ErrCodeNotPermitted)
+		})
+
+		Convey("should not disable the room", func() {
+			many.onManyCommand([]byte(fmt.Sprintf(`{"name":"ManageUpdRoom","room":%d,"content":{"Enabled":false}}`, one.ID)))
+			So(readError(many).Code, ShouldEqual, conn.ErrCodeNotPermitted)
+			saved := &One{}
+			So(saved.Find(one.ID), ShouldBeNil)
+			So(saved.Enabled, ShouldBeTrue)
+		})
+
+		Convey("should rename the room", func() {
+			many.onManyCommand([]byte(fmt.Sprintf(`{"name":"ManageUpdRoom","room":%d,"content":{"Name":"renamed"}}`, one.ID)))
+			saved := &One{}
+			So(saved.Find(one.ID), ShouldBeNil)
+			So(saved.Name, ShouldEqual, "renamed")
+		})
+	})
+}
@@ -5,8 +5,12 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/dchest/uniuri"
 	"github.com/gin-gonic/gin"
 	"github.com/golang/glog"
 	"github.com/gorilla/websocket"
@@ -18,26 +22,55 @@ import (
 
 var (
 	ErrUserNotAuthed = errors.New("User not authed")
+	ErrReauthAccount = errors.New("Reauth with another account")
+	ErrUserDisabled  = errors.New("User disabled")
+)
+
+var (
+	// TokenExpiring will be sent before token expired
+	TokenExpiringAhead = 2 * time.Minute
+	TokenCheckPeriod   = 10 * time.Second
 )
 
 type controlUser struct {
 	*websocket.Conn
 	*Oauth
-	send chan []byte
-	hub  conn.Hub
-	Exp  time.Time
+	sid    string
+	send   chan []byte
+	kick   chan []byte
+	reauth chan time.Time
+	hub    conn.Hub
+	vf     conn.VerifyFunc
+	// 1 when shared, read by rooms in other goroutines
+	share int32
+	// only used in writePump
+	Exp time.Time
+	// string, changed by Reauth
+	jti atomic.Value
 }
 
-func newControlUser(h conn.Hub, ws *websocket.Conn) *controlUser {
+func newControlUser(h conn.Hub, ws *websocket.Conn, vf conn.VerifyFunc) *controlUser {
 	return &controlUser{
-		Conn: ws,
-		hub:  h,
-		send: make(chan []byte, 64),
+		Conn:   ws,
+		hub:    h,
+		vf:     vf,
+		sid:    uniuri.New(),
+		send:   make(chan []byte, 64),
+		kick:   make(chan []byte, 1),
+		reauth: make(chan time.Time, 1),
 	}
 }
 
 func (room *controlUser) Tag() string { return "user" }
 
+func (many *controlUser) SessionId() string   { return many.sid }
+func (many *controlUser) SharePresence() bool { return atomic.LoadInt32(&many.share) == 1 }
+
+func (many *controlUser) Jti() string {
+	jti, _ := many.jti.Load().(string)
+	return jti
+}
+
 func (many *controlUser) Id() uint {
 	if many.Oauth == nil {
 		return 0
@@ -48,10 +81,19 @@ func (many *controlUser) Id() uint {
 func (many *controlUser) GetOauth() *Oauth { return many.Oauth }
 func (many *controlUser) Send(msg []byte)  { many.send <- msg }
 
+// Kick will not block the hub
+func (many *controlUser) Kick(reason string) {
+	msg, _ := json.Marshal(gin.H{"type": "Kicked", "content": reason})
+	select {
+	case many.kick <- msg:
+	default:
+	}
+}
+
 func (many *controlUser) SendObj(obj interface{}) {
 	msg, err := json.Marshal(obj)
 	if err != nil {
-		many.Send(GetTypedInfo(err.Error()))
+		many.Send(conn.ManyError(conn.ErrCodeInternal, err.Error()))
 		return
 	}
 	many.Send(msg)
@@ -61,7 +103,7 @@ func (many *controlUser) RoomOnes() ([]One, error) {
 	if many.Oauth == nil {
 		return nil, ErrUserNotAuthed
 	}
-	if err := many.Account.GetOnes(); err != nil {
+	if err := many.Account.GetActiveOnes(); err != nil {
 		return nil, err
 	}
 	return many.Account.Ones, nil
@@ -71,14 +113,17 @@ func (many *controlUser) T2M(oneId uint, k []byte, part *json.RawMessage) {
 	many.SendObj(gin.H{"type": "T2M", "ID": oneId, "name": string(k), "part": part})
 }
 
-// with ping
+// with ping and token expiry check
 func (many *controlUser) writePump() {
 	ticker := time.NewTicker(PingPeriod)
+	expTicker := time.NewTicker(TokenCheckPeriod)
+	expiring := false
 	defer func() {
 		if err := recover(); err != nil {
 			glog.Errorln(err)
 		}
 		ticker.Stop()
+		expTicker.Stop()
 		many.Close()
 	}()
 	for {
@@ -96,6 +141,31 @@ func (many *controlUser) writePump() {
 			if err := many.WriteMessage(websocket.PingMessage, []byte{}); err != nil {
 				return
 			}
+		case exp := <-many.reauth:
+			many.Exp = exp
+			expiring = false
+		case <-expTicker.C:
+			if many.Exp.IsZero() {
+				continue
+			}
+			now := time.Now()
+			if now.After(many.Exp) {
+				glog.Infoln("Token expired, account:", many.Id())
+				many.WriteMessage(websocket.TextMessage, []byte(`{"type":"TokenExpired"}`))
+				many.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if !expiring && now.Add(TokenExpiringAhead).After(many.Exp) {
+				expiring = true
+				msg := fmt.Sprintf(`{"type":"TokenExpiring","exp":%d}`, many.Exp.Unix())
+				if err := many.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+					return
+				}
+			}
+		case msg := <-many.kick:
+			many.WriteMessage(websocket.TextMessage, msg)
+			many.WriteMessage(websocket.CloseMessage, []byte{})
+			return
 		}
 	}
 }
@@ -110,10 +180,16 @@ func (many *controlUser) readPump() {
 		glog.Infoln("From many client:", string(b))
 		if !bytes.HasPrefix(b, []byte("many:")) {
 			glog.Errorln("Wrong message from many")
+			many.Send(conn.ManyError(conn.ErrCodeBadRequest, "Wrong message prefix"))
 			continue
 		}
 		// many:Chat:{"":""}
 		raws := bytes.SplitN(b, []byte{':'}, 3)
+		if len(raws) < 3 {
+			glog.Errorln("Not enough info in many message")
+			many.Send(conn.ManyError(conn.ErrCodeBadRequest, "Not enough info in message"))
+			continue
+		}
 		many.onRead(raws[1], raws[2])
 	}
 }
@@ -139,46 +215,127 @@ func (many *controlUser) onReadAuthed(typ, content []byte) {
 		many.onManyCommand(content)
 	case "GetManyData":
 		many.onManyGetData(content)
+	case "Reauth":
+		many.onReauth(content)
+	case "SharePresence":
+		many.onSharePresence(content)
 	default:
 		glog.Errorln("Unknow authed:", string(typ), string(content))
+		many.Send(conn.ManyError(conn.ErrCodeUnknownCommand, "Unknow message type:"+string(typ)))
 	}
 }
 
 func (many *controlUser) onReadNotAuthed(typ, content []byte) {
 	glog.Errorln("Unknow unauthed:", string(typ), string(content))
+	many.Send(conn.ManyError(conn.ErrCodeBadToken, "Not authed"))
 }
 
-func AuthMws(ws conn.Ws, vf conn.VerifyFunc) (*Oauth, error) {
+// AuthMws returns the verified Oauth, the jti and the expiry of the token
+func AuthMws(ws conn.Ws, vf conn.VerifyFunc) (*Oauth, string, time.Time, error) {
 	_, token, err := ws.ReadMessage()
 	if err != nil {
 		glog.Infoln("Read message err:", err)
-		return nil, err
+		return nil, "", time.Time{}, err
 	}
 	o := &Oauth{}
 	if err = vf(o, token); err != nil {
 		glog.Infoln(string(token))
-		ws.WriteMessage(websocket.TextMessage, []byte(`{"type":"LoginFailed"}`))
-		return nil, err
+		reply := &conn.ErrorReply{Type: "LoginFailed", Error: 1, Code: conn.ErrCodeBadToken}
+		ws.WriteMessage(websocket.TextMessage, reply.Bytes())
+		return nil, "", time.Time{}, err
+	}
+	if !o.Valid() {
+		reply := &conn.ErrorReply{Type: "LoginFailed", Error: 1, Code: conn.ErrCodeNotPermitted}
+		ws.WriteMessage(websocket.TextMessage, reply.Bytes())
+		return nil, "", time.Time{}, ErrUserDisabled
+	}
+	exp, err := conn.TokenExp(token)
+	if err != nil {
+		return nil, "", time.Time{}, err
 	}
 	if err = ws.WriteMessage(websocket.TextMessage, []byte(`{"type":"LoginOk"}`)); err != nil {
-		return nil, err
+		return nil, "", time.Time{}, err
 	}
-	return o, nil
+	return o, TokenJti(string(token)), exp, nil
+}
+
+// many:Reauth:[token]
+// Token must belong to the same account.
+func (many *controlUser) onReauth(token []byte) {
+	o := &Oauth{}
+	if err := many.vf(o, token); err != nil {
+		glog.Infoln("Reauth failed:", err)
+		many.Send(conn.ManyError(conn.ErrCodeBadToken, "Reauth failed"))
+		return
+	}
+	if o.AccountId != many.AccountId {
+		glog.Infoln(ErrReauthAccount)
+		many.Send(conn.ManyError(conn.ErrCodeBadToken, ErrReauthAccount.Error()))
+		return
+	}
+	if !o.Valid() {
+		many.Send(conn.ManyError(conn.ErrCodeNotPermitted, ErrUserDisabled.Error()))
+		return
+	}
+	exp, err := conn.TokenExp(token)
+	if err != nil {
+		many.Send(conn.ManyError(conn.ErrCodeBadToken, "Reauth failed"))
+		return
+	}
+	many.jti.Store(TokenJti(string(token)))
+	many.reauth <- exp
+	many.Send([]byte(`{"type":"ReauthOk"}`))
 }
 
 func (many *controlUser) SendUserIpcams() {
 	ones, err := many.RawRooms()
 	if err != nil {
-		many.Send(GetTypedInfo("Cannot get rooms"))
+		many.Send(conn.ManyError(conn.ErrCodeDbError, "Cannot get rooms"))
 		return
 	}
 	many.SendObj(gin.H{"type": "Rooms", "rooms": ones})
 
 	if views, err := many.Oauth.RawViewsByViewer(); err != nil {
-		many.Send(GetTypedInfo("Cannot get views"))
+		many.Send(conn.ManyError(conn.ErrCodeDbError, "Cannot get views"))
 	} else {
 		many.SendObj(gin.H{"type": "RoomViews", "views": views})
 	}
+
+	var groups []RoomGroup
+	if err := many.Account.Groups(&groups); err != nil {
+		many.Send(conn.ManyError(conn.ErrCodeDbError, "Cannot get groups"))
+	} else {
+		many.SendObj(gin.H{"type": "RoomGroups", "groups": groups})
+	}
+}
+
+// GetManyData:Rooms:[tag=office|group=3]
+// Replies Rooms like SendUserIpcams, with the filter.
+func (many *controlUser) onFilterRooms(arg []byte) {
+	var f RoomFilter
+	kv := strings.SplitN(string(arg), "=", 2)
+	if len(kv) == 2 {
+		switch kv[0] {
+		case "tag":
+			f.Tag = kv[1]
+		case "group":
+			id, err := strconv.ParseUint(kv[1], 10, 64)
+			if err != nil {
+				many.Send(conn.ManyError(conn.ErrCodeBadRequest, "Bad group id"))
+				return
+			}
+			f.GroupId = uint(id)
+		default:
+			many.Send(conn.ManyError(conn.ErrCodeBadRequest, "Rooms can filter by tag or group"))
+			return
+		}
+	}
+	ones, err := many.RawRoomsFilter(f)
+	if err != nil {
+		many.Send(conn.ManyError(conn.ErrCodeDbError, "Cannot get rooms"))
+		return
+	}
+	many.SendObj(gin.H{"type": "Rooms", "rooms": ones, "filter": string(arg)})
 }
 
 func HandleManyCtrl(h conn.Hub, vf conn.VerifyFunc) gin.HandlerFunc {
@@ -189,13 +346,15 @@ func HandleManyCtrl(h conn.Hub, vf conn.VerifyFunc) gin.HandlerFunc {
 			return
 		}
 		defer ws.Close()
-		o, err := AuthMws(ws, vf)
+		o, jti, exp, err := AuthMws(ws, vf)
 		if err != nil {
 			glog.Infoln("Auth failed:", err)
 			return
 		}
-		many := newControlUser(h, ws)
+		many := newControlUser(h, ws, vf)
 		many.Oauth = o
+		many.Exp = exp
+		many.jti.Store(jti)
 
 		go many.writePump()
 		// need after writePump
@@ -214,6 +373,7 @@ func (many *controlUser) onManyChat(bmsg []byte) {
 	msg := &conn.Message{}
 	if err := json.Unmarshal(bmsg, msg); err != nil {
 		glog.Errorln(err)
+		many.Send(conn.ManyError(conn.ErrCodeBadRequest, "Bad chat message"))
 		return
 	}
 	msg.From = many.Account.Name
@@ -224,12 +384,20 @@ func (many *controlUser) onManyCommand(bcmd []byte) {
 	cmd := conn.ManyCommand{}
 	if err := json.Unmarshal(bcmd, &cmd); err != nil {
 		glog.Errorln(err)
+		many.Send(conn.ManyError(conn.ErrCodeBadRequest, "Bad command"))
+		return
+	}
+
+	// the target is not the owner yet
+	if cmd.Name == "AcceptTransfer" {
+		many.onAcceptTransfer(cmd.Value())
 		return
 	}
 
-	one := &One{}
-	if err := one.FindIfOwner(cmd.Room, many.Account.ID); err != nil {
+	one, _, code, err := conn.FindManagedRoom(&many.Account, cmd.Room)
+	if err != nil {
 		glog.Errorln(err)
+		many.Send(conn.ManyError(code, "Not the owner of the room"))
 		return
 	}
 
@@ -237,63 +405,116 @@ func (many *controlUser) onManyCommand(bcmd []byte) {
 	case "ManageSetRoom":
 		// Content: new_name
 		// Proccess in server
-		one.Name = string(cmd.Value())
-		if err := one.Save(); err != nil {
-			glog.Errorln(err)
-			many.Send(GetTypedInfo("SetRoomName Error"))
-			return
-		}
-		part, err := one.RawUserRoom()
-		if err != nil {
-			glog.Errorln(err)
-			many.Send(GetTypedInfo("Get user room view error"))
-			return
-		}
+		input, _ := json.Marshal(map[string]string{"Name": string(cmd.Value())})
+		many.onUpdateRoom(one, input)
 
-		k := []byte("One")
-		room, ok := many.hub.GetRoom(cmd.Room)
-		if ok {
-			room.BroadcastT2M(k, *part)
-			return
-		}
-		many.T2M(one.ID, k, part)
+	case "ManageUpdRoom":
+		// Content: {"Name":"","Dsc":"","Enabled":true}, all optional
+		many.onUpdateRoom(one, cmd.Content)
 
 	case "ManageDelRoom":
-		room, ok := many.hub.GetRoom(cmd.Room)
-		if ok {
-			room.Remove()
+		// before StepUp, api keys must not use up totp failures
+		if _, code, err := conn.NoApiKey(many.Oauth); err != nil {
+			many.Send(conn.ManyError(code, err.Error()))
 			return
 		}
-
-		if err := one.Delete(); err != nil {
-			glog.Errorln(err)
-			many.Send(GetTypedInfo("DelRoom Error"))
+		if _, code, err := conn.StepUp(&many.Account, cmd.Totp); err != nil {
+			many.Send(conn.ManyError(code, err.Error()))
 			return
 		}
-		many.Send([]byte(fmt.Sprintf(`{"type":"XRoom","ID":%d}`, one.ID)))
+		if _, code, err := conn.DeleteRoom(many.hub, many, many.Oauth, one); err != nil {
+			glog.Errorln(err)
+			many.Send(conn.ManyError(code, "DelRoom Error"))
+		}
 
 	case "ManageGetIpcam", "ManageSetIpcam", "ManageDelIpcam":
 		// Content(string): ipcam_id/ipcam/ipcam_id
 		// Pass to One
-		room, ok := many.hub.GetRoom(cmd.Room)
-		if !ok {
-			many.Send(GetTypedInfo("Room not online"))
-			return
+		if _, code, err := conn.ManageIpcam(many.hub, many.Oauth, one, cmd.Name, cmd.Content); err != nil {
+			many.Send(conn.ManyError(code, err.Error()))
 		}
-		room.Send(GetNamedCmd(many.Account.ID, []byte(cmd.Name), cmd.Content))
 
 	default:
 		glog.Errorln("Unknow Command name:", cmd.Name)
-		many.Send(GetTypedInfo("Unknow Command name:" + cmd.Name))
+		many.Send(conn.ManyError(conn.ErrCodeUnknownCommand, "Unknow Command name:"+cmd.Name))
+	}
+}
+
+func (many *controlUser) onUpdateRoom(one *One, input []byte) {
+	_, fields, _, code, err := conn.UpdateRoom(many.hub, many, many.Oauth, one, input)
+	switch {
+	case fields != nil:
+		many.Send(conn.ManyFieldsError(fields))
+	case err != nil:
+		glog.Errorln(err)
+		many.Send(conn.ManyError(code, "UpdateRoom Error"))
+	}
+}
+
+// Content: transfer_id
+// Both accounts get RoomTransferred from hub when ok.
+func (many *controlUser) onAcceptTransfer(content []byte) {
+	id, err := strconv.ParseUint(string(content), 10, 64)
+	if err != nil {
+		many.Send(conn.ManyError(conn.ErrCodeBadRequest, "AcceptTransfer need transfer id"))
+		return
+	}
+	if _, _, code, err := conn.AcceptTransfer(many.hub, &many.Account, uint(id)); err != nil {
+		glog.Infoln("AcceptTransfer err:", err)
+		many.Send(conn.ManyError(code, err.Error()))
 	}
 }
 
-func (many *controlUser) onManyGetData(name []byte) {
+// many:SharePresence:true|false
+func (many *controlUser) onSharePresence(content []byte) {
+	share, err := strconv.ParseBool(string(content))
+	if err != nil {
+		many.Send(conn.ManyError(conn.ErrCodeBadRequest, "SharePresence need bool"))
+		return
+	}
+	var v int32
+	if share {
+		v = 1
+	}
+	atomic.StoreInt32(&many.share, v)
+}
+
+// GetManyData:RoomPresence:[room]
+func (many *controlUser) onRoomPresence(arg []byte) {
+	id, err := strconv.ParseUint(string(arg), 10, 64)
+	if err != nil {
+		many.Send(conn.ManyError(conn.ErrCodeBadRequest, "RoomPresence need room id"))
+		return
+	}
+	room, ok := many.hub.GetRoom(uint(id))
+	if !ok {
+		many.Send(conn.ManyError(conn.ErrCodeRoomOffline, "Room not online"))
+		return
+	}
+	if !many.Oauth.CanView(room.GetOne()) {
+		many.Send(conn.ManyError(conn.ErrCodeNotPermitted, "Not permited to view this room"))
+		return
+	}
+	many.SendObj(gin.H{"type": "RoomPresence", "content": room.Presence(many.Id())})
+}
+
+// GetManyData:[name]:[arg]
+func (many *controlUser) onManyGetData(content []byte) {
+	raws := bytes.SplitN(content, []byte{':'}, 2)
+	name := raws[0]
+	var arg []byte
+	if len(raws) == 2 {
+		arg = raws[1]
+	}
 	switch string(name) {
 	case "UserCameras":
 		many.SendUserIpcams()
+	case "RoomPresence":
+		many.onRoomPresence(arg)
+	case "Rooms":
+		many.onFilterRooms(arg)
 	default:
 		glog.Errorln("Unknow GetManyData name:", string(name))
-		many.Send(GetTypedInfo("Unknow GetManyData name:" + string(name)))
+		many.Send(conn.ManyError(conn.ErrCodeUnknownCommand, "Unknow GetManyData name:"+string(name)))
 	}
 }
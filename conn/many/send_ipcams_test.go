@@ -5,73 +5,65 @@ import (
 	"testing"
 
 	. "github.com/empirefox/ic-server-conductor/account"
-	. "github.com/empirefox/ic-server-conductor/conn"
+	"github.com/empirefox/ic-server-conductor/account/accounttest"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
-func findRoomById(rs []CameraRoom, id uint) *CameraRoom {
-	for _, room := range rs {
-		if room.Id == id {
-			return &room
-		}
-	}
-	return nil
+type typedMsg struct {
+	Type   string
+	Rooms  []json.RawMessage `json:"rooms"`
+	Views  []json.RawMessage `json:"views"`
+	Groups []json.RawMessage `json:"groups"`
 }
 
-func Test_genCameraList(t *testing.T) {
-	Convey("genCameraList", t, func() {
-		// user 601 has room 101/102/103
-		as := &fakeService{
-			dataGetOnes: []One{*newFakeDbOne(101), *newFakeDbOne(102), *newFakeDbOne(103)},
-		}
-		SetService(as)
-		defer SetService(nil)
-
-		// user 601
-		many := &fakeMany{
-			controlUser: newControlUser(nil, nil),
-		}
-		many.Oauth = newFakeDbOauth(601)
-
-		// room 101 has ipcams
-		room101 := newFakeIdRoom(101)
-		room101.ipcams = Ipcams{
-			"ic1": Ipcam{Id: "ic1"},
-		}
+func readTyped(many *controlUser) *typedMsg {
+	select {
+	case msg := <-many.send:
+		var m typedMsg
+		So(json.Unmarshal(msg, &m), ShouldBeNil)
+		return &m
+	default:
+		So("no msg sent", ShouldBeEmpty)
+		return nil
+	}
+}
 
-		h := &fakeHub{
-			// room 101/102 online
-			rooms: map[uint]ControlRoom{
-				101: room101,
-				102: newFakeIdRoom(102),
-			},
-			// user 601 online
-			clients: map[uint]ControlUser{
-				601: many,
-			},
+func Test_SendUserIpcams(t *testing.T) {
+	Convey("SendUserIpcams", t, func() {
+		// user has room 101/102/103
+		o := accounttest.Setup()
+		for _, name := range []string{"r101", "r102", "r103"} {
+			one := &One{Addr: name}
+			one.Name = name
+			So(o.Account.RegOne(one), ShouldBeNil)
 		}
-		many.hub = h
-
-		r, err := many.genCameraList()
-		// should ok
-		So(err, ShouldBeNil)
+		// another user views one of them
+		o2 := accounttest.Login("viewer")
+		So(o.GetOnes(), ShouldBeNil)
+		So(o2.Account.ViewOne(&o.Account.Ones[0]), ShouldBeNil)
 
-		var ics CameraList
-		err = json.Unmarshal(r, &ics)
-		So(err, ShouldBeNil)
-		So(len(ics.Rooms), ShouldEqual, 3)
+		Convey("should send rooms, views and groups", func() {
+			many := newControlUser(nil, nil, nil)
+			many.Oauth = o
+			many.SendUserIpcams()
 
-		r10x := findRoomById(ics.Rooms, 101)
-		So(r10x, ShouldNotBeNil)
-		So(len(r10x.Cameras), ShouldEqual, 1)
-		So(r10x.Cameras[0].Id, ShouldEqual, "ic1")
+			rooms := readTyped(many)
+			So(rooms.Type, ShouldEqual, "Rooms")
+			So(len(rooms.Rooms), ShouldEqual, 3)
+			views := readTyped(many)
+			So(views.Type, ShouldEqual, "RoomViews")
+			So(len(views.Views), ShouldEqual, 3)
+			So(readTyped(many).Type, ShouldEqual, "RoomGroups")
+		})
 
-		r10x = findRoomById(ics.Rooms, 102)
-		So(r10x, ShouldNotBeNil)
-		So(len(r10x.Cameras), ShouldEqual, 0)
+		Convey("should only send viewed rooms", func() {
+			many := newControlUser(nil, nil, nil)
+			many.Oauth = o2
+			many.SendUserIpcams()
 
-		r10x = findRoomById(ics.Rooms, 103)
-		So(r10x, ShouldNotBeNil)
-		So(len(r10x.Cameras), ShouldEqual, 0)
+			rooms := readTyped(many)
+			So(rooms.Type, ShouldEqual, "Rooms")
+			So(len(rooms.Rooms), ShouldEqual, 1)
+		})
 	})
 }
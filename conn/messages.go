@@ -31,10 +31,32 @@ type Command struct {
 	Content string `json:"content,omitempty"`
 }
 
+// Kick live many sockets of the account.
+// All sockets of the account will be kicked when Provider is empty.
+// Only the sockets and signalings of the token are kicked when Jti is set.
+type Kick struct {
+	AccountId uint
+	Provider  string
+	Jti       string
+	Reason    string
+	// Signalings ends the signalings of the account too,
+	// only of the kicked tokens when Provider is set
+	Signalings bool
+}
+
+// Transfer is sent to hub after the owner of Room changed in db.
+type Transfer struct {
+	Room uint `json:"room"`
+	From uint `json:"from"`
+	To   uint `json:"to"`
+}
+
 type ManyCommand struct {
 	Name    string          `json:"name,omitempty"`
 	Room    uint            `json:"room,omitempty"`
 	Content json.RawMessage `json:"content,omitempty"`
+	// totp or recovery code, required by destructive commands when enabled
+	Totp string `json:"totp,omitempty"`
 }
 
 func (c *ManyCommand) Value() []byte {
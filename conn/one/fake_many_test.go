@@ -0,0 +1,56 @@
+package one
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/empirefox/ic-server-conductor/account"
+)
+
+type fakeMany struct {
+	id       uint
+	sid      string
+	shared   bool
+	oauth    *account.Oauth
+	ones     []account.One
+	mu       sync.Mutex
+	dataSent [][]byte
+}
+
+func newFakeMany(id uint, sid string, shared bool) *fakeMany {
+	o := &account.Oauth{AccountId: id}
+	o.Account.ID = id
+	return &fakeMany{id: id, sid: sid, shared: shared, oauth: o}
+}
+
+func (many *fakeMany) ReadMessage() (int, []byte, error)               { return 0, nil, nil }
+func (many *fakeMany) WriteMessage(int, []byte) error                  { return nil }
+func (many *fakeMany) Close() error                                    { return nil }
+func (many *fakeMany) Id() uint                                        { return many.id }
+func (many *fakeMany) SessionId() string                               { return many.sid }
+func (many *fakeMany) Tag() string                                     { return "user" }
+func (many *fakeMany) SharePresence() bool                             { return many.shared }
+func (many *fakeMany) T2M(oneId uint, k []byte, part *json.RawMessage) {}
+func (many *fakeMany) RoomOnes() ([]account.One, error)                { return many.ones, nil }
+func (many *fakeMany) GetOauth() *account.Oauth                        { return many.oauth }
+func (many *fakeMany) Kick(reason string)                              {}
+func (many *fakeMany) Jti() string                                     { return "" }
+
+func (many *fakeMany) Send(msg []byte) {
+	many.mu.Lock()
+	defer many.mu.Unlock()
+	many.dataSent = append(many.dataSent, msg)
+}
+
+func (many *fakeMany) sent(typ string) int {
+	many.mu.Lock()
+	defer many.mu.Unlock()
+	n := 0
+	for _, msg := range many.dataSent {
+		var v struct{ Type string }
+		if json.Unmarshal(msg, &v) == nil && v.Type == typ {
+			n++
+		}
+	}
+	return n
+}
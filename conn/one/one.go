@@ -5,9 +5,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 
-	"github.com/dchest/uniuri"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
 	"github.com/golang/glog"
@@ -15,6 +15,7 @@ import (
 
 	. "github.com/empirefox/ic-server-conductor/account"
 	"github.com/empirefox/ic-server-conductor/conn"
+	"github.com/empirefox/ic-server-conductor/keys"
 	"github.com/empirefox/ic-server-conductor/utils"
 )
 
@@ -26,20 +27,30 @@ type controlRoom struct {
 	*websocket.Conn
 	*One
 	ipcams     json.RawMessage
-	onlines    map[uint]conn.ControlUser
+	mu         sync.RWMutex // guard onlines, cameras, signalings, Addr and claims
+	rotateMu   sync.Mutex
+	onlines    map[uint]conn.Sessions
+	cameras    map[uint]Cameras
+	signalings map[string]*conn.Signaling
 	send       chan []byte
 	hub        conn.Hub
 	alg        string
+	keys       *keys.Manager
 	manyVerify conn.VerifyFunc
+	// claims of the token used to login, nil after rotated
+	claims *roomClaims
 }
 
-func newControlRoom(h conn.Hub, ws *websocket.Conn, alg string, manyVerify conn.VerifyFunc) *controlRoom {
+func newControlRoom(h conn.Hub, ws *websocket.Conn, alg string, km *keys.Manager, manyVerify conn.VerifyFunc) *controlRoom {
 	return &controlRoom{
 		Conn:       ws,
 		hub:        h,
 		send:       make(chan []byte, 64),
-		onlines:    make(map[uint]conn.ControlUser),
+		onlines:    make(map[uint]conn.Sessions),
+		cameras:    make(map[uint]Cameras),
+		signalings: make(map[string]*conn.Signaling),
 		alg:        alg,
+		keys:       km,
 		manyVerify: manyVerify,
 	}
 }
@@ -57,9 +68,34 @@ func (room *controlRoom) Id() uint {
 
 func (room *controlRoom) Send(msg []byte) { room.send <- msg }
 
+// trySend drops msg when the buffer is full, it is used
+// from other goroutines which should not be blocked by One.
+func (room *controlRoom) trySend(msg []byte) {
+	select {
+	case room.send <- msg:
+	default:
+		glog.Infoln("Drop msg to one:", string(msg))
+	}
+}
+
+// sendTimeout blocks at most d, false when One did not read in time
+func (room *controlRoom) sendTimeout(msg []byte, d time.Duration) bool {
+	select {
+	case room.send <- msg:
+		return true
+	case <-time.After(d):
+		glog.Infoln("Timeout msg to one:", string(msg))
+		return false
+	}
+}
+
 func (room *controlRoom) Broadcast(msg []byte) {
-	for _, ctrl := range room.onlines {
-		ctrl.Send(msg)
+	room.mu.RLock()
+	defer room.mu.RUnlock()
+	for _, ss := range room.onlines {
+		for _, ctrl := range ss {
+			ctrl.Send(msg)
+		}
 	}
 }
 
@@ -67,33 +103,88 @@ func (room *controlRoom) Friends() ([]Account, error) {
 	if room.One == nil {
 		return nil, ErrRoomNotAuthed
 	}
-	if err := room.Viewers(); err != nil {
+	if err := room.ActiveViewers(); err != nil {
 		return nil, err
 	}
 	return room.Accounts, nil
 }
 
+// UserOnline is sent to One for every session.
+// It is called by hub, so never blocks on One nor does db io.
 func (room *controlRoom) AddOnline(id uint, cu conn.ControlUser, tag string) {
-	room.onlines[id] = cu
-	switch tag {
-	case "room":
-		cu.Send([]byte(fmt.Sprintf(`{"type":"RoomOnline","ID":%d}`, room.Id())))
-	case "user":
-		cu.Send([]byte(fmt.Sprintf(`{"type":"RoomOnline","ID":%d}`, room.Id())))
-		room.send <- []byte(fmt.Sprintf(`{"from":%d,"name":"UserOnline"}`, id))
-	default:
+	// tag is "room" when the viewer joined before the room
+	if tag != "room" && tag != "user" {
 		glog.Errorln("Unknown tag:", tag)
+		return
 	}
+	o := cu.GetOauth()
+	room.mu.Lock()
+	one := room.One
+	ss, existed := room.onlines[id]
+	if !existed {
+		ss = make(conn.Sessions)
+		room.onlines[id] = ss
+	}
+	ss[cu.SessionId()] = cu
+	// the allow-list of a shared viewer is loaded out of the hub goroutine,
+	// no camera is allowed until then
+	load := !existed && o != nil && one != nil && o.AccountId != one.OwnerId
+	if load {
+		room.cameras[id] = Cameras{""}
+	} else if !existed {
+		room.cameras[id] = nil
+	}
+	room.mu.Unlock()
+
+	if tag == "user" && !existed {
+		room.notifyViewers("ViewerOnline", id, cu)
+	}
+	if !load {
+		room.sendOnline(id, cu, tag)
+		return
+	}
+	// One is told after the allow-list, so its replies are filtered by it
+	go func() {
+		if room.setSessionCameras(id, cu, room.viewCameras(o, one)) {
+			room.sendOnline(id, cu, tag)
+		}
+	}()
 }
 
-func (room *controlRoom) GetOnline(id uint) (cu conn.ControlUser, ok bool) {
-	cu, ok = room.onlines[id]
+func (room *controlRoom) sendOnline(id uint, cu conn.ControlUser, tag string) {
+	cu.Send([]byte(fmt.Sprintf(`{"type":"RoomOnline","ID":%d}`, room.Id())))
+	if tag == "user" {
+		room.trySend([]byte(fmt.Sprintf(`{"from":%d,"name":"UserOnline","content":"%s"}`, id, cu.SessionId())))
+	}
+}
+
+func (room *controlRoom) GetOnline(id uint) (ss conn.Sessions, ok bool) {
+	room.mu.RLock()
+	defer room.mu.RUnlock()
+	ss, ok = room.onlines[id]
 	return
 }
 
-func (room *controlRoom) RemoveOnline(id uint) {
-	if room.onlines != nil {
+// account is removed when no session left
+// UserOffline is sent to One for every session, never blocks on One.
+func (room *controlRoom) RemoveOnline(id uint, cu conn.ControlUser) {
+	room.mu.Lock()
+	ss, ok := room.onlines[id]
+	if !ok {
+		room.mu.Unlock()
+		return
+	}
+	delete(ss, cu.SessionId())
+	last := len(ss) == 0
+	if last {
 		delete(room.onlines, id)
+		delete(room.cameras, id)
+	}
+	room.mu.Unlock()
+
+	room.trySend([]byte(fmt.Sprintf(`{"from":%d,"name":"UserOffline","content":"%s"}`, id, cu.SessionId())))
+	if last {
+		room.notifyViewers("ViewerOffline", id, cu)
 	}
 }
 
@@ -132,11 +223,13 @@ func (room *controlRoom) readPump() {
 		glog.Infoln("From one client:", string(b))
 		if !bytes.HasPrefix(b, []byte("one:")) {
 			glog.Errorln("Wrong message from one")
+			room.send <- conn.OneError("BadMessage", conn.ErrCodeBadRequest)
 			continue
 		}
 		raws := bytes.SplitN(b, []byte{':'}, 3)
 		if len(raws) < 3 {
 			glog.Errorln("Not enough info in one message")
+			room.send <- conn.OneError("BadMessage", conn.ErrCodeBadRequest)
 			continue
 		}
 		room.onRead(raws[1], raws[2])
@@ -157,12 +250,64 @@ func (room *controlRoom) onRead(typ, content []byte) {
 }
 
 func (room *controlRoom) doTargetT2M(to uint, k []byte, part json.RawMessage) {
-	room.onlines[to].T2M(room.Id(), k, &part)
+	room.mu.RLock()
+	defer room.mu.RUnlock()
+	ss, ok := room.onlines[to]
+	if !ok {
+		glog.Infoln("T2M target not online:", to)
+		room.send <- conn.OneError("BadT2M", conn.ErrCodeNotFound)
+		return
+	}
+	room.sendT2M(to, ss, k, part)
 }
 
 func (room *controlRoom) BroadcastT2M(k []byte, part json.RawMessage) {
-	for _, ctrl := range room.onlines {
-		ctrl.T2M(room.Id(), k, &part)
+	room.mu.RLock()
+	defer room.mu.RUnlock()
+	for id, ss := range room.onlines {
+		room.sendT2M(id, ss, k, part)
+	}
+}
+
+// sendT2M filters ipcams by the allow-list of the viewer, room.mu must be held
+func (room *controlRoom) sendT2M(id uint, ss conn.Sessions, k []byte, part json.RawMessage) {
+	filtered, ok := conn.FilterT2M(room.cameras[id], k, part)
+	if !ok {
+		return
+	}
+	for _, cu := range ss {
+		cu.T2M(room.Id(), k, &filtered)
+	}
+}
+
+// viewCameras does db io, never call it on the hub goroutine.
+// It never allows a camera when the allow-list cannot be loaded.
+func (room *controlRoom) viewCameras(o *Oauth, one *One) Cameras {
+	cs, err := o.ViewCameras(one)
+	if err != nil {
+		glog.Errorln("Load view cameras:", err)
+		return Cameras{""}
+	}
+	return cs
+}
+
+// setSessionCameras is false when the session left before the allow-list loaded
+func (room *controlRoom) setSessionCameras(id uint, cu conn.ControlUser, cs Cameras) bool {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	if room.onlines[id][cu.SessionId()] != cu {
+		return false
+	}
+	room.cameras[id] = cs
+	return true
+}
+
+// SetCameras takes effect from the next T2M of One
+func (room *controlRoom) SetCameras(accountId uint, cs Cameras) {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	if _, ok := room.onlines[accountId]; ok {
+		room.cameras[accountId] = cs
 	}
 }
 
@@ -173,20 +318,32 @@ func (room *controlRoom) onReadAuthed(typ, content []byte) {
 		room.onT2M(content)
 	case "ServerCommand":
 		onServerCommand(room, content)
+	case "RotateToken":
+		room.onRotateToken()
 	default:
 		glog.Errorln("Unknow command json:", string(typ), string(content))
+		room.send <- conn.OneError("UnknownCommand", conn.ErrCodeUnknownCommand)
 	}
 }
 
 func (room *controlRoom) onReadNotAuthed(typ, content []byte) {
 	switch string(typ) {
 	case "Login":
-		room.send <- []byte(fmt.Sprintf(`{"name":"%s"}`, room.onLogin(content)))
+		room.send <- room.onLogin(content)
+		// upgrade old token without exp
+		room.mu.RLock()
+		upgrade := room.claims != nil && room.claims.exp.IsZero()
+		room.mu.RUnlock()
+		if upgrade {
+			room.onRotateToken()
+		}
+	case "Pair":
+		room.send <- room.onPair(content)
 	case "RegRoom":
-		n, c := room.onRegRoom(content)
-		room.send <- []byte(fmt.Sprintf(`{"name":"%s","content":"%s"}`, n, c))
+		room.send <- room.onRegRoom(content)
 	default:
 		glog.Errorln("Unknow command json:", string(typ), string(content))
+		room.send <- conn.OneError("UnknownCommand", conn.ErrCodeUnknownCommand)
 	}
 }
 
@@ -195,6 +352,7 @@ func (room *controlRoom) onT2M(withTo []byte) {
 	to, k, part, err := utils.ReadO2MSeg(withTo)
 	if err != nil {
 		glog.Errorln(err)
+		room.send <- conn.OneError("BadT2M", conn.ErrCodeBadRequest)
 		return
 	}
 	if to == 0 {
@@ -208,73 +366,118 @@ type regRoomData struct {
 	Name string `json:"name"`
 }
 
-func (room *controlRoom) onRegRoom(regInfo []byte) (res, roomToken string) {
-	res = "BadRegToken"
+// Deprecated: use onPair, many token should not be copied to One
+func (room *controlRoom) onRegRoom(regInfo []byte) []byte {
 	// 1. Check msg format
 	// [regToken]:[json]
 	raws := bytes.SplitN(regInfo, []byte{':'}, 2)
 	if len(raws) < 2 {
 		glog.Errorln("No transfer data from one")
-		return
+		return conn.OneError("BadRegToken", conn.ErrCodeBadRequest)
 	}
 	// 2. Validate RegToken with many secret
 	o := &Oauth{}
 	err := room.manyVerify(o, raws[0])
 	if err != nil {
 		glog.Infoln("manyVerify err:", err)
-		return
+		return conn.OneError("BadRegToken", conn.ErrCodeBadToken)
 	}
 	// 3. Parse RoomData
 	var data regRoomData
 	if err = json.Unmarshal(raws[1], &data); err != nil {
 		glog.Infoln("Unmarshal err", err)
-		return
+		return conn.OneError("BadRegToken", conn.ErrCodeBadRequest)
 	}
 	// 4. Reg Room
-	res = "RegError"
 	one := &One{Addr: utils.NewRandom()}
 	one.Name = data.Name
 	if err = o.Account.RegOne(one); err != nil {
 		glog.Infoln("RegOne err:", err)
-		return
+		return conn.OneError("RegError", conn.ErrCodeDbError)
 	}
 	// 5. Generate RoomToken
-	token := jwt.New(jwt.GetSigningMethod(room.alg))
-	token.Claims["rid"] = one.ID
-	token.Claims["aid"] = o.Account.ID
-	token.Claims["iat"] = time.Now().Unix()
-	token.Claims["rnd"] = uniuri.New()
-	roomToken, err = token.SignedString([]byte(one.Addr))
-	glog.Infoln(one.Addr)
+	roomToken, err := newRoomToken(room.alg, room.keys, one)
 	if err != nil {
 		glog.Infoln("SignedString err:", err)
-		return
+		return conn.OneError("RegError", conn.ErrCodeInternal)
 	}
 
-	res = "SetRoomToken"
-	return
+	return []byte(fmt.Sprintf(`{"name":"SetRoomToken","content":"%s"}`, roomToken))
+}
+
+// one:Pair:[code]:[json]
+// code is got from /many/pair by the owner
+func (room *controlRoom) onPair(pairInfo []byte) []byte {
+	raws := bytes.SplitN(pairInfo, []byte{':'}, 2)
+	if len(raws) < 2 {
+		glog.Errorln("No pair data from one")
+		return conn.OneError("BadPairCode", conn.ErrCodeBadRequest)
+	}
+	// the code is only consumed by a valid request
+	var data regRoomData
+	if err := json.Unmarshal(raws[1], &data); err != nil {
+		glog.Infoln("Unmarshal err", err)
+		return conn.OneError("BadPairCode", conn.ErrCodeBadRequest)
+	}
+	accountId, ok := room.hub.ConsumePairCode(string(bytes.ToUpper(raws[0])))
+	if !ok {
+		glog.Infoln("Pair code not found")
+		return conn.OneError("BadPairCode", conn.ErrCodeBadToken)
+	}
+	owner := &Account{}
+	if err := owner.Find(accountId); err != nil {
+		glog.Infoln("Find pair account err:", err)
+		return conn.OneError("RegError", conn.ErrCodeNotFound)
+	}
+	one := &One{Addr: utils.NewRandom()}
+	one.Name = data.Name
+	if err := owner.RegOne(one); err != nil {
+		glog.Infoln("RegOne err:", err)
+		return conn.OneError("RegError", conn.ErrCodeDbError)
+	}
+	roomToken, err := newRoomToken(room.alg, room.keys, one)
+	if err != nil {
+		glog.Infoln("SignedString err:", err)
+		return conn.OneError("RegError", conn.ErrCodeInternal)
+	}
+	return []byte(fmt.Sprintf(`{"name":"SetRoomToken","content":"%s"}`, roomToken))
 }
 
-func (room *controlRoom) onLogin(tokenBytes []byte) (res string) {
-	res = "BadRoomToken"
+func (room *controlRoom) onLogin(tokenBytes []byte) []byte {
 	one := &One{}
 	token, err := jwt.Parse(string(tokenBytes), func(token *jwt.Token) (interface{}, error) {
 		rid, _ := token.Claims["rid"].(float64)
 		aid, _ := token.Claims["aid"].(float64)
 		glog.Infoln(token.Claims)
-		if err := one.FindIfOwner(uint(rid), uint(aid)); err != nil {
+		// aid is the owner when signed, the room may belong to an org now.
+		// Token of a transferred room is rotated with the new owner.
+		if err := one.Find(uint(rid)); err != nil {
 			return nil, err
 		}
-		glog.Infoln(one.Addr)
-		return []byte(one.Addr), nil
+		if one.OwnerId != uint(aid) {
+			return nil, ErrRoomTokenOwner
+		}
+		return roomVerifyKey(room.keys, token, one)
 	})
 	if err != nil || !token.Valid {
 		glog.Infoln("Token is not valid:", err)
-		return
+		return conn.OneError("BadRoomToken", conn.ErrCodeBadToken)
+	}
+	claims := parseRoomClaims(token)
+	if IsTokenRevoked(claims.jti) {
+		glog.Infoln("Token is revoked:", claims.jti)
+		return conn.OneError("BadRoomToken", conn.ErrCodeBadToken)
 	}
+	if !one.Usable() {
+		glog.Infoln("Room is disabled:", one.ID)
+		return conn.OneError("RoomDisabled", conn.ErrCodeNotPermitted)
+	}
+	room.mu.Lock()
 	room.One = one
+	room.claims = claims
+	room.mu.Unlock()
 	room.hub.OnReg(room)
-	return "Broadcast"
+	return []byte(`{"name":"Broadcast"}`)
 }
 
 func (room *controlRoom) offline() {
@@ -286,20 +489,40 @@ func (room *controlRoom) offline() {
 	room.One = nil
 }
 
+// Remove deletes the One, it is called by http handlers too,
+// never blocks on One.
 func (room *controlRoom) Remove() {
-	if room.One == nil {
+	room.mu.Lock()
+	one := room.One
+	room.One = nil
+	room.mu.Unlock()
+	if one == nil {
 		return
 	}
-	if err := room.Owner.RemoveOne(room.One); err != nil {
+	if err := one.Owner.RemoveOne(one); err != nil {
 		glog.Errorln(err)
 	}
-	room.Broadcast([]byte(fmt.Sprintf(`{"type":"XRoom","ID":%d}`, room.Id())))
-	room.hub.OnUnreg(room)
-	room.send <- []byte(`{"name":"BadRoomToken"}`)
+	room.Broadcast([]byte(fmt.Sprintf(`{"type":"XRoom","ID":%d}`, one.ID)))
+	// One is nil already, hub removes the room by id
+	room.hub.OnDisable(one.ID)
+	room.trySend(conn.OneError("BadRoomToken", conn.ErrCodeNotFound))
+}
+
+// Disable is called on the hub goroutine after the room is removed from hub,
+// never blocks on One.
+func (room *controlRoom) Disable() {
+	room.mu.Lock()
+	one := room.One
 	room.One = nil
+	room.mu.Unlock()
+	if one == nil {
+		return
+	}
+	room.Broadcast([]byte(fmt.Sprintf(`{"type":"RoomOffline","ID":%d}`, one.ID)))
+	room.trySend(conn.OneError("RoomDisabled", conn.ErrCodeNotPermitted))
 }
 
-func HandleOneCtrl(h conn.Hub, alg string, manyVerify conn.VerifyFunc) gin.HandlerFunc {
+func HandleOneCtrl(h conn.Hub, alg string, km *keys.Manager, manyVerify conn.VerifyFunc) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		ws, err := utils.Upgrader.Upgrade(c.Writer, c.Request, nil)
 		if err != nil {
@@ -308,7 +531,7 @@ func HandleOneCtrl(h conn.Hub, alg string, manyVerify conn.VerifyFunc) gin.Handl
 		}
 		defer ws.Close()
 
-		room := newControlRoom(h, ws, alg, manyVerify)
+		room := newControlRoom(h, ws, alg, km, manyVerify)
 		defer room.offline()
 		go room.writePump()
 		room.readPump()
@@ -319,10 +542,13 @@ func onServerCommand(room *controlRoom, command []byte) {
 	var cmd conn.ServerCommand
 	if err := json.Unmarshal(command, &cmd); err != nil {
 		glog.Errorln(err)
+		room.send <- conn.OneError("BadServerCommand", conn.ErrCodeBadRequest)
 		return
 	}
 	switch cmd.Name {
 	case "RemoveRoom":
 		room.Remove()
+	default:
+		room.send <- conn.OneError("UnknownServerCommand", conn.ErrCodeUnknownCommand)
 	}
 }
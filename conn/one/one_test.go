@@ -0,0 +1,124 @@
+package one
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	. "github.com/empirefox/ic-server-conductor/account"
+	"github.com/empirefox/ic-server-conductor/account/accounttest"
+	"github.com/empirefox/ic-server-conductor/conn"
+	"github.com/empirefox/ic-server-conductor/conn/hub"
+)
+
+func TestOnPair(t *testing.T) {
+	Convey("onPair", t, func() {
+		o := accounttest.Setup()
+		h := hub.NewHub()
+		code, _ := h.NewPairCode(o.AccountId)
+		room := newControlRoom(h, nil, "HS256", nil, nil)
+
+		Convey("should not consume the code by a bad request", func() {
+			So(string(room.onPair([]byte(code+":{bad"))), ShouldContainSubstring, "BadPairCode")
+			So(string(room.onPair([]byte(code+`:{"name":"r1"}`))), ShouldContainSubstring, "SetRoomToken")
+		})
+
+		Convey("should consume the code once", func() {
+			So(string(room.onPair([]byte(code+`:{"name":"r1"}`))), ShouldContainSubstring, "SetRoomToken")
+			So(string(room.onPair([]byte(code+`:{"name":"r2"}`))), ShouldContainSubstring, "BadPairCode")
+		})
+	})
+}
+
+func TestDisable(t *testing.T) {
+	Convey("Disable", t, func() {
+		room := newTestRoom()
+		viewer := newFakeMany(room.OwnerId, "v1", false)
+		room.AddOnline(viewer.id, viewer, "user")
+
+		Convey("should notify viewers and not block when One does not read", func() {
+			for len(room.send) < cap(room.send) {
+				room.send <- []byte("filled")
+			}
+			room.Disable()
+			So(room.GetOne(), ShouldBeNil)
+			So(viewer.sent("RoomOffline"), ShouldEqual, 1)
+
+			room.Disable()
+			So(viewer.sent("RoomOffline"), ShouldEqual, 1)
+		})
+	})
+}
+
+func TestRemove(t *testing.T) {
+	Convey("Remove", t, func() {
+		room := newTestRoom()
+		room.hub = hub.NewHub()
+		viewer := newFakeMany(room.OwnerId, "v1", false)
+		room.AddOnline(viewer.id, viewer, "user")
+
+		Convey("should delete the One and not block when One does not read", func() {
+			id := room.Id()
+			for len(room.send) < cap(room.send) {
+				room.send <- []byte("filled")
+			}
+			room.Remove()
+			So(room.GetOne(), ShouldBeNil)
+			So(viewer.sent("XRoom"), ShouldEqual, 1)
+			So((&One{}).Find(id), ShouldNotBeNil)
+
+			room.Remove()
+			So(viewer.sent("XRoom"), ShouldEqual, 1)
+		})
+	})
+}
+
+func TestAddOnline(t *testing.T) {
+	Convey("AddOnline", t, func() {
+		room := newTestRoom()
+		viewer := accounttest.Login("viewer")
+		So(viewer.Account.ViewOne(room.One), ShouldBeNil)
+		_, err := room.One.SetViewCameras(viewer.AccountId, []string{"door"})
+		So(err, ShouldBeNil)
+
+		Convey("should load cameras when the viewer joined before the room", func() {
+			h := hub.NewHub()
+			go h.Run()
+			room.hub = h
+			many := newFakeMany(viewer.AccountId, "v1", false)
+			many.oauth = viewer
+			many.ones = []One{*room.One}
+			h.OnJoin(many)
+			h.OnReg(room)
+
+			// RoomOnline is sent after the allow-list loaded
+			for i := 0; i < 100 && many.sent("RoomOnline") == 0; i++ {
+				time.Sleep(10 * time.Millisecond)
+			}
+			So(many.sent("RoomOnline"), ShouldEqual, 1)
+			room.mu.RLock()
+			cs, ok := room.cameras[viewer.AccountId]
+			room.mu.RUnlock()
+			So(ok, ShouldBeTrue)
+			So(cs.Allow("door"), ShouldBeTrue)
+			So(cs.Allow("yard"), ShouldBeFalse)
+		})
+
+		Convey("should not set cameras of a session left before they loaded", func() {
+			many := newFakeMany(viewer.AccountId, "v1", false)
+			many.oauth = viewer
+			room.mu.Lock()
+			room.onlines[viewer.AccountId] = conn.Sessions{"v1": many}
+			room.cameras[viewer.AccountId] = Cameras{""}
+			room.mu.Unlock()
+			room.RemoveOnline(viewer.AccountId, many)
+
+			So(room.setSessionCameras(viewer.AccountId, many, Cameras{"door"}), ShouldBeFalse)
+			room.mu.RLock()
+			_, ok := room.cameras[viewer.AccountId]
+			room.mu.RUnlock()
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
@@ -0,0 +1,137 @@
+package one
+
+import (
+	"encoding/json"
+
+	"github.com/golang/glog"
+
+	"github.com/empirefox/ic-server-conductor/conn"
+)
+
+func (room *controlRoom) AddSignaling(s *conn.Signaling) {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	room.signalings[s.Reciever] = s
+}
+
+// AddGuestSignaling counts and adds in one lock, so concurrent starts
+// cannot exceed max. A used reciever is never replaced.
+func (room *controlRoom) AddGuestSignaling(s *conn.Signaling, max int) bool {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	if _, used := room.signalings[s.Reciever]; used {
+		return false
+	}
+	n := 0
+	for _, existed := range room.signalings {
+		if existed.GuestId == s.GuestId {
+			n++
+		}
+	}
+	if n >= max {
+		return false
+	}
+	room.signalings[s.Reciever] = s
+	return true
+}
+
+func (room *controlRoom) RemoveSignaling(reciever string) {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	delete(room.signalings, reciever)
+}
+
+// EndSignalings stops and removes the matched signalings
+func (room *controlRoom) EndSignalings(match func(s *conn.Signaling) bool) {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	for reciever, s := range room.signalings {
+		if !match(s) {
+			continue
+		}
+		if s.Stop != nil {
+			s.Stop()
+		}
+		delete(room.signalings, reciever)
+	}
+}
+
+// owner of the room always knows, others only know shared viewers
+func (room *controlRoom) canSee(forAccount, viewer uint, shared bool) bool {
+	return shared || forAccount == viewer || (room.One != nil && room.OwnerId == forAccount)
+}
+
+func sharedSessions(ss conn.Sessions) (name string, shared bool) {
+	for _, cu := range ss {
+		if o := cu.GetOauth(); o != nil {
+			name = o.Account.Name
+		}
+		if cu.SharePresence() {
+			shared = true
+		}
+	}
+	return
+}
+
+func (room *controlRoom) Presence(forAccount uint) *conn.Presence {
+	room.mu.RLock()
+	defer room.mu.RUnlock()
+	p := &conn.Presence{
+		Room:       room.Id(),
+		Viewers:    []conn.ViewerPresence{},
+		Signalings: []conn.Signaling{},
+	}
+	visible := make(map[uint]bool)
+	for id, ss := range room.onlines {
+		name, shared := sharedSessions(ss)
+		if !room.canSee(forAccount, id, shared) {
+			continue
+		}
+		visible[id] = true
+		p.Viewers = append(p.Viewers, conn.ViewerPresence{AccountId: id, Name: name, Sessions: len(ss)})
+	}
+	for _, s := range room.signalings {
+		// guests are only known by the owner
+		if visible[s.AccountId] || (s.GuestId != 0 && room.One != nil && room.OwnerId == forAccount) {
+			p.Signalings = append(p.Signalings, *s)
+		}
+	}
+	return p
+}
+
+// ViewerOnline/ViewerOffline to co-viewers, the viewer must share presence.
+// Owner always knows, as the One of the owner knows.
+func (room *controlRoom) notifyViewers(typ string, id uint, cu conn.ControlUser) {
+	var name string
+	if o := cu.GetOauth(); o != nil {
+		name = o.Account.Name
+	}
+	msg, err := json.Marshal(map[string]interface{}{
+		"type":      typ,
+		"ID":        room.Id(),
+		"AccountId": id,
+		"Name":      name,
+	})
+	if err != nil {
+		glog.Errorln(err)
+		return
+	}
+	shared := cu.SharePresence()
+
+	room.mu.RLock()
+	defer room.mu.RUnlock()
+	for aid, ss := range room.onlines {
+		if aid == id {
+			continue
+		}
+		isOwner := room.One != nil && room.OwnerId == aid
+		if !shared && !isOwner {
+			continue
+		}
+		for _, other := range ss {
+			if isOwner || other.SharePresence() {
+				other.Send(msg)
+			}
+		}
+	}
+}
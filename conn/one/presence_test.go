@@ -0,0 +1,107 @@
+package one
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/empirefox/ic-server-conductor/conn"
+)
+
+func TestPresence(t *testing.T) {
+	Convey("Presence", t, func() {
+		room := newTestRoom()
+		ownerId := room.OwnerId
+		owner := newFakeMany(ownerId, "o1", false)
+		hidden := newFakeMany(ownerId+100, "h1", false)
+		shared := newFakeMany(ownerId+200, "s1", true)
+		room.AddOnline(owner.id, owner, "user")
+		room.AddOnline(hidden.id, hidden, "user")
+		room.AddOnline(shared.id, shared, "user")
+
+		Convey("owner should see all viewers", func() {
+			p := room.Presence(ownerId)
+			So(p.Room, ShouldEqual, room.Id())
+			So(len(p.Viewers), ShouldEqual, 3)
+		})
+
+		Convey("viewer should only see shared viewers and self", func() {
+			p := room.Presence(hidden.id)
+			ids := []uint{}
+			for _, v := range p.Viewers {
+				ids = append(ids, v.AccountId)
+			}
+			So(ids, ShouldContain, hidden.id)
+			So(ids, ShouldContain, shared.id)
+			So(ids, ShouldNotContain, ownerId)
+		})
+
+		Convey("guest signalings should only be seen by owner", func() {
+			room.AddSignaling(&conn.Signaling{Reciever: "g1", GuestId: 9})
+			So(len(room.Presence(ownerId).Signalings), ShouldEqual, 1)
+			So(len(room.Presence(shared.id).Signalings), ShouldEqual, 0)
+			room.RemoveSignaling("g1")
+			So(len(room.Presence(ownerId).Signalings), ShouldEqual, 0)
+		})
+
+		Convey("owner should be notified of every viewer, others only of shared", func() {
+			So(owner.sent("ViewerOnline"), ShouldEqual, 2)
+			So(hidden.sent("ViewerOnline"), ShouldEqual, 0)
+			So(shared.sent("ViewerOnline"), ShouldEqual, 0)
+		})
+
+		Convey("ViewerOffline should be sent when the last session left", func() {
+			hidden2 := newFakeMany(hidden.id, "h2", false)
+			room.AddOnline(hidden2.id, hidden2, "user")
+			So(owner.sent("ViewerOnline"), ShouldEqual, 2)
+
+			room.RemoveOnline(hidden.id, hidden)
+			So(owner.sent("ViewerOffline"), ShouldEqual, 0)
+			room.RemoveOnline(hidden2.id, hidden2)
+			So(owner.sent("ViewerOffline"), ShouldEqual, 1)
+			_, ok := room.GetOnline(hidden.id)
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("should not block when One does not read", func() {
+			for len(room.send) < cap(room.send) {
+				room.send <- []byte("filled")
+			}
+			room.RemoveOnline(shared.id, shared)
+			room.AddOnline(shared.id, shared, "user")
+			So(len(room.send), ShouldEqual, cap(room.send))
+		})
+	})
+}
+
+func TestAddGuestSignaling(t *testing.T) {
+	Convey("AddGuestSignaling", t, func() {
+		room := newTestRoom()
+
+		Convey("should not exceed max when started concurrently", func() {
+			var wg sync.WaitGroup
+			var added int32
+			for i := 0; i < 8; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					if room.AddGuestSignaling(&conn.Signaling{Reciever: fmt.Sprintf("g%d", i), GuestId: 9}, 2) {
+						atomic.AddInt32(&added, 1)
+					}
+				}(i)
+			}
+			wg.Wait()
+			So(int(added), ShouldEqual, 2)
+			So(len(room.Presence(room.OwnerId).Signalings), ShouldEqual, 2)
+		})
+
+		Convey("should not replace a used reciever", func() {
+			room.AddSignaling(&conn.Signaling{Reciever: "r1", AccountId: 1})
+			So(room.AddGuestSignaling(&conn.Signaling{Reciever: "r1", GuestId: 9}, 2), ShouldBeFalse)
+			So(room.AddGuestSignaling(&conn.Signaling{Reciever: "r2", GuestId: 8}, 1), ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,188 @@
+package one
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dchest/uniuri"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/golang/glog"
+
+	. "github.com/empirefox/ic-server-conductor/account"
+	"github.com/empirefox/ic-server-conductor/conn"
+	"github.com/empirefox/ic-server-conductor/keys"
+	"github.com/empirefox/ic-server-conductor/utils"
+)
+
+// RoomTokenTTL is the exp of new room token.
+// One should rotate token before expired.
+var RoomTokenTTL = 90 * 24 * time.Hour
+
+// RoomTokenTimeout limits the delivery of a rotated token,
+// the rotation is rolled back when One does not read in time.
+var RoomTokenTimeout = 10 * time.Second
+
+var (
+	ErrRoomTokenVer   = errors.New("Room token is not for current addr")
+	ErrRoomTokenOwner = errors.New("Room token is not for the owner")
+)
+
+// roomClaims are parsed from a valid room token
+type roomClaims struct {
+	jti string
+	exp time.Time
+}
+
+// addrVer binds a token to the current One.Addr, so changing Addr
+// invalidates tokens signed by the key manager too.
+func addrVer(addr string) string {
+	sum := sha256.Sum256([]byte(addr))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Signed by the room key of km if exist, otherwise HMAC with One.Addr.
+func newRoomToken(alg string, km *keys.Manager, one *One) (string, error) {
+	now := time.Now()
+	claims := map[string]interface{}{
+		"rid":  one.ID,
+		"aid":  one.OwnerId,
+		"iat":  now.Unix(),
+		"exp":  now.Add(RoomTokenTTL).Unix(),
+		"jti":  uniuri.New(),
+		"aver": addrVer(one.Addr),
+	}
+	if km != nil {
+		if _, ok := km.Signer(keys.UseRoom); ok {
+			return km.Sign(keys.UseRoom, claims)
+		}
+	}
+	token := jwt.New(jwt.GetSigningMethod(alg))
+	for k, v := range claims {
+		token.Claims[k] = v
+	}
+	return token.SignedString([]byte(one.Addr))
+}
+
+// roomVerifyKey is called after One found by claims.
+// Token with kid is verified by the key manager, otherwise by One.Addr.
+func roomVerifyKey(km *keys.Manager, token *jwt.Token, one *One) (interface{}, error) {
+	if ver, ok := token.Claims["aver"].(string); ok && ver != addrVer(one.Addr) {
+		return nil, ErrRoomTokenVer
+	}
+	kid, ok := token.Header["kid"].(string)
+	if !ok {
+		if _, isHMAC := token.Method.(*jwt.SigningMethodHMAC); !isHMAC {
+			return nil, keys.ErrWrongAlg
+		}
+		return []byte(one.Addr), nil
+	}
+	if km == nil {
+		return nil, keys.ErrWrongKid
+	}
+	return km.VerifyKey(keys.UseRoom, kid, token.Method.Alg())
+}
+
+func parseRoomClaims(token *jwt.Token) *roomClaims {
+	rc := &roomClaims{}
+	rc.jti, _ = token.Claims["jti"].(string)
+	if exp, ok := token.Claims["exp"].(float64); ok {
+		rc.exp = time.Unix(int64(exp), 0)
+	}
+	return rc
+}
+
+// RotateToken regenerates the One.Addr and sends the new token to One.
+// Old token is revoked after One read the new one, but the socket stays
+// connected. When One does not read in RoomTokenTimeout, the old Addr is
+// restored and ErrRoomTokenNotSent returned.
+// It is called by http handlers too, rotateMu serializes rotations,
+// room.mu guards Addr and claims.
+func (room *controlRoom) RotateToken() error {
+	room.rotateMu.Lock()
+	defer room.rotateMu.Unlock()
+
+	room.mu.RLock()
+	if room.One == nil {
+		room.mu.RUnlock()
+		return ErrRoomNotAuthed
+	}
+	one := *room.One
+	claims := room.claims
+	room.mu.RUnlock()
+
+	oldAddr := one.Addr
+	one.Addr = utils.NewRandom()
+	roomToken, err := newRoomToken(room.alg, room.keys, &one)
+	if err != nil {
+		return err
+	}
+	if err := one.SetAddr(one.Addr); err != nil {
+		return err
+	}
+	room.setAddr(one.Addr)
+
+	msg := []byte(fmt.Sprintf(`{"name":"SetRoomToken","content":"%s"}`, roomToken))
+	if !room.sendTimeout(msg, RoomTokenTimeout) {
+		if err := one.SetAddr(oldAddr); err != nil {
+			glog.Errorln(err)
+		}
+		room.setAddr(oldAddr)
+		return conn.ErrRoomTokenNotSent
+	}
+
+	room.mu.Lock()
+	room.claims = nil
+	room.mu.Unlock()
+	if claims != nil && claims.jti != "" {
+		exp := claims.exp
+		if exp.IsZero() {
+			exp = time.Now().Add(RoomTokenTTL)
+		}
+		if err := RevokeToken(claims.jti, exp); err != nil {
+			glog.Errorln(err)
+		}
+	}
+	return nil
+}
+
+func (room *controlRoom) setAddr(addr string) {
+	room.mu.Lock()
+	if room.One != nil {
+		room.Addr = addr
+	}
+	room.mu.Unlock()
+}
+
+// Transferred refreshes the owner then rotates the token,
+// so the new token carries the new owner.
+// It does db io, hub calls it in a new goroutine.
+func (room *controlRoom) Transferred() error {
+	id := room.Id()
+	if id == 0 {
+		return ErrRoomNotAuthed
+	}
+	one := &One{}
+	if err := one.Find(id); err != nil {
+		return err
+	}
+	room.mu.Lock()
+	if room.One == nil {
+		room.mu.Unlock()
+		return ErrRoomNotAuthed
+	}
+	room.OwnerId = one.OwnerId
+	room.Owner = one.Owner
+	room.OrgId = one.OrgId
+	room.mu.Unlock()
+	return room.RotateToken()
+}
+
+func (room *controlRoom) onRotateToken() {
+	if err := room.RotateToken(); err != nil {
+		glog.Errorln("RotateToken err:", err)
+		room.trySend(conn.OneError("RotateTokenError", conn.ErrCodeDbError))
+	}
+}
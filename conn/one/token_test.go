@@ -0,0 +1,166 @@
+package one
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	. "github.com/smartystreets/goconvey/convey"
+
+	. "github.com/empirefox/ic-server-conductor/account"
+	"github.com/empirefox/ic-server-conductor/account/accounttest"
+	"github.com/empirefox/ic-server-conductor/conn"
+	"github.com/empirefox/ic-server-conductor/conn/hub"
+)
+
+// newTestRoom sets up the account service,
+// returns an authed room of a new One owned by the owner
+func newTestRoom() *controlRoom {
+	o := accounttest.Setup()
+	one := &One{Addr: "old-addr"}
+	So(o.Account.RegOne(one), ShouldBeNil)
+	room := newControlRoom(nil, nil, "HS256", nil, nil)
+	room.One = one
+	return room
+}
+
+// sentToken reads the token of the SetRoomToken msg
+func sentToken(room *controlRoom) string {
+	select {
+	case msg := <-room.send:
+		So(string(msg), ShouldStartWith, `{"name":"SetRoomToken","content":"`)
+		return strings.TrimSuffix(strings.TrimPrefix(string(msg), `{"name":"SetRoomToken","content":"`), `"}`)
+	default:
+		So("no msg sent", ShouldBeEmpty)
+		return ""
+	}
+}
+
+func parseRoomToken(room *controlRoom, token string) (*jwt.Token, error) {
+	return jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
+		one := &One{}
+		if err := one.Find(room.One.ID); err != nil {
+			return nil, err
+		}
+		return roomVerifyKey(room.keys, token, one)
+	})
+}
+
+func TestRotateToken(t *testing.T) {
+	Convey("RotateToken", t, func() {
+		Convey("should fail when not authed", func() {
+			room := newControlRoom(nil, nil, "HS256", nil, nil)
+			So(room.RotateToken(), ShouldEqual, ErrRoomNotAuthed)
+		})
+
+		Convey("should save new addr, revoke old token and send new token", func() {
+			room := newTestRoom()
+			oldToken, err := newRoomToken(room.alg, room.keys, room.One)
+			So(err, ShouldBeNil)
+			room.claims = &roomClaims{jti: "old-jti", exp: time.Now().Add(time.Hour)}
+
+			So(room.RotateToken(), ShouldBeNil)
+			So(room.Addr, ShouldNotEqual, "old-addr")
+			So(room.claims, ShouldBeNil)
+			So(IsTokenRevoked("old-jti"), ShouldBeTrue)
+
+			saved := &One{}
+			So(saved.Find(room.One.ID), ShouldBeNil)
+			So(saved.Addr, ShouldEqual, room.Addr)
+
+			token, err := parseRoomToken(room, sentToken(room))
+			So(err, ShouldBeNil)
+			So(token.Valid, ShouldBeTrue)
+			So(token.Claims["aver"], ShouldEqual, addrVer(room.Addr))
+
+			_, err = parseRoomToken(room, oldToken)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("should roll back when One does not read", func() {
+			timeout := RoomTokenTimeout
+			RoomTokenTimeout = 10 * time.Millisecond
+			Reset(func() { RoomTokenTimeout = timeout })
+			room := newTestRoom()
+			room.claims = &roomClaims{jti: "old-jti", exp: time.Now().Add(time.Hour)}
+			for i := 0; i < cap(room.send); i++ {
+				room.send <- []byte("filled")
+			}
+			So(room.RotateToken(), ShouldEqual, conn.ErrRoomTokenNotSent)
+			So(room.Addr, ShouldEqual, "old-addr")
+			So(room.claims, ShouldNotBeNil)
+			So(IsTokenRevoked("old-jti"), ShouldBeFalse)
+
+			saved := &One{}
+			So(saved.Find(room.One.ID), ShouldBeNil)
+			So(saved.Addr, ShouldEqual, "old-addr")
+		})
+
+		Convey("should only save addr", func() {
+			room := newTestRoom()
+			changed := *room.One
+			changed.Name = "renamed"
+			So(changed.Save(), ShouldBeNil)
+
+			So(room.RotateToken(), ShouldBeNil)
+			saved := &One{}
+			So(saved.Find(room.One.ID), ShouldBeNil)
+			So(saved.Name, ShouldEqual, "renamed")
+			So(saved.Addr, ShouldEqual, room.Addr)
+		})
+
+		Convey("should save the last addr when rotated concurrently", func() {
+			room := newTestRoom()
+			var wg sync.WaitGroup
+			for i := 0; i < 8; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					room.RotateToken()
+				}()
+			}
+			wg.Wait()
+			So(len(room.send), ShouldEqual, 8)
+
+			saved := &One{}
+			So(saved.Find(room.One.ID), ShouldBeNil)
+			So(saved.Addr, ShouldEqual, room.Addr)
+		})
+
+		Convey("should only login by the token of the owner", func() {
+			room := newTestRoom()
+			room.hub = hub.NewHub()
+			token, err := newRoomToken(room.alg, room.keys, room.One)
+			So(err, ShouldBeNil)
+
+			other := accounttest.Login("other")
+			one := *room.One
+			one.OwnerId = other.AccountId
+			So(one.Save(), ShouldBeNil)
+
+			login := newControlRoom(room.hub, nil, "HS256", nil, nil)
+			So(string(login.onLogin([]byte(token))), ShouldContainSubstring, "BadRoomToken")
+			So(login.One, ShouldBeNil)
+		})
+
+		Convey("should send the token of the new owner when transferred", func() {
+			room := newTestRoom()
+			room.hub = hub.NewHub()
+			target := accounttest.Login("target")
+			owner := &Account{ID: room.OwnerId}
+			t, err := owner.StartTransfer(room.One, target.AccountId)
+			So(err, ShouldBeNil)
+			_, err = target.Account.AcceptTransfer(t.ID)
+			So(err, ShouldBeNil)
+
+			So(room.Transferred(), ShouldBeNil)
+			So(room.OwnerId, ShouldEqual, target.AccountId)
+			token := sentToken(room)
+			login := newControlRoom(room.hub, nil, "HS256", nil, nil)
+			So(string(login.onLogin([]byte(token))), ShouldEqual, `{"name":"Broadcast"}`)
+			So(login.OwnerId, ShouldEqual, target.AccountId)
+		})
+	})
+}
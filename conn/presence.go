@@ -0,0 +1,30 @@
+package conn
+
+import "time"
+
+// Signaling is an active signaling session of a viewer
+type Signaling struct {
+	Reciever  string    `json:"reciever"`
+	AccountId uint      `json:"accountId"`
+	GuestId   uint      `json:"guestId,omitempty"` // guest link, AccountId is 0
+	Camera    string    `json:"camera,omitempty"`
+	StartedAt time.Time `json:"startedAt"`
+	// Stop closes the socket of the viewer
+	Stop func() `json:"-"`
+	// token of the viewer, see account.TokenJti
+	Jti string `json:"-"`
+	// of the guest link, zero for accounts, ended by the hub sweeper
+	ExpiresAt time.Time `json:"expiresAt,omitempty"`
+}
+
+type ViewerPresence struct {
+	AccountId uint   `json:"accountId"`
+	Name      string `json:"name"`
+	Sessions  int    `json:"sessions"`
+}
+
+type Presence struct {
+	Room       uint             `json:"ID"`
+	Viewers    []ViewerPresence `json:"viewers"`
+	Signalings []Signaling      `json:"signalings"`
+}
@@ -0,0 +1,102 @@
+package conn
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/empirefox/ic-server-conductor/account"
+	"github.com/empirefox/ic-server-conductor/utils"
+	"github.com/empirefox/tagsjson/tagjson"
+)
+
+var (
+	ErrRoomFields  = errors.New("Invalid room fields")
+	ErrRoomOffline = errors.New("Room not online")
+	// the rotation is rolled back, the old token is still valid
+	ErrRoomTokenNotSent = errors.New("New room token not read by One")
+)
+
+// Room management is shared by rest api and many commands,
+// status is only used by rest api. self is the many session
+// sending the command, nil for rest api.
+
+// FindManagedRoom finds the personal room of a, or the org room managed by a
+func FindManagedRoom(a *account.Account, id uint) (*account.One, int, ErrorCode, error) {
+	one := &account.One{}
+	if err := one.FindIfOwner(id, a.ID); err != nil {
+		return nil, http.StatusForbidden, ErrCodeNotOwner, err
+	}
+	return one, http.StatusOK, "", nil
+}
+
+// UpdateRoom decodes input by the Upd profile, only fields in input are changed.
+// Viewers of the online room get T2M One, only self gets it when offline.
+// The online room is disconnected when disabled.
+// fields are set when validation failed.
+func UpdateRoom(h Hub, self ControlUser, o *account.Oauth, one *account.One, input []byte) (part *json.RawMessage, fields map[string][]string, status int, code ErrorCode, err error) {
+	enabled := one.Enabled
+	if fields, ok := tagjson.NewDecoder(account.Upd).DecodeReaderV(bytes.NewReader(input), one); !ok {
+		return nil, fields, http.StatusBadRequest, ErrCodeBadRequest, ErrRoomFields
+	}
+	if one.Enabled != enabled {
+		if status, code, err := NoApiKey(o); err != nil {
+			return nil, nil, status, code, err
+		}
+	}
+	if one.Enabled && one.DisabledBySys {
+		return nil, nil, http.StatusForbidden, ErrCodeNotPermitted, ErrRoomDisabledBySys
+	}
+	if err := one.Save(); err != nil {
+		return nil, nil, http.StatusInternalServerError, ErrCodeDbError, err
+	}
+	part, err = one.RawUserRoom()
+	if err != nil {
+		return nil, nil, http.StatusInternalServerError, ErrCodeInternal, err
+	}
+	k := []byte("One")
+	if room, ok := h.GetRoom(one.ID); ok {
+		room.BroadcastT2M(k, *part)
+	} else if self != nil {
+		self.T2M(one.ID, k, part)
+	}
+	disableRoom(h, one)
+	return part, nil, http.StatusOK, "", nil
+}
+
+// DeleteRoom removes the online room, viewers get XRoom and One gets BadRoomToken.
+// Only self gets XRoom when offline.
+func DeleteRoom(h Hub, self ControlUser, o *account.Oauth, one *account.One) (int, ErrorCode, error) {
+	if status, code, err := NoApiKey(o); err != nil {
+		return status, code, err
+	}
+	if room, ok := h.GetRoom(one.ID); ok {
+		room.Remove()
+		return http.StatusOK, "", nil
+	}
+	if err := one.Delete(); err != nil {
+		return http.StatusInternalServerError, ErrCodeDbError, err
+	}
+	if self != nil {
+		self.Send([]byte(fmt.Sprintf(`{"type":"XRoom","ID":%d}`, one.ID)))
+	}
+	return http.StatusOK, "", nil
+}
+
+// ManageIpcam passes ManageGetIpcam/ManageSetIpcam/ManageDelIpcam to One,
+// One replies to the sessions of o by T2M.
+func ManageIpcam(h Hub, o *account.Oauth, one *account.One, name string, content json.RawMessage) (int, ErrorCode, error) {
+	if name == "ManageDelIpcam" {
+		if status, code, err := NoApiKey(o); err != nil {
+			return status, code, err
+		}
+	}
+	room, ok := h.GetRoom(one.ID)
+	if !ok {
+		return http.StatusConflict, ErrCodeRoomOffline, ErrRoomOffline
+	}
+	room.Send(utils.GetNamedCmd(o.Account.ID, []byte(name), content))
+	return http.StatusAccepted, "", nil
+}
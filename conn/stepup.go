@@ -0,0 +1,36 @@
+package conn
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/empirefox/ic-server-conductor/account"
+)
+
+var ErrApiKeyNotPermitted = errors.New("not permitted for api keys")
+
+// NoApiKey is shared by rest api and many commands before destructive actions,
+// api keys only automate the others.
+func NoApiKey(o *account.Oauth) (int, ErrorCode, error) {
+	if o.Provider == account.ProviderApiKey {
+		return http.StatusForbidden, ErrCodeNotPermitted, ErrApiKeyNotPermitted
+	}
+	return http.StatusOK, "", nil
+}
+
+// StepUp is shared by rest api and many commands before destructive actions,
+// code is the totp or recovery code. It passes when a has no totp enabled.
+func StepUp(a *account.Account, code string) (int, ErrorCode, error) {
+	switch err := a.StepUp(code); err {
+	case nil:
+		return http.StatusOK, "", nil
+	case account.ErrTotpRequired:
+		return http.StatusForbidden, ErrCodeTotpRequired, err
+	case account.ErrBadTotp:
+		return http.StatusForbidden, ErrCodeBadTotp, err
+	case account.ErrTotpLocked:
+		return http.StatusForbidden, ErrCodeTotpLocked, err
+	default:
+		return http.StatusInternalServerError, ErrCodeDbError, err
+	}
+}
@@ -0,0 +1,24 @@
+package conn
+
+import (
+	"net/http"
+
+	"github.com/empirefox/ic-server-conductor/account"
+)
+
+// AcceptTransfer is shared by rest api and many command,
+// status is only used by rest api.
+func AcceptTransfer(h Hub, a *account.Account, id uint) (*account.OneTransfer, int, ErrorCode, error) {
+	t, err := a.AcceptTransfer(id)
+	switch err {
+	case nil:
+	case account.ErrTransferExpired, account.ErrTransferStale:
+		return nil, http.StatusGone, ErrCodeNotPermitted, err
+	case account.ErrRecordNotFound:
+		return nil, http.StatusNotFound, ErrCodeNotFound, err
+	default:
+		return nil, http.StatusInternalServerError, ErrCodeDbError, err
+	}
+	h.OnTransfer(&Transfer{Room: t.OneId, From: t.FromId, To: t.ToId})
+	return t, http.StatusOK, "", nil
+}
@@ -0,0 +1,126 @@
+// Package export builds account data archives in background,
+// finished archives are kept in memory until downloaded or expired.
+package export
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/empirefox/ic-server-conductor/account"
+	"github.com/empirefox/ic-server-conductor/utils"
+)
+
+const (
+	StatusPending = "pending"
+	StatusReady   = "ready"
+	StatusFailed  = "failed"
+)
+
+var (
+	ErrRunning = errors.New("Export is running")
+	ErrTooMany = errors.New("Too many exports")
+)
+
+// TTL is how long a finished export is kept.
+var TTL = time.Hour
+
+// MaxJobs is how many exports an account keeps before they expire.
+var MaxJobs = 3
+
+type Job struct {
+	ID        string     `json:"id"`
+	AccountId uint       `json:"-"`
+	Status    string     `json:"status"`
+	Error     string     `json:"error,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	data      []byte
+}
+
+type Manager struct {
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+func NewManager() *Manager {
+	return &Manager{jobs: make(map[string]*Job)}
+}
+
+// Start exports a in background, only one pending job and at most
+// MaxJobs kept jobs for an account.
+func (m *Manager) Start(a *account.Account) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for _, j := range m.jobs {
+		if j.AccountId != a.ID {
+			continue
+		}
+		if j.Status == StatusPending {
+			return *j, ErrRunning
+		}
+		n++
+	}
+	if n >= MaxJobs {
+		return Job{}, ErrTooMany
+	}
+	j := &Job{ID: utils.NewRandom(), AccountId: a.ID, Status: StatusPending, CreatedAt: time.Now()}
+	m.jobs[j.ID] = j
+	go m.run(j.ID, *a)
+	return *j, nil
+}
+
+func (m *Manager) run(id string, a account.Account) {
+	data, err := archive(&a)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[id]
+	if !ok {
+		return
+	}
+	if err != nil {
+		glog.Errorln("Export account", a.ID, err)
+		j.Status = StatusFailed
+		j.Error = err.Error()
+	} else {
+		j.Status = StatusReady
+		j.data = data
+	}
+	exp := time.Now().Add(TTL)
+	j.ExpiresAt = &exp
+}
+
+func archive(a *account.Account) ([]byte, error) {
+	ex, err := a.Export()
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(ex, "", "  ")
+}
+
+// Get returns the job and the archive when ready,
+// jobs of other accounts are not found.
+func (m *Manager) Get(id string, accountId uint) (Job, []byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[id]
+	if !ok || j.AccountId != accountId {
+		return Job{}, nil, false
+	}
+	return *j, j.data, true
+}
+
+// Purge removes finished jobs expired before now.
+func (m *Manager) Purge(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, j := range m.jobs {
+		if j.ExpiresAt != nil && j.ExpiresAt.Before(now) {
+			delete(m.jobs, id)
+		}
+	}
+}
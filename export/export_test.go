@@ -0,0 +1,107 @@
+package export
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/empirefox/ic-server-conductor/account"
+	"github.com/empirefox/ic-server-conductor/account/accounttest"
+)
+
+func waitDone(m *Manager, id string, accountId uint) (Job, []byte) {
+	for i := 0; i < 100; i++ {
+		j, data, ok := m.Get(id, accountId)
+		So(ok, ShouldBeTrue)
+		if j.Status != StatusPending {
+			return j, data
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return Job{}, nil
+}
+
+func TestExport(t *testing.T) {
+	Convey("export", t, func() {
+		o := accounttest.Setup()
+		m := NewManager()
+		one := &account.One{Name: "room", Addr: "secret"}
+		So(o.Account.RegOne(one), ShouldBeNil)
+
+		Convey("should archive owned data in background", func() {
+			j, err := m.Start(&o.Account)
+			So(err, ShouldBeNil)
+			So(j.Status, ShouldEqual, StatusPending)
+
+			_, _, ok := m.Get(j.ID, o.Account.ID+1)
+			So(ok, ShouldBeFalse)
+
+			j, data := waitDone(m, j.ID, o.Account.ID)
+			So(j.Status, ShouldEqual, StatusReady)
+			var ex account.AccountExport
+			So(json.Unmarshal(data, &ex), ShouldBeNil)
+			So(ex.Account.ID, ShouldEqual, o.Account.ID)
+			So(len(ex.Oauths), ShouldEqual, 1)
+			So(len(ex.Ones), ShouldEqual, 1)
+			So(len(ex.Views), ShouldEqual, 1)
+			So(string(data), ShouldNotContainSubstring, "secret")
+		})
+
+		Convey("should export shares, guest links, api keys, totp and sessions without secrets", func() {
+			viewer := accounttest.Login("viewer")
+			So(viewer.Account.ViewOne(one), ShouldBeNil)
+			_, err := one.SetViewCameras(viewer.Account.ID, []string{"cam1"})
+			So(err, ShouldBeNil)
+			_, code, err := o.Account.NewGuestLink(one, "", 1, time.Now().Add(time.Hour))
+			So(err, ShouldBeNil)
+			_, key, err := o.Account.NewApiKey("ci")
+			So(err, ShouldBeNil)
+			totp, err := o.Account.EnrollTotp()
+			So(err, ShouldBeNil)
+			So(o.RecordSession("session-token", "", "", time.Time{}, true), ShouldBeNil)
+
+			ex, err := o.Account.Export()
+			So(err, ShouldBeNil)
+			So(len(ex.Oauths), ShouldEqual, 1)
+			So(len(ex.ApiKeys), ShouldEqual, 1)
+			So(ex.ApiKeys[0].Name, ShouldEqual, "ci")
+			So(ex.Totp, ShouldNotBeNil)
+			So(ex.Totp.Enabled, ShouldBeFalse)
+			So(len(ex.Sessions), ShouldEqual, 1)
+			So(len(ex.Ones[0].Shares), ShouldEqual, 2)
+			So(len(ex.Ones[0].GuestLinks), ShouldEqual, 1)
+
+			data, err := json.Marshal(ex)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldContainSubstring, "cam1")
+			for _, secret := range []string{code, key[len(key)-32:], totp.Secret, account.TokenJti("session-token")} {
+				So(string(data), ShouldNotContainSubstring, secret)
+			}
+		})
+
+		Convey("should limit kept jobs of an account", func() {
+			for i := 0; i < MaxJobs; i++ {
+				j, err := m.Start(&o.Account)
+				So(err, ShouldBeNil)
+				waitDone(m, j.ID, o.Account.ID)
+			}
+			_, err := m.Start(&o.Account)
+			So(err, ShouldEqual, ErrTooMany)
+
+			m.Purge(time.Now().Add(TTL + time.Minute))
+			_, err = m.Start(&o.Account)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("should purge expired jobs", func() {
+			j, err := m.Start(&o.Account)
+			So(err, ShouldBeNil)
+			waitDone(m, j.ID, o.Account.ID)
+			m.Purge(time.Now().Add(TTL + time.Minute))
+			_, _, ok := m.Get(j.ID, o.Account.ID)
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
@@ -0,0 +1,150 @@
+package gorm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Cascade means rows of Model will be deleted with the Parent row,
+// Model.Field references Parent.id.
+type Cascade struct {
+	Model  interface{}
+	Field  string
+	Parent string
+}
+
+// Dialect hides the differences of foreign keys between databases.
+type Dialect interface {
+	AddCascades(db *gorm.DB, cs ...Cascade) error
+	// WithTimeout adds the query timeout to the url
+	WithTimeout(url string, d time.Duration) string
+	// Upsert inserts the row of values into the table of model, or updates
+	// the columns of the row which has the same unique key.
+	Upsert(db *gorm.DB, model interface{}, key string, values map[string]interface{}, columns ...string) error
+}
+
+// insertSql is the insert statement of values, sorted by column
+func insertSql(db *gorm.DB, model interface{}, values map[string]interface{}) (string, []interface{}) {
+	cols := make([]string, 0, len(values))
+	for col := range values {
+		cols = append(cols, col)
+	}
+	sort.Strings(cols)
+	args := make([]interface{}, len(cols))
+	for i, col := range cols {
+		args[i] = values[col]
+	}
+	marks := strings.TrimSuffix(strings.Repeat("?,", len(cols)), ",")
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		db.NewScope(model).TableName(), strings.Join(cols, ","), marks), args
+}
+
+// onConflict is supported by postgres and sqlite 3.24+
+func onConflict(db *gorm.DB, model interface{}, key string, values map[string]interface{}, columns []string) error {
+	sql, args := insertSql(db, model, values)
+	if len(columns) == 0 {
+		return db.Exec(sql+fmt.Sprintf(" ON CONFLICT (%s) DO NOTHING", key), args...).Error
+	}
+	sets := make([]string, len(columns))
+	for i, col := range columns {
+		sets[i] = fmt.Sprintf("%s = excluded.%s", col, col)
+	}
+	return db.Exec(sql+fmt.Sprintf(" ON CONFLICT (%s) DO UPDATE SET %s", key, strings.Join(sets, ",")), args...).Error
+}
+
+var dialects = map[string]Dialect{
+	"postgres": foreignKeys{},
+	"mysql":    triggers{mysql: true},
+	"sqlite3":  triggers{},
+}
+
+// DialectOf returns postgres when name is unknown.
+func DialectOf(name string) Dialect {
+	if d, ok := dialects[name]; ok {
+		return d
+	}
+	return dialects["postgres"]
+}
+
+func addParam(url, param string) string {
+	if strings.Contains(url, "?") {
+		return url + "&" + param
+	}
+	return url + "?" + param
+}
+
+type foreignKeys struct{}
+
+// server side statement_timeout, the query is cancelled.
+func (foreignKeys) WithTimeout(url string, d time.Duration) string {
+	param := fmt.Sprintf("statement_timeout=%d", d/time.Millisecond)
+	if !strings.Contains(url, "://") {
+		// key=value style
+		return url + " " + param
+	}
+	return addParam(url, param)
+}
+
+func (foreignKeys) Upsert(db *gorm.DB, model interface{}, key string, values map[string]interface{}, columns ...string) error {
+	return onConflict(db, model, key, values, columns)
+}
+
+func (foreignKeys) AddCascades(db *gorm.DB, cs ...Cascade) error {
+	for _, c := range cs {
+		if err := db.Model(c.Model).AddForeignKey(c.Field, c.Parent, "CASCADE", "CASCADE").Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sqlite cannot add foreign key to an existed table, and mysql
+// ignores it with MyISAM, so cascades are emulated by triggers.
+type triggers struct {
+	mysql bool
+}
+
+// mysql driver closes the connection when timeout,
+// sqlite waits for the lock at most d.
+func (t triggers) WithTimeout(url string, d time.Duration) string {
+	if t.mysql {
+		return addParam(url, fmt.Sprintf("readTimeout=%s&writeTimeout=%s", d, d))
+	}
+	return addParam(url, fmt.Sprintf("_busy_timeout=%d", d/time.Millisecond))
+}
+
+// mysql finds the conflict by any unique key, key is only for sqlite.
+func (t triggers) Upsert(db *gorm.DB, model interface{}, key string, values map[string]interface{}, columns ...string) error {
+	if !t.mysql {
+		return onConflict(db, model, key, values, columns)
+	}
+	sql, args := insertSql(db, model, values)
+	sets := []string{fmt.Sprintf("%s = %s", key, key)}
+	for _, col := range columns {
+		sets = append(sets, fmt.Sprintf("%s = VALUES(%s)", col, col))
+	}
+	return db.Exec(sql+" ON DUPLICATE KEY UPDATE "+strings.Join(sets, ","), args...).Error
+}
+
+func (t triggers) AddCascades(db *gorm.DB, cs ...Cascade) error {
+	for _, c := range cs {
+		table := db.NewScope(c.Model).TableName()
+		name := fmt.Sprintf("%s_del_%s_%s", c.Parent, table, c.Field)
+		var sql string
+		if t.mysql {
+			sql = fmt.Sprintf("CREATE TRIGGER %s BEFORE DELETE ON %s FOR EACH ROW DELETE FROM %s WHERE %s.%s = OLD.id",
+				name, c.Parent, table, table, c.Field)
+		} else {
+			sql = fmt.Sprintf("CREATE TRIGGER IF NOT EXISTS %s AFTER DELETE ON %s FOR EACH ROW BEGIN DELETE FROM %s WHERE %s = OLD.id; END",
+				name, c.Parent, table, c.Field)
+		}
+		if err := db.Exec(sql).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
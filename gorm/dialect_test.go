@@ -0,0 +1,54 @@
+package gorm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type upsertItem struct {
+	ID   uint   `gorm:"primary_key"`
+	Code string `sql:"unique_index"`
+	Name string
+	Note string
+}
+
+func TestUpsert(t *testing.T) {
+	Convey("Upsert on sqlite", t, func() {
+		dir, err := ioutil.TempDir("", "upsert")
+		So(err, ShouldBeNil)
+		Reset(func() { os.RemoveAll(dir) })
+		db, err := Open(Config{Dialect: "sqlite3", Url: filepath.Join(dir, "test.db")})
+		So(err, ShouldBeNil)
+		Reset(func() { db.Close() })
+		So(db.CreateTable(&upsertItem{}).Error, ShouldBeNil)
+		So(db.Model(&upsertItem{}).AddUniqueIndex("uix_upsert_items_code", "code").Error, ShouldBeNil)
+
+		upsert := func(name, note string, columns ...string) error {
+			return db.Dialect.Upsert(&db.DB, &upsertItem{}, "code",
+				map[string]interface{}{"code": "c", "name": name, "note": note}, columns...)
+		}
+
+		Convey("should insert then update only the columns", func() {
+			So(upsert("first", "a"), ShouldBeNil)
+			So(upsert("second", "b", "name"), ShouldBeNil)
+			var items []upsertItem
+			So(db.Find(&items).Error, ShouldBeNil)
+			So(len(items), ShouldEqual, 1)
+			So(items[0].Name, ShouldEqual, "second")
+			So(items[0].Note, ShouldEqual, "a")
+		})
+
+		Convey("should keep the row when no columns", func() {
+			So(upsert("first", "a"), ShouldBeNil)
+			So(upsert("second", "b"), ShouldBeNil)
+			var items []upsertItem
+			So(db.Find(&items).Error, ShouldBeNil)
+			So(len(items), ShouldEqual, 1)
+			So(items[0].Name, ShouldEqual, "first")
+		})
+	})
+}
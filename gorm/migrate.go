@@ -0,0 +1,83 @@
+package gorm
+
+import (
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/jinzhu/gorm"
+)
+
+// Tx is passed to Migration.Up, all steps of a Migration run in it.
+type Tx struct {
+	*gorm.DB
+	Dialect Dialect
+}
+
+// Migration must never be changed after released, add a new one instead.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx Tx) error `json:"-"`
+}
+
+// SchemaVersion records every applied Migration.
+type SchemaVersion struct {
+	Version   int    `gorm:"primary_key" sql:"auto_increment:false"`
+	Name      string `sql:"type:varchar(64)"`
+	CreatedAt time.Time
+}
+
+// Version returns 0 when nothing applied.
+func Version(db *DB) (int, error) {
+	if !db.HasTable(&SchemaVersion{}) {
+		return 0, nil
+	}
+	var v SchemaVersion
+	q := db.Order("version desc").First(&v)
+	if q.RecordNotFound() {
+		return 0, nil
+	}
+	return v.Version, q.Error
+}
+
+// Pending returns ms newer than current, ms must be ordered by Version.
+func Pending(ms []Migration, current int) []Migration {
+	for i, m := range ms {
+		if m.Version > current {
+			return ms[i:]
+		}
+	}
+	return nil
+}
+
+// MigrateUp applies pending ms in order, and stops at the first error.
+// Returns the pending ms when dryRun, or the applied ones.
+func MigrateUp(db *DB, ms []Migration, dryRun bool) ([]Migration, error) {
+	current, err := Version(db)
+	if err != nil {
+		return nil, err
+	}
+	pending := Pending(ms, current)
+	if dryRun || len(pending) == 0 {
+		return pending, nil
+	}
+	if err := db.AutoMigrate(&SchemaVersion{}).Error; err != nil {
+		return nil, err
+	}
+	for i, m := range pending {
+		tx := db.Begin()
+		if err := m.Up(Tx{DB: tx, Dialect: db.Dialect}); err != nil {
+			tx.Rollback()
+			return pending[:i], err
+		}
+		if err := tx.Create(&SchemaVersion{Version: m.Version, Name: m.Name}).Error; err != nil {
+			tx.Rollback()
+			return pending[:i], err
+		}
+		if err := tx.Commit().Error; err != nil {
+			return pending[:i], err
+		}
+		glog.Infof("Migrated to version %d: %s\n", m.Version, m.Name)
+	}
+	return pending, nil
+}
@@ -0,0 +1,114 @@
+package gorm
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type migrateItem struct {
+	ID   uint `gorm:"primary_key"`
+	Name string
+}
+
+type migrateOther struct {
+	ID uint `gorm:"primary_key"`
+}
+
+func TestMigrateUp(t *testing.T) {
+	Convey("MigrateUp on sqlite", t, func() {
+		dir, err := ioutil.TempDir("", "migrate")
+		So(err, ShouldBeNil)
+		Reset(func() { os.RemoveAll(dir) })
+		db, err := Open(Config{Dialect: "sqlite3", Url: filepath.Join(dir, "test.db")})
+		So(err, ShouldBeNil)
+		Reset(func() { db.Close() })
+
+		var applied []int
+		up := func(v int, f func(tx Tx) error) Migration {
+			return Migration{Version: v, Name: "m", Up: func(tx Tx) error {
+				applied = append(applied, v)
+				return f(tx)
+			}}
+		}
+		ms := []Migration{
+			up(1, func(tx Tx) error { return tx.CreateTable(&migrateItem{}).Error }),
+			up(2, func(tx Tx) error { return tx.Create(&migrateItem{Name: "first"}).Error }),
+		}
+
+		Convey("should apply in order and record the version", func() {
+			done, err := MigrateUp(db, ms, false)
+			So(err, ShouldBeNil)
+			So(len(done), ShouldEqual, 2)
+			So(applied, ShouldResemble, []int{1, 2})
+			v, err := Version(db)
+			So(err, ShouldBeNil)
+			So(v, ShouldEqual, 2)
+		})
+
+		Convey("should only list pending when dry run", func() {
+			pending, err := MigrateUp(db, ms, true)
+			So(err, ShouldBeNil)
+			So(len(pending), ShouldEqual, 2)
+			So(applied, ShouldBeEmpty)
+			v, err := Version(db)
+			So(err, ShouldBeNil)
+			So(v, ShouldEqual, 0)
+		})
+
+		Convey("should do nothing when run again", func() {
+			_, err := MigrateUp(db, ms, false)
+			So(err, ShouldBeNil)
+			applied = nil
+
+			done, err := MigrateUp(db, ms, false)
+			So(err, ShouldBeNil)
+			So(done, ShouldBeEmpty)
+			So(applied, ShouldBeEmpty)
+			var count int
+			So(db.Model(&migrateItem{}).Count(&count).Error, ShouldBeNil)
+			So(count, ShouldEqual, 1)
+		})
+
+		Convey("should roll back the failed migration and keep the version", func() {
+			failed := append(ms,
+				up(3, func(tx Tx) error {
+					if err := tx.CreateTable(&migrateOther{}).Error; err != nil {
+						return err
+					}
+					if err := tx.Create(&migrateItem{Name: "second"}).Error; err != nil {
+						return err
+					}
+					return errors.New("failed")
+				}),
+				up(4, func(tx Tx) error { return nil }),
+			)
+			done, err := MigrateUp(db, failed, false)
+			So(err, ShouldNotBeNil)
+			So(len(done), ShouldEqual, 2)
+			So(applied, ShouldResemble, []int{1, 2, 3})
+
+			v, err := Version(db)
+			So(err, ShouldBeNil)
+			So(v, ShouldEqual, 2)
+			So(db.HasTable(&migrateOther{}), ShouldBeFalse)
+			var count int
+			So(db.Model(&migrateItem{}).Count(&count).Error, ShouldBeNil)
+			So(count, ShouldEqual, 1)
+
+			// fixed migration is applied from the failed one
+			applied = nil
+			failed[2] = up(3, func(tx Tx) error { return tx.CreateTable(&migrateOther{}).Error })
+			done, err = MigrateUp(db, failed, false)
+			So(err, ShouldBeNil)
+			So(applied, ShouldResemble, []int{3, 4})
+			v, err = Version(db)
+			So(err, ShouldBeNil)
+			So(v, ShouldEqual, 4)
+		})
+	})
+}
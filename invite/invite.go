@@ -19,14 +19,18 @@ func HandleManyGetInviteCode(h Hub, userKey string) gin.HandlerFunc {
 		var data getInviteCodeData
 		if err := c.BindJSON(&data); err != nil {
 			glog.Infoln("No room set in context:", err)
-			c.AbortWithStatus(http.StatusBadRequest)
+			AbortWithCode(c, http.StatusBadRequest, ErrCodeBadRequest, "room required")
 			return
 		}
 		user := c.Keys[userKey].(*Oauth).Account
 		one := &One{}
 		if err := one.FindIfOwner(data.Room, user.ID); err != nil {
 			glog.Infoln("Not the owner of the room:", err)
-			c.AbortWithStatus(http.StatusForbidden)
+			AbortWithCode(c, http.StatusForbidden, ErrCodeNotOwner, "not the owner of the room")
+			return
+		}
+		if !one.Usable() {
+			AbortWithCode(c, http.StatusForbidden, ErrCodeNotPermitted, "room disabled")
 			return
 		}
 		c.JSON(http.StatusOK, gin.H{
@@ -41,38 +45,55 @@ type onInviteData struct {
 	Code string `json:"code"`
 }
 
-func onManyInvite(h Hub, c *gin.Context, userKey string) (ok bool) {
+// return empty code when ok
+func onManyInvite(h Hub, c *gin.Context, userKey string) (status int, code ErrorCode) {
 	var data onInviteData
 	if err := c.BindJSON(&data); err != nil {
 		glog.Infoln("Get on-invite data:", err)
-		return
+		return http.StatusBadRequest, ErrCodeBadRequest
 
 	}
 	if !h.ValidateInviteCode(data.Room, data.Code) {
 		glog.Infoln("Invalid invite code")
-		return
+		return http.StatusBadRequest, ErrCodeBadInviteCode
 	}
 	one := &One{}
 	if err := one.Find(data.Room); err != nil {
 		glog.Infoln("Room not found:", err)
-		return
+		return http.StatusNotFound, ErrCodeNotFound
+	}
+	o := c.Keys[userKey].(*Oauth)
+	user := o.Account
+	if !o.Valid() || !one.Usable() {
+		glog.Infoln("User or room disabled")
+		return http.StatusForbidden, ErrCodeNotPermitted
+	}
+	// owner, org members and viewers
+	if o.CanView(one) {
+		glog.Infoln("Already can view the room")
+		return http.StatusBadRequest, ErrCodeBadRequest
 	}
-	user := c.Keys[userKey].(*Oauth).Account
-	if one.OwnerId == user.ID {
-		glog.Infoln("Cannot invite to your own room")
-		return
+	// a view closed by schedule or expired is kept, the owner resets it
+	switch err := user.FindView(&AccountOne{}, one.ID); err {
+	case nil:
+		glog.Infoln("Already invited to the room")
+		return http.StatusBadRequest, ErrCodeBadRequest
+	case ErrRecordNotFound:
+	default:
+		glog.Infoln("Find view:", err)
+		return http.StatusInternalServerError, ErrCodeDbError
 	}
 	if err := user.ViewOne(one); err != nil {
 		glog.Infoln("Cannot be invited to the room:", err)
-		return
+		return http.StatusInternalServerError, ErrCodeDbError
 	}
-	return true
+	return http.StatusOK, ""
 }
 
 func HandleManyOnInvite(h Hub, userKey string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if !onManyInvite(h, c, userKey) {
-			c.AbortWithStatus(http.StatusBadRequest)
+		if status, code := onManyInvite(h, c, userKey); code != "" {
+			AbortWithCode(c, status, code, "cannot join the room")
 			return
 		}
 		c.AbortWithStatus(http.StatusOK)
@@ -0,0 +1,86 @@
+package invite
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/smartystreets/goconvey/convey"
+
+	. "github.com/empirefox/ic-server-conductor/account"
+	"github.com/empirefox/ic-server-conductor/account/accounttest"
+	"github.com/empirefox/ic-server-conductor/conn/hub"
+)
+
+const userKey = "user"
+
+func serve(handler gin.HandlerFunc, o *Oauth, body interface{}) *httptest.ResponseRecorder {
+	router := gin.New()
+	router.POST("/", func(c *gin.Context) { c.Set(userKey, o) }, handler)
+	b, _ := json.Marshal(body)
+	req, _ := http.NewRequest("POST", "/", bytes.NewReader(b))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestInvite(t *testing.T) {
+	Convey("invite", t, func() {
+		owner := accounttest.Setup()
+		viewer := accounttest.Login("viewer")
+		h := hub.NewHub()
+		one := &One{Name: "room", Addr: "addr"}
+		So(owner.Account.RegOne(one), ShouldBeNil)
+
+		Convey("should only give code to owner", func() {
+			w := serve(HandleManyGetInviteCode(h, userKey), viewer, getInviteCodeData{Room: one.ID})
+			So(w.Code, ShouldEqual, http.StatusForbidden)
+		})
+
+		Convey("should view the room with code once", func() {
+			w := serve(HandleManyGetInviteCode(h, userKey), owner, getInviteCodeData{Room: one.ID})
+			So(w.Code, ShouldEqual, http.StatusOK)
+			var res onInviteData
+			So(json.Unmarshal(w.Body.Bytes(), &res), ShouldBeNil)
+			So(res.Room, ShouldEqual, one.ID)
+
+			w = serve(HandleManyOnInvite(h, userKey), viewer, res)
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(viewer.CanView(one), ShouldBeTrue)
+
+			w = serve(HandleManyOnInvite(h, userKey), viewer, res)
+			So(w.Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("should not be invited again when the view expired", func() {
+			So(viewer.Account.ViewOne(one), ShouldBeNil)
+			expired := time.Now().Add(-time.Minute)
+			So(one.SetViewSchedule(viewer.AccountId, nil, &expired), ShouldBeNil)
+			So(viewer.CanView(one), ShouldBeFalse)
+
+			w := serve(HandleManyGetInviteCode(h, userKey), owner, getInviteCodeData{Room: one.ID})
+			var res onInviteData
+			So(json.Unmarshal(w.Body.Bytes(), &res), ShouldBeNil)
+			w = serve(HandleManyOnInvite(h, userKey), viewer, res)
+			So(w.Code, ShouldEqual, http.StatusBadRequest)
+			So(viewer.CanView(one), ShouldBeFalse)
+		})
+
+		Convey("should not join disabled room", func() {
+			w := serve(HandleManyGetInviteCode(h, userKey), owner, getInviteCodeData{Room: one.ID})
+			var res onInviteData
+			So(json.Unmarshal(w.Body.Bytes(), &res), ShouldBeNil)
+
+			one.Enabled = false
+			So(one.Save(), ShouldBeNil)
+			w = serve(HandleManyOnInvite(h, userKey), viewer, res)
+			So(w.Code, ShouldEqual, http.StatusForbidden)
+			So(viewer.CanView(one), ShouldBeFalse)
+		})
+	})
+}
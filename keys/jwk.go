@@ -0,0 +1,78 @@
+package keys
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"time"
+)
+
+// Jwk only has public fields, HMAC keys are never published.
+type Jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type Jwks struct {
+	Keys []Jwk `json:"keys"`
+}
+
+func b64(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+// fixed size for EC coordinates
+func b64Fixed(n *big.Int, size int) string {
+	b := n.Bytes()
+	if len(b) < size {
+		b = append(make([]byte, size-len(b)), b...)
+	}
+	return b64(b)
+}
+
+func (k *Key) Jwk() (*Jwk, bool) {
+	jwk := &Jwk{Kid: k.Kid, Use: k.Use, Alg: k.Alg}
+	switch pub := k.Public().(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = b64(pub.N.Bytes())
+		jwk.E = b64(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = pub.Curve.Params().Name
+		jwk.X = b64Fixed(pub.X, size)
+		jwk.Y = b64Fixed(pub.Y, size)
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = b64(pub)
+	default:
+		return nil, false
+	}
+	return jwk, true
+}
+
+// Jwks returns all public keys not retired
+func (m *Manager) Jwks() *Jwks {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	now := time.Now()
+	set := &Jwks{Keys: []Jwk{}}
+	for _, k := range m.keys {
+		if k.retired(now) {
+			continue
+		}
+		if jwk, ok := k.Jwk(); ok {
+			set.Keys = append(set.Keys, *jwk)
+		}
+	}
+	return set
+}
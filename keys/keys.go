@@ -0,0 +1,234 @@
+// Package keys manages signing keys of session, room, guest, proxy and
+// system tokens. A key is found by kid, and is limited to one use, so a key
+// of proxy cannot be used to sign a room token.
+package keys
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	UseSys     = "system"
+	UseProxy   = "proxy"
+	UseRoom    = "room"
+	UseGuest   = "guest"
+	UseSession = "session"
+)
+
+var (
+	ErrWrongKid    = errors.New("Wrong kid")
+	ErrWrongAlg    = errors.New("Wrong alg")
+	ErrNoSigner    = errors.New("No signing key")
+	ErrKeyRetired  = errors.New("Key retired")
+	ErrUnknownKey  = errors.New("Unknown key type")
+	ErrBadPemBlock = errors.New("Bad pem block")
+	ErrKidExists   = errors.New("Kid exists")
+)
+
+type Key struct {
+	Kid string
+	Use string
+	Alg string
+	// []byte for HMAC, *rsa.PrivateKey, *ecdsa.PrivateKey or ed25519.PrivateKey
+	Private interface{}
+	// Zero means never retired. Retired key can verify until NotAfter, but never sign.
+	NotAfter time.Time
+	// the newest key of a use signs
+	CreatedAt time.Time
+}
+
+func (k *Key) Method() jwt.SigningMethod { return jwt.GetSigningMethod(k.Alg) }
+
+func (k *Key) IsHMAC() bool {
+	_, ok := k.Private.([]byte)
+	return ok
+}
+
+// Public returns the key used to verify
+func (k *Key) Public() interface{} {
+	switch p := k.Private.(type) {
+	case []byte:
+		return p
+	case *rsa.PrivateKey:
+		return &p.PublicKey
+	case *ecdsa.PrivateKey:
+		return &p.PublicKey
+	case ed25519.PrivateKey:
+		return p.Public().(ed25519.PublicKey)
+	}
+	return nil
+}
+
+func (k *Key) retired(now time.Time) bool {
+	return !k.NotAfter.IsZero() && now.After(k.NotAfter)
+}
+
+func NewHMACKey(kid, use string, secret []byte) *Key {
+	return &Key{Kid: kid, Use: use, Alg: "HS256", Private: secret, CreatedAt: time.Now()}
+}
+
+// ParsePEM parses PKCS1/PKCS8/EC private key, alg is RS256, ES256 or EdDSA.
+func ParsePEM(kid, use string, data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrBadPemBlock
+	}
+	var priv interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		priv, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		priv, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		priv, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	key := &Key{Kid: kid, Use: use, Private: priv, CreatedAt: time.Now()}
+	switch priv.(type) {
+	case *rsa.PrivateKey:
+		key.Alg = "RS256"
+	case *ecdsa.PrivateKey:
+		key.Alg = "ES256"
+	case ed25519.PrivateKey:
+		key.Alg = "EdDSA"
+	default:
+		return nil, ErrUnknownKey
+	}
+	return key, nil
+}
+
+// Manager is safe for concurrent use.
+type Manager struct {
+	mu   sync.RWMutex
+	keys map[string]*Key
+}
+
+func NewManager(keys ...*Key) *Manager {
+	m := &Manager{keys: make(map[string]*Key)}
+	for _, k := range keys {
+		m.keys[k.Kid] = k
+	}
+	return m
+}
+
+// FromSecrets is compatible with the old Server.Keys, kid is the use.
+func FromSecrets(secrets map[string][]byte) *Manager {
+	m := NewManager()
+	for kid, secret := range secrets {
+		m.keys[kid] = NewHMACKey(kid, kid, secret)
+	}
+	return m
+}
+
+func (m *Manager) Add(key *Key) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if key.CreatedAt.IsZero() {
+		key.CreatedAt = time.Now()
+	}
+	m.keys[key.Kid] = key
+}
+
+// Rotate adds the new signer of the use, old keys of the use
+// can still verify in overlap. The kid must be new, so a key
+// of any use is never replaced.
+func (m *Manager) Rotate(key *Key, overlap time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.keys[key.Kid]; ok {
+		return ErrKidExists
+	}
+	now := time.Now()
+	for _, k := range m.keys {
+		if k.Use == key.Use && k.NotAfter.IsZero() {
+			k.NotAfter = now.Add(overlap)
+		}
+	}
+	if key.CreatedAt.IsZero() {
+		key.CreatedAt = now
+	}
+	m.keys[key.Kid] = key
+	return nil
+}
+
+// Signer returns the newest not retired key of the use.
+func (m *Manager) Signer(use string) (*Key, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var signer *Key
+	for _, k := range m.keys {
+		if k.Use != use || !k.NotAfter.IsZero() {
+			continue
+		}
+		if signer == nil || k.CreatedAt.After(signer.CreatedAt) {
+			signer = k
+		}
+	}
+	return signer, signer != nil
+}
+
+// Sign token with the signer of the use, kid is set in header.
+func (m *Manager) Sign(use string, claims map[string]interface{}) (string, error) {
+	key, ok := m.Signer(use)
+	if !ok {
+		return "", ErrNoSigner
+	}
+	token := jwt.New(key.Method())
+	token.Header["kid"] = key.Kid
+	for k, v := range claims {
+		token.Claims[k] = v
+	}
+	return token.SignedString(key.Private)
+}
+
+// VerifyKey finds the public key by kid, the key must match use and alg.
+func (m *Manager) VerifyKey(use, kid, alg string) (interface{}, error) {
+	m.mu.RLock()
+	key, ok := m.keys[kid]
+	m.mu.RUnlock()
+	if !ok || key.Use != use {
+		return nil, ErrWrongKid
+	}
+	if key.Alg != alg {
+		return nil, ErrWrongAlg
+	}
+	if key.retired(time.Now()) {
+		return nil, ErrKeyRetired
+	}
+	return key.Public(), nil
+}
+
+// Keyfunc used by jwt.Parse
+func (m *Manager) Keyfunc(use string) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		kid, ok := token.Header["kid"].(string)
+		if !ok {
+			return nil, ErrWrongKid
+		}
+		return m.VerifyKey(use, kid, token.Method.Alg())
+	}
+}
+
+// Purge removes retired keys
+func (m *Manager) Purge() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for kid, k := range m.keys {
+		if k.retired(now) {
+			delete(m.keys, kid)
+		}
+	}
+}
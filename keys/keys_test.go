@@ -0,0 +1,84 @@
+package keys
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func newEdKey(kid, use string) *Key {
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	return &Key{Kid: kid, Use: use, Alg: "EdDSA", Private: priv}
+}
+
+func TestManager(t *testing.T) {
+	Convey("Manager", t, func() {
+		m := NewManager()
+		m.Add(newEdKey("room1", UseRoom))
+		m.Add(NewHMACKey(UseSys, UseSys, []byte("secret")))
+
+		Convey("should sign and verify by use", func() {
+			s, err := m.Sign(UseRoom, map[string]interface{}{"rid": 1})
+			So(err, ShouldBeNil)
+			token, err := jwt.Parse(s, m.Keyfunc(UseRoom))
+			So(err, ShouldBeNil)
+			So(token.Valid, ShouldBeTrue)
+			So(token.Header["kid"], ShouldEqual, "room1")
+
+			// key of room cannot verify system token
+			_, err = jwt.Parse(s, m.Keyfunc(UseSys))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("should keep old key in overlap after rotated", func() {
+			old, err := m.Sign(UseRoom, map[string]interface{}{"rid": 1})
+			So(err, ShouldBeNil)
+
+			So(m.Rotate(newEdKey("room2", UseRoom), time.Hour), ShouldBeNil)
+			signer, ok := m.Signer(UseRoom)
+			So(ok, ShouldBeTrue)
+			So(signer.Kid, ShouldEqual, "room2")
+
+			_, err = jwt.Parse(old, m.Keyfunc(UseRoom))
+			So(err, ShouldBeNil)
+		})
+
+		Convey("should purge retired key", func() {
+			So(m.Rotate(newEdKey("room2", UseRoom), -time.Second), ShouldBeNil)
+			m.Purge()
+
+			_, err := m.VerifyKey(UseRoom, "room1", "EdDSA")
+			So(err, ShouldEqual, ErrWrongKid)
+			_, err = m.VerifyKey(UseRoom, "room2", "EdDSA")
+			So(err, ShouldBeNil)
+		})
+
+		Convey("should reject retired key", func() {
+			old, err := m.Sign(UseRoom, map[string]interface{}{"rid": 1})
+			So(err, ShouldBeNil)
+
+			So(m.Rotate(newEdKey("room2", UseRoom), -time.Second), ShouldBeNil)
+			_, err = jwt.Parse(old, m.Keyfunc(UseRoom))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("should not replace an existing kid by rotation", func() {
+			So(m.Rotate(newEdKey("room1", UseSys), time.Hour), ShouldEqual, ErrKidExists)
+			signer, ok := m.Signer(UseRoom)
+			So(ok, ShouldBeTrue)
+			So(signer.Kid, ShouldEqual, "room1")
+			So(signer.NotAfter.IsZero(), ShouldBeTrue)
+		})
+
+		Convey("should only publish public keys", func() {
+			set := m.Jwks()
+			So(len(set.Keys), ShouldEqual, 1)
+			So(set.Keys[0].Kty, ShouldEqual, "OKP")
+			So(set.Keys[0].Kid, ShouldEqual, "room1")
+		})
+	})
+}
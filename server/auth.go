@@ -6,30 +6,39 @@ import (
 	"strings"
 	"time"
 
+	"github.com/dchest/uniuri"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/empirefox/gotool/paas"
 	"github.com/empirefox/ic-server-conductor/account"
+	"github.com/empirefox/ic-server-conductor/conn"
+	"github.com/empirefox/ic-server-conductor/keys"
 	"github.com/empirefox/ic-server-conductor/proxy"
 	"github.com/gin-gonic/gin"
 	"github.com/golang/glog"
 	"github.com/itsjamie/gin-cors"
 )
 
-var ErrWrongKid = errors.New("Wrong kid")
+var (
+	// ErrNoSessionKey fails Run, session tokens need a signing key of keys.UseSession
+	ErrNoSessionKey  = errors.New(`No signing key of "session", add it to Server.Keys or Server.KeyManager`)
+	ErrWrongKid      = keys.ErrWrongKid
+	ErrTokenRevoked  = errors.New("Token revoked")
+	ErrPreAuth       = errors.New("Pre-auth token, get a new token with the totp code")
+	ErrNoIssuedToken = errors.New("No token found in issued token object")
+)
 
 func CheckIsSystemMode(c *gin.Context) {
 	if paas.IsSystemMode() {
 		return
 	}
-	c.JSON(http.StatusNotFound, gin.H{"error": 1, "content": "system running mode changed"})
-	c.Abort()
+	conn.AbortWithCode(c, http.StatusNotFound, conn.ErrCodeNotFound, "system running mode changed")
 }
 
 func (s *Server) Cors(method string) gin.HandlerFunc {
 	return cors.Middleware(cors.Config{
 		Origins:         s.Origins,
 		Methods:         method,
-		RequestHeaders:  "Origin, Authorization, Content-Type",
+		RequestHeaders:  "Origin, Authorization, Content-Type, " + TotpHeader,
 		ExposedHeaders:  "",
 		MaxAge:          48 * time.Hour,
 		Credentials:     false,
@@ -45,29 +54,95 @@ func (s *Server) SecureWs(c *gin.Context) {
 	}
 }
 
+// PostNewToken needs the totp code in TotpHeader when the account enabled totp.
+// The token is recorded as a session labeled by ?device= and user-agent.
+// It is the only route of pre-auth tokens.
 func (s *Server) PostNewToken(c *gin.Context) {
-	tokenObj, err := s.goauthConfig.NewToken(c.Keys[s.UserKey].(*account.Oauth))
+	o := c.Keys[s.UserKey].(*account.Oauth)
+	if status, code, err := conn.StepUp(&o.Account, c.Request.Header.Get(TotpHeader)); err != nil {
+		conn.AbortWithCode(c, status, code, err.Error())
+		return
+	}
+	tokenObj, err := s.newSessionToken(o)
 	if err != nil {
-		c.AbortWithError(http.StatusInternalServerError, err)
+		conn.AbortWithCode(c, http.StatusInternalServerError, conn.ErrCodeInternal, err.Error())
+		return
+	}
+	if err := s.recordSession(c, o, issuedToken(tokenObj), true); err != nil {
+		conn.AbortWithCode(c, http.StatusInternalServerError, conn.ErrCodeDbError, err.Error())
 		return
 	}
 	c.JSON(http.StatusOK, tokenObj)
 }
 
+// newSessionToken signs the token of o by the session key of KeyManager,
+// in the Satellizer compatible {"token":""}.
+func (s *Server) newSessionToken(o *account.Oauth) (gin.H, error) {
+	now := time.Now()
+	token, err := s.KeyManager.Sign(keys.UseSession, map[string]interface{}{
+		"prd": o.Provider,
+		"oid": o.Oid,
+		"iat": now.Unix(),
+		"exp": now.Add(account.SessionMaxAge).Unix(),
+		"jti": uniuri.New(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return gin.H{"token": token}, nil
+}
+
+// verifySession verifies tokens signed by KeyManager, tokens without kid
+// are issued by provider logins and verified by goauth.
+func (s *Server) verifySession(o *account.Oauth, token []byte) error {
+	if conn.TokenKid(token) == "" {
+		return s.goauthConfig.Verify(o, token)
+	}
+	t, err := jwt.Parse(string(token), s.KeyManager.Keyfunc(keys.UseSession))
+	if err != nil {
+		return err
+	}
+	if !t.Valid {
+		return conn.ErrInvalidToken
+	}
+	prd, _ := t.Claims["prd"].(string)
+	oid, _ := t.Claims["oid"].(string)
+	return o.Find(prd, oid)
+}
+
+// SessionAuth binds the Oauth of "Authorization: Bearer [token]",
+// as goauth MustBindUser does for tokens of provider logins.
+func (s *Server) SessionAuth(c *gin.Context) {
+	o := &account.Oauth{}
+	if err := s.verifySession(o, []byte(requestToken(c))); err != nil {
+		conn.AbortWithCode(c, http.StatusUnauthorized, conn.ErrCodeBadToken, err.Error())
+		return
+	}
+	c.Set(s.UserKey, o)
+}
+
+// PostProxyToken issues the token of a proxied provider login,
+// it is pre-auth when the account enabled totp.
 func (s *Server) PostProxyToken(c *gin.Context) {
 	var data proxy.PostProxyTokenData
 	if err := c.BindJSON(&data); err != nil {
-		c.AbortWithError(http.StatusBadRequest, err)
+		conn.AbortWithCode(c, http.StatusBadRequest, conn.ErrCodeBadRequest, err.Error())
 		return
 	}
 
-	if reqToken, err := jwt.Parse(data.Token, s.goauthConfig.FindVerifyKey); err == nil {
+	// link the provider to the logged in account
+	if conn.TokenKid([]byte(data.Token)) != "" {
+		o := &account.Oauth{}
+		if err := s.verifySession(o, []byte(data.Token)); err == nil {
+			c.Set(s.UserKey, o)
+		}
+	} else if reqToken, err := jwt.Parse(data.Token, s.goauthConfig.FindVerifyKey); err == nil {
 		c.Set(s.ClaimsKey, reqToken.Claims)
 		s.goauthConfig.BindUser(c)
 	}
 
 	if err := s.goauthConfig.HandleUserInfo(c, &data.Info); err != nil {
-		c.AbortWithError(http.StatusInternalServerError, err)
+		conn.AbortWithCode(c, http.StatusInternalServerError, conn.ErrCodeInternal, err.Error())
 	}
 }
 
@@ -95,27 +170,44 @@ func (s *Server) GetProxiedProviders(c *gin.Context) {
 	c.JSON(http.StatusOK, ps)
 }
 
-func (s *Server) Auth(kid string) gin.HandlerFunc {
+// Auth accepts tokens signed by any active key of the use
+func (s *Server) Auth(use string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		token, err := jwt.ParseFromRequest(c.Request, func(token *jwt.Token) (interface{}, error) {
-			reqKid, ok := token.Header["kid"]
-			if !ok {
-				return nil, ErrWrongKid
-			}
-			if req, ok := reqKid.(string); !ok || req != kid {
-				return nil, ErrWrongKid
-			}
-			return s.Keys[kid], nil
-		})
+		token, err := jwt.ParseFromRequest(c.Request, s.KeyManager.Keyfunc(use))
 
 		if err != nil {
-			c.AbortWithError(http.StatusUnauthorized, err)
+			conn.AbortWithCode(c, http.StatusUnauthorized, conn.ErrCodeBadToken, err.Error())
 			return
 		}
 		c.Set(s.ClaimsKey, token.Claims)
 	}
 }
 
+// GetJwks publishes public keys, so Ones and proxies can verify offline
+func (s *Server) GetJwks(c *gin.Context) {
+	c.Header("Cache-Control", "max-age=300")
+	c.JSON(http.StatusOK, s.KeyManager.Jwks())
+}
+
+// Verify accepts tokens of goauth and api keys,
+// revoked and pre-auth tokens are rejected.
 func (s *Server) Verify(o *account.Oauth, token []byte) error {
-	return s.goauthConfig.Verify(o, token)
+	if account.IsApiKey(string(token)) {
+		return o.FindByApiKey(string(token))
+	}
+	if account.IsTokenRevoked(account.TokenJti(string(token))) {
+		return ErrTokenRevoked
+	}
+	if err := s.verifySession(o, token); err != nil {
+		return err
+	}
+	pre, err := o.PreAuth(string(token))
+	if err != nil {
+		return err
+	}
+	if pre {
+		return ErrPreAuth
+	}
+	s.seenSession(o, string(token), "")
+	return nil
 }
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/empirefox/ic-server-conductor/account"
+	"github.com/empirefox/ic-server-conductor/conn"
+)
+
+type enabledData struct {
+	Enabled bool `json:"enabled"`
+}
+
+// bindEnabled reads the id param and {"enabled":bool}
+func bindEnabled(c *gin.Context) (uint, bool, bool) {
+	id, ok := paramUint(c, "id")
+	if !ok {
+		return 0, false, false
+	}
+	var data enabledData
+	if err := c.BindJSON(&data); err != nil {
+		conn.AbortWithCode(c, http.StatusBadRequest, conn.ErrCodeBadRequest, "enabled required")
+		return 0, false, false
+	}
+	return id, data.Enabled, true
+}
+
+// PutRoomEnabled is used by the owner, the online room is disconnected when disabled
+func (s *Server) PutRoomEnabled(c *gin.Context) {
+	one, o, ok := s.findManagedRoom(c)
+	if !ok {
+		return
+	}
+	_, enabled, ok := bindEnabled(c)
+	if !ok {
+		return
+	}
+	if status, code, err := conn.SetRoomEnabled(s.Hub, o, one, enabled); err != nil {
+		conn.AbortWithCode(c, status, code, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"room": one.ID, "enabled": enabled})
+}
+
+// PutSysRoomEnabled is used by admin, the owner can not enable the room disabled here
+func (s *Server) PutSysRoomEnabled(c *gin.Context) {
+	id, enabled, ok := bindEnabled(c)
+	if !ok {
+		return
+	}
+	one := &account.One{}
+	if err := one.Find(id); err != nil {
+		conn.AbortWithCode(c, http.StatusNotFound, conn.ErrCodeNotFound, "room not found")
+		return
+	}
+	if status, code, err := conn.SetSysRoomEnabled(s.Hub, one, enabled); err != nil {
+		conn.AbortWithCode(c, status, code, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"room": id, "enabled": enabled})
+}
+
+func (s *Server) PutSysAccountEnabled(c *gin.Context) {
+	id, enabled, ok := bindEnabled(c)
+	if !ok {
+		return
+	}
+	if status, code, err := conn.SetAccountEnabled(s.Hub, id, enabled); err != nil {
+		conn.AbortWithCode(c, status, code, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"account": id, "enabled": enabled})
+}
+
+func (s *Server) PutSysOauthEnabled(c *gin.Context) {
+	id, enabled, ok := bindEnabled(c)
+	if !ok {
+		return
+	}
+	o, status, code, err := conn.SetOauthEnabled(s.Hub, id, enabled)
+	if err != nil {
+		conn.AbortWithCode(c, status, code, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"oauth": id, "account": o.AccountId, "enabled": enabled})
+}
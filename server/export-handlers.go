@@ -0,0 +1,52 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/empirefox/ic-server-conductor/account"
+	"github.com/empirefox/ic-server-conductor/conn"
+	"github.com/empirefox/ic-server-conductor/export"
+)
+
+// PostExport starts exporting all data of the user,
+// poll the status then download when ready.
+func (s *Server) PostExport(c *gin.Context) {
+	o := c.Keys[s.UserKey].(*account.Oauth)
+	j, err := s.Exports.Start(&o.Account)
+	if err != nil {
+		conn.AbortWithCode(c, http.StatusConflict, conn.ErrCodeBadRequest, err.Error())
+		return
+	}
+	c.JSON(http.StatusAccepted, j)
+}
+
+func (s *Server) findExport(c *gin.Context) (export.Job, []byte, bool) {
+	o := c.Keys[s.UserKey].(*account.Oauth)
+	j, data, ok := s.Exports.Get(c.Params.ByName("id"), o.AccountId)
+	if !ok {
+		conn.AbortWithCode(c, http.StatusNotFound, conn.ErrCodeNotFound, "export not found")
+	}
+	return j, data, ok
+}
+
+func (s *Server) GetExport(c *gin.Context) {
+	if j, _, ok := s.findExport(c); ok {
+		c.JSON(http.StatusOK, j)
+	}
+}
+
+func (s *Server) GetExportDownload(c *gin.Context) {
+	j, data, ok := s.findExport(c)
+	if !ok {
+		return
+	}
+	if j.Status != export.StatusReady {
+		conn.AbortWithCode(c, http.StatusConflict, conn.ErrCodeBadRequest, "export is "+j.Status)
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="account-%d.json"`, j.AccountId))
+	c.Data(http.StatusOK, "application/json; charset=utf-8", data)
+}
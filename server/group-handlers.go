@@ -0,0 +1,119 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/empirefox/ic-server-conductor/account"
+	"github.com/empirefox/ic-server-conductor/conn"
+)
+
+type groupData struct {
+	Name string `json:"name"`
+	Sort int    `json:"sort"`
+}
+
+type viewData struct {
+	Group uint     `json:"group"`
+	Tags  []string `json:"tags"`
+}
+
+func bindGroup(c *gin.Context) (*groupData, bool) {
+	var data groupData
+	if err := c.BindJSON(&data); err != nil || data.Name == "" || len(data.Name) > 64 {
+		conn.AbortWithCode(c, http.StatusBadRequest, conn.ErrCodeBadRequest, "name required")
+		return nil, false
+	}
+	return &data, true
+}
+
+func (s *Server) GetGroups(c *gin.Context) {
+	o := c.Keys[s.UserKey].(*account.Oauth)
+	var gs []account.RoomGroup
+	if err := o.Account.Groups(&gs); err != nil {
+		conn.AbortWithCode(c, http.StatusInternalServerError, conn.ErrCodeDbError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gs)
+}
+
+func (s *Server) PostGroup(c *gin.Context) {
+	data, ok := bindGroup(c)
+	if !ok {
+		return
+	}
+	o := c.Keys[s.UserKey].(*account.Oauth)
+	g := &account.RoomGroup{AccountId: o.AccountId, Name: data.Name, Sort: data.Sort}
+	if err := g.Save(); err != nil {
+		conn.AbortWithCode(c, http.StatusInternalServerError, conn.ErrCodeDbError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, g)
+}
+
+func (s *Server) findGroup(c *gin.Context) (*account.RoomGroup, bool) {
+	id, ok := paramUint(c, "id")
+	if !ok {
+		return nil, false
+	}
+	o := c.Keys[s.UserKey].(*account.Oauth)
+	g := &account.RoomGroup{}
+	if err := g.FindIfOwner(id, o.AccountId); err != nil {
+		conn.AbortWithCode(c, http.StatusNotFound, conn.ErrCodeNotFound, "group not found")
+		return nil, false
+	}
+	return g, true
+}
+
+func (s *Server) PutGroup(c *gin.Context) {
+	g, ok := s.findGroup(c)
+	if !ok {
+		return
+	}
+	data, ok := bindGroup(c)
+	if !ok {
+		return
+	}
+	g.Name = data.Name
+	g.Sort = data.Sort
+	if err := g.Save(); err != nil {
+		conn.AbortWithCode(c, http.StatusInternalServerError, conn.ErrCodeDbError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, g)
+}
+
+func (s *Server) DeleteGroup(c *gin.Context) {
+	g, ok := s.findGroup(c)
+	if !ok {
+		return
+	}
+	if err := g.Delete(); err != nil {
+		conn.AbortWithCode(c, http.StatusInternalServerError, conn.ErrCodeDbError, err.Error())
+		return
+	}
+	c.AbortWithStatus(http.StatusOK)
+}
+
+// PutView sets group and tags of a viewed room, group 0 means ungrouped.
+func (s *Server) PutView(c *gin.Context) {
+	room, ok := paramUint(c, "room")
+	if !ok {
+		return
+	}
+	var data viewData
+	if err := c.BindJSON(&data); err != nil {
+		conn.AbortWithCode(c, http.StatusBadRequest, conn.ErrCodeBadRequest, "group or tags required")
+		return
+	}
+	o := c.Keys[s.UserKey].(*account.Oauth)
+	switch err := o.Account.SetView(room, data.Group, data.Tags); err {
+	case nil:
+		c.AbortWithStatus(http.StatusOK)
+	case account.ErrTagsTooLong:
+		conn.AbortWithCode(c, http.StatusBadRequest, conn.ErrCodeBadRequest, err.Error())
+	default:
+		conn.AbortWithCode(c, http.StatusNotFound, conn.ErrCodeNotFound, "view or group not found")
+	}
+}
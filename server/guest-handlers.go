@@ -0,0 +1,204 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+	"github.com/gorilla/websocket"
+
+	"github.com/empirefox/ic-server-conductor/account"
+	"github.com/empirefox/ic-server-conductor/conn"
+	"github.com/empirefox/ic-server-conductor/keys"
+)
+
+// GuestTokenTTL caps the exp of a token opened from a guest link
+var GuestTokenTTL = time.Hour
+
+// ErrNoGuestKey fails Run, guest tokens need a signing key of keys.UseGuest
+var ErrNoGuestKey = errors.New(`No signing key of "guest", add it to Server.Keys or Server.KeyManager`)
+
+type guestLinkData struct {
+	Camera      string    `json:"camera"`
+	MaxSessions int       `json:"maxSessions"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+// PostRoomGuest creates a guest link, the code is only in this reply.
+func (s *Server) PostRoomGuest(c *gin.Context) {
+	one, o, ok := s.findManagedRoom(c)
+	if !ok {
+		return
+	}
+	var data guestLinkData
+	if err := c.BindJSON(&data); err != nil {
+		conn.AbortWithCode(c, http.StatusBadRequest, conn.ErrCodeBadRequest, "expiresAt and maxSessions required")
+		return
+	}
+	l, code, err := o.Account.NewGuestLink(one, data.Camera, data.MaxSessions, data.ExpiresAt)
+	switch err {
+	case nil:
+		c.JSON(http.StatusOK, gin.H{"link": l, "code": code})
+	case account.ErrGuestExpired, account.ErrGuestSessions:
+		conn.AbortWithCode(c, http.StatusBadRequest, conn.ErrCodeBadRequest, err.Error())
+	default:
+		conn.AbortWithCode(c, http.StatusInternalServerError, conn.ErrCodeDbError, err.Error())
+	}
+}
+
+type guestLinkReply struct {
+	account.GuestLink
+	Sessions int `json:"sessions"`
+}
+
+// GetRoomGuests lists guest links with their active sessions
+func (s *Server) GetRoomGuests(c *gin.Context) {
+	one, _, ok := s.findManagedRoom(c)
+	if !ok {
+		return
+	}
+	var ls []account.GuestLink
+	if err := one.GuestLinks(&ls); err != nil {
+		conn.AbortWithCode(c, http.StatusInternalServerError, conn.ErrCodeDbError, err.Error())
+		return
+	}
+	room, online := s.Hub.GetRoom(one.ID)
+	replies := make([]guestLinkReply, 0, len(ls))
+	for _, l := range ls {
+		r := guestLinkReply{GuestLink: l}
+		if online {
+			r.Sessions = guestSessions(room, l.ID)
+		}
+		replies = append(replies, r)
+	}
+	c.JSON(http.StatusOK, replies)
+}
+
+// DeleteRoomGuest revokes the link, active guests of it are stopped.
+func (s *Server) DeleteRoomGuest(c *gin.Context) {
+	one, _, ok := s.findManagedRoom(c)
+	if !ok {
+		return
+	}
+	id, ok := paramUint(c, "guest")
+	if !ok {
+		return
+	}
+	l := &account.GuestLink{}
+	if err := l.Find(id); err != nil || l.OneId != one.ID {
+		conn.AbortWithCode(c, http.StatusNotFound, conn.ErrCodeNotFound, "guest link not found")
+		return
+	}
+	if err := l.Delete(); err != nil {
+		conn.AbortWithCode(c, http.StatusInternalServerError, conn.ErrCodeDbError, err.Error())
+		return
+	}
+	if room, ok := s.Hub.GetRoom(one.ID); ok {
+		room.EndSignalings(func(s *conn.Signaling) bool { return s.GuestId == l.ID })
+	}
+	c.JSON(http.StatusOK, gin.H{"room": one.ID, "guest": l.ID})
+}
+
+type guestTokenData struct {
+	Code string `json:"code"`
+}
+
+// PostGuestToken opens a guest link, no account required.
+// The token is only accepted by WsManySignaling.
+func (s *Server) PostGuestToken(c *gin.Context) {
+	var data guestTokenData
+	if err := c.BindJSON(&data); err != nil || data.Code == "" {
+		conn.AbortWithCode(c, http.StatusBadRequest, conn.ErrCodeBadRequest, "code required")
+		return
+	}
+	l := &account.GuestLink{}
+	if err := l.FindByCode(data.Code); err != nil {
+		conn.AbortWithCode(c, http.StatusNotFound, conn.ErrCodeNotFound, "guest link not found or expired")
+		return
+	}
+	token, exp, err := s.newGuestToken(l)
+	if err != nil {
+		conn.AbortWithCode(c, http.StatusInternalServerError, conn.ErrCodeInternal, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"token": token, "exp": exp.Unix(), "room": l.OneId, "camera": l.Camera})
+}
+
+func (s *Server) newGuestToken(l *account.GuestLink) (string, time.Time, error) {
+	now := time.Now()
+	exp := now.Add(GuestTokenTTL)
+	if l.ExpiresAt.Before(exp) {
+		exp = l.ExpiresAt
+	}
+	token, err := s.KeyManager.Sign(keys.UseGuest, map[string]interface{}{
+		"gid": l.ID,
+		"rid": l.OneId,
+		"iat": now.Unix(),
+		"exp": exp.Unix(),
+	})
+	return token, exp, err
+}
+
+// parseGuestToken returns the guest link id of a valid guest token
+func (s *Server) parseGuestToken(token string) (uint, bool) {
+	if s.KeyManager == nil {
+		return 0, false
+	}
+	t, err := jwt.Parse(token, s.KeyManager.Keyfunc(keys.UseGuest))
+	if err != nil || !t.Valid {
+		return 0, false
+	}
+	gid, ok := t.Claims["gid"].(float64)
+	return uint(gid), ok && gid > 0
+}
+
+func guestSessions(room conn.ControlRoom, guestId uint) int {
+	n := 0
+	for _, s := range room.Presence(room.GetOne().OwnerId).Signalings {
+		if s.GuestId == guestId {
+			n++
+		}
+	}
+	return n
+}
+
+// The link is checked on every signaling start, so revoked links
+// cannot be used even if the token is not expired.
+func preProccessGuestSignaling(h conn.Hub, info *StartSignalingInfo, guestId uint, stop func()) (chan *websocket.Conn, conn.ErrorCode) {
+	l := &account.GuestLink{}
+	if err := l.Find(guestId); err != nil || l.Expired() {
+		glog.Infoln("Guest link revoked or expired")
+		return nil, conn.ErrCodeNotPermitted
+	}
+	if l.OneId != info.Room || (l.Camera != "" && l.Camera != info.Camera) {
+		glog.Infoln("Guest link is not for this room or camera")
+		return nil, conn.ErrCodeNotPermitted
+	}
+	room, ok := h.GetRoom(info.Room)
+	if !ok {
+		return nil, conn.ErrCodeRoomOffline
+	}
+	if !room.GetOne().Usable() {
+		return nil, conn.ErrCodeNotPermitted
+	}
+	sig := &conn.Signaling{
+		Reciever:  info.Reciever,
+		GuestId:   l.ID,
+		Camera:    info.Camera,
+		StartedAt: time.Now(),
+		Stop:      stop,
+		ExpiresAt: l.ExpiresAt,
+	}
+	if !room.AddGuestSignaling(sig, l.MaxSessions) {
+		glog.Infoln("Guest link max sessions reached")
+		return nil, conn.ErrCodeNotPermitted
+	}
+	res, code := startSignaling(h, room, info, sig)
+	if code != "" {
+		room.RemoveSignaling(info.Reciever)
+	}
+	return res, code
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/empirefox/ic-server-conductor/account"
+	"github.com/empirefox/ic-server-conductor/account/accounttest"
+	"github.com/empirefox/ic-server-conductor/conn"
+	"github.com/empirefox/ic-server-conductor/conn/hub"
+	"github.com/empirefox/ic-server-conductor/keys"
+)
+
+func TestGuestHandlers(t *testing.T) {
+	Convey("guest handlers", t, func() {
+		owner := accounttest.Setup()
+		viewer := accounttest.Login("viewer")
+		s := &Server{
+			UserKey:    "user",
+			Hub:        hub.NewHub(),
+			KeyManager: keys.NewManager(keys.NewHMACKey("guest1", keys.UseGuest, []byte("secret"))),
+		}
+
+		one := &account.One{Name: "room", Addr: "addr"}
+		So(owner.Account.RegOne(one), ShouldBeNil)
+		path := fmt.Sprintf("/rooms/%d/guests", one.ID)
+		data := guestLinkData{Camera: "door", MaxSessions: 1, ExpiresAt: time.Now().Add(time.Hour)}
+
+		Convey("should be created by owner only", func() {
+			w := serve(s, "POST", "/rooms/:id/guests", path, s.PostRoomGuest, viewer, data)
+			So(w.Code, ShouldEqual, http.StatusForbidden)
+
+			expired := data
+			expired.ExpiresAt = time.Now().Add(-time.Minute)
+			w = serve(s, "POST", "/rooms/:id/guests", path, s.PostRoomGuest, owner, expired)
+			So(w.Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("should open, list and revoke", func() {
+			w := serve(s, "POST", "/rooms/:id/guests", path, s.PostRoomGuest, owner, data)
+			So(w.Code, ShouldEqual, http.StatusOK)
+			var created struct {
+				Link account.GuestLink `json:"link"`
+				Code string            `json:"code"`
+			}
+			So(json.Unmarshal(w.Body.Bytes(), &created), ShouldBeNil)
+			So(created.Code, ShouldNotBeEmpty)
+			So(w.Body.String(), ShouldNotContainSubstring, "CodeHash")
+
+			w = serve(s, "POST", "/guest/token", "/guest/token", s.PostGuestToken, nil, guestTokenData{Code: "wrong"})
+			So(w.Code, ShouldEqual, http.StatusNotFound)
+			w = serve(s, "POST", "/guest/token", "/guest/token", s.PostGuestToken, nil, guestTokenData{Code: created.Code})
+			So(w.Code, ShouldEqual, http.StatusOK)
+			var opened struct {
+				Token string `json:"token"`
+			}
+			So(json.Unmarshal(w.Body.Bytes(), &opened), ShouldBeNil)
+			gid, ok := s.parseGuestToken(opened.Token)
+			So(ok, ShouldBeTrue)
+			So(gid, ShouldEqual, created.Link.ID)
+
+			info := &StartSignalingInfo{Room: one.ID, Camera: "office", Reciever: "r1"}
+			_, code := preProccessGuestSignaling(s.Hub, info, gid, nil)
+			So(code, ShouldEqual, conn.ErrCodeNotPermitted)
+			info.Camera = "door"
+			_, code = preProccessGuestSignaling(s.Hub, info, gid, nil)
+			So(code, ShouldEqual, conn.ErrCodeRoomOffline)
+
+			w = serve(s, "GET", "/rooms/:id/guests", path, s.GetRoomGuests, owner, nil)
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(w.Body.String(), ShouldContainSubstring, `"sessions":0`)
+
+			gpath := fmt.Sprintf("%s/%d", path, gid)
+			w = serve(s, "DELETE", "/rooms/:id/guests/:guest", gpath, s.DeleteRoomGuest, owner, nil)
+			So(w.Code, ShouldEqual, http.StatusOK)
+			_, code = preProccessGuestSignaling(s.Hub, info, gid, nil)
+			So(code, ShouldEqual, conn.ErrCodeNotPermitted)
+			w = serve(s, "POST", "/guest/token", "/guest/token", s.PostGuestToken, nil, guestTokenData{Code: created.Code})
+			So(w.Code, ShouldEqual, http.StatusNotFound)
+		})
+	})
+}
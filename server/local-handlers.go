@@ -0,0 +1,171 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/empirefox/ic-server-conductor/account"
+	"github.com/empirefox/ic-server-conductor/conn"
+)
+
+type localUserData struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Name     string `json:"name,omitempty"`
+	Totp     string `json:"totp,omitempty"`
+}
+
+// PostSysLocalUser creates an account with local credentials
+func (s *Server) PostSysLocalUser(c *gin.Context) {
+	var data localUserData
+	if err := c.BindJSON(&data); err != nil || data.Username == "" {
+		conn.AbortWithCode(c, http.StatusBadRequest, conn.ErrCodeBadRequest, "username and password required")
+		return
+	}
+	o, err := account.NewLocalUser(data.Username, data.Password, data.Name)
+	switch err {
+	case nil:
+		c.JSON(http.StatusOK, gin.H{"account": o.AccountId, "username": o.Oid})
+	case account.ErrWeakPassword:
+		conn.AbortWithCode(c, http.StatusBadRequest, conn.ErrCodeBadRequest, err.Error())
+	case account.ErrUsernameTaken:
+		conn.AbortWithCode(c, http.StatusConflict, conn.ErrCodeBadRequest, err.Error())
+	default:
+		conn.AbortWithCode(c, http.StatusInternalServerError, conn.ErrCodeDbError, err.Error())
+	}
+}
+
+// PostLocalLogin replies the same token as oauth providers do,
+// totp is required when enabled.
+func (s *Server) PostLocalLogin(c *gin.Context) {
+	var data localUserData
+	if err := c.BindJSON(&data); err != nil {
+		conn.AbortWithCode(c, http.StatusBadRequest, conn.ErrCodeBadRequest, "username and password required")
+		return
+	}
+	o := &account.Oauth{}
+	if err := o.LoginLocal(data.Username, data.Password); err != nil {
+		conn.AbortWithCode(c, http.StatusUnauthorized, conn.ErrCodeBadToken, err.Error())
+		return
+	}
+	if status, code, err := conn.StepUp(&o.Account, data.Totp); err != nil {
+		conn.AbortWithCode(c, status, code, err.Error())
+		return
+	}
+	tokenObj, err := s.newSessionToken(o)
+	if err != nil {
+		conn.AbortWithCode(c, http.StatusInternalServerError, conn.ErrCodeInternal, err.Error())
+		return
+	}
+	if err := s.recordSession(c, o, issuedToken(tokenObj), true); err != nil {
+		conn.AbortWithCode(c, http.StatusInternalServerError, conn.ErrCodeDbError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, tokenObj)
+}
+
+type passwordData struct {
+	Current  string `json:"current"`
+	Password string `json:"password"`
+}
+
+// PutPassword changes the password when logged in by local credentials,
+// the current password is required, and StepUp before when totp enabled.
+func (s *Server) PutPassword(c *gin.Context) {
+	var data passwordData
+	if err := c.BindJSON(&data); err != nil {
+		conn.AbortWithCode(c, http.StatusBadRequest, conn.ErrCodeBadRequest, "password required")
+		return
+	}
+	o := c.Keys[s.UserKey].(*account.Oauth)
+	switch err := o.ChangePassword(data.Current, data.Password); err {
+	case nil:
+		c.AbortWithStatus(http.StatusOK)
+	case account.ErrWeakPassword:
+		conn.AbortWithCode(c, http.StatusBadRequest, conn.ErrCodeBadRequest, err.Error())
+	case account.ErrBadCredentials, account.ErrNotLocal:
+		conn.AbortWithCode(c, http.StatusForbidden, conn.ErrCodeNotPermitted, err.Error())
+	default:
+		conn.AbortWithCode(c, http.StatusInternalServerError, conn.ErrCodeDbError, err.Error())
+	}
+}
+
+type apiKeyReply struct {
+	ID        uint      `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+	Key       string    `json:"key,omitempty"`
+}
+
+func (s *Server) GetApiKeys(c *gin.Context) {
+	o := c.Keys[s.UserKey].(*account.Oauth)
+	var keys []account.Oauth
+	if err := o.Account.ApiKeys(&keys); err != nil {
+		conn.AbortWithCode(c, http.StatusInternalServerError, conn.ErrCodeDbError, err.Error())
+		return
+	}
+	replies := make([]apiKeyReply, 0, len(keys))
+	for _, k := range keys {
+		replies = append(replies, apiKeyReply{ID: k.ID, Name: k.Name, CreatedAt: k.CreatedAt})
+	}
+	c.JSON(http.StatusOK, replies)
+}
+
+type apiKeyData struct {
+	Name string `json:"name"`
+}
+
+// PostApiKey creates an api key, the key is only in this reply.
+func (s *Server) PostApiKey(c *gin.Context) {
+	var data apiKeyData
+	if err := c.BindJSON(&data); err != nil || data.Name == "" {
+		conn.AbortWithCode(c, http.StatusBadRequest, conn.ErrCodeBadRequest, "name required")
+		return
+	}
+	o := c.Keys[s.UserKey].(*account.Oauth)
+	k, key, err := o.Account.NewApiKey(data.Name)
+	if err != nil {
+		conn.AbortWithCode(c, http.StatusInternalServerError, conn.ErrCodeDbError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, apiKeyReply{ID: k.ID, Name: k.Name, CreatedAt: k.CreatedAt, Key: key})
+}
+
+// DeleteApiKey revokes the key, api key sockets of the account are kicked
+// and only the valid ones can connect again.
+func (s *Server) DeleteApiKey(c *gin.Context) {
+	id, ok := paramUint(c, "id")
+	if !ok {
+		return
+	}
+	o := c.Keys[s.UserKey].(*account.Oauth)
+	switch err := o.Account.RevokeApiKey(id); err {
+	case nil:
+		s.Hub.OnKick(&conn.Kick{AccountId: o.AccountId, Provider: account.ProviderApiKey, Reason: "ApiKeyRevoked"})
+		c.JSON(http.StatusOK, gin.H{"id": id})
+	case account.ErrRecordNotFound:
+		conn.AbortWithCode(c, http.StatusNotFound, conn.ErrCodeNotFound, "api key not found")
+	default:
+		conn.AbortWithCode(c, http.StatusInternalServerError, conn.ErrCodeDbError, err.Error())
+	}
+}
+
+// NoApiKey guards destructive rest api, api keys only automate the others
+func (s *Server) NoApiKey(c *gin.Context) {
+	o := c.Keys[s.UserKey].(*account.Oauth)
+	if status, code, err := conn.NoApiKey(o); err != nil {
+		conn.AbortWithCode(c, status, code, err.Error())
+	}
+}
+
+// ApiKeyAuth binds the Oauth of "Authorization: Bearer ick_..."
+func (s *Server) ApiKeyAuth(c *gin.Context) {
+	o := &account.Oauth{}
+	if err := o.FindByApiKey(requestToken(c)); err != nil {
+		conn.AbortWithCode(c, http.StatusUnauthorized, conn.ErrCodeBadToken, err.Error())
+		return
+	}
+	c.Set(s.UserKey, o)
+}
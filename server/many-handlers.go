@@ -19,7 +19,7 @@ import (
 
 func (s *Server) GetOauths(c *gin.Context) {
 	if data, err := account.SatellizerProviders(); err != nil {
-		c.AbortWithError(http.StatusInternalServerError, err)
+		conn.AbortWithCode(c, http.StatusInternalServerError, conn.ErrCodeDbError, err.Error())
 	} else {
 		c.Data(http.StatusOK, "application/json; charset=utf-8", data)
 	}
@@ -43,6 +43,7 @@ func (s *Server) GetSystemData(c *gin.Context) {
 
 type StartSignalingInfo struct {
 	Room     uint   `json:"room"`
+	Camera   string `json:"camera,omitempty"`
 	Reciever string `json:"reciever"`
 	Token    string `json:"token"`
 }
@@ -65,19 +66,29 @@ func (s *Server) WsManySignaling(c *gin.Context) {
 	var info StartSignalingInfo
 	if err = json.Unmarshal(startInfo, &info); err != nil {
 		glog.Infoln("Unmarshal info err:", err)
+		ws.WriteMessage(websocket.TextMessage, conn.ManyError(conn.ErrCodeBadRequest, "Bad start info"))
 		return
 	}
 
-	o := &account.Oauth{}
-	if err = s.Verify(o, []byte(info.Token)); err != nil {
-		ws.WriteMessage(websocket.TextMessage, []byte(`{"type":"AuthFailed"}`))
-		return
+	var res chan *websocket.Conn
+	var code conn.ErrorCode
+	stop := func() { ws.Close() }
+	if guestId, ok := s.parseGuestToken(info.Token); ok {
+		res, code = preProccessGuestSignaling(s.Hub, &info, guestId, stop)
+	} else {
+		o := &account.Oauth{}
+		if err = s.Verify(o, []byte(info.Token)); err != nil {
+			reply := &conn.ErrorReply{Type: "AuthFailed", Error: 1, Code: conn.ErrCodeBadToken}
+			ws.WriteMessage(websocket.TextMessage, reply.Bytes())
+			return
+		}
+		res, code = preProccessSignaling(s.Hub, &info, o, stop)
 	}
-
-	res := preProccessSignaling(s.Hub, &info, o)
 	if res == nil {
+		ws.WriteMessage(websocket.TextMessage, conn.ManyError(code, "Cannot start signaling"))
 		return
 	}
+	defer s.endSignaling(&info)
 	err = ws.WriteMessage(websocket.TextMessage, []byte(`{"type":"Accepted"}`))
 	if err != nil {
 		s.Hub.ProcessFromWait(info.Reciever)
@@ -95,11 +106,16 @@ func (s *Server) WsManySignaling(c *gin.Context) {
 	res <- nil
 }
 
-func preProccessSignaling(h conn.Hub, info *StartSignalingInfo, o *account.Oauth) chan *websocket.Conn {
+// stop ends the signaling when the view of o is closed
+func preProccessSignaling(h conn.Hub, info *StartSignalingInfo, o *account.Oauth, stop func()) (chan *websocket.Conn, conn.ErrorCode) {
 	room, ok := h.GetRoom(info.Room)
 	if !ok {
 		glog.Infoln("Room not found in request")
-		return nil
+		return nil, conn.ErrCodeRoomOffline
+	}
+	if !o.Valid() || !room.GetOne().Usable() {
+		glog.Infoln("User or room disabled")
+		return nil, conn.ErrCodeNotPermitted
 	}
 	if !o.CanView(room.GetOne()) {
 		b1, _ := json.MarshalIndent(o, "", "\t")
@@ -107,28 +123,95 @@ func preProccessSignaling(h conn.Hub, info *StartSignalingInfo, o *account.Oauth
 		glog.Infoln(string(b1))
 		glog.Infoln(string(b2))
 		glog.Infoln("Not permited to view this room")
-		return nil
+		return nil, conn.ErrCodeNotPermitted
 	}
+	if !o.CanViewCamera(room.GetOne(), info.Camera) {
+		glog.Infoln("Not permited to view this camera:", info.Camera)
+		return nil, conn.ErrCodeNotPermitted
+	}
+	return startSignaling(h, room, info, &conn.Signaling{
+		Reciever:  info.Reciever,
+		AccountId: o.AccountId,
+		Camera:    info.Camera,
+		StartedAt: time.Now(),
+		Stop:      stop,
+		Jti:       account.TokenJti(info.Token),
+	})
+}
+
+// startSignaling asks One to connect, from is 0 for guests
+func startSignaling(h conn.Hub, room conn.ControlRoom, info *StartSignalingInfo, sig *conn.Signaling) (chan *websocket.Conn, conn.ErrorCode) {
 	res, err := h.WaitForProcess(info.Reciever)
 	if err != nil {
 		glog.Infoln("Wait for process:", err)
-		return nil
+		return nil, conn.ErrCodeBadRequest
 	}
 	cmd := fmt.Sprintf(`{
 		"name":"CreateSignalingConnection",
 		"from":%d,
 		"content":"%s"
-	}`, o.AccountId, info.Reciever)
+	}`, sig.AccountId, info.Reciever)
 	room.Send([]byte(cmd))
-	return res
+	room.AddSignaling(sig)
+	return res, ""
+}
+
+func (s *Server) endSignaling(info *StartSignalingInfo) {
+	if room, ok := s.Hub.GetRoom(info.Room); ok {
+		room.RemoveSignaling(info.Reciever)
+	}
 }
 
 func (s *Server) GetAccountProviders(c *gin.Context) {
 	o := c.Keys[s.UserKey].(*account.Oauth)
 	ps := []string{}
 	if err := o.GetProviders(&ps); err != nil {
-		c.AbortWithError(http.StatusInternalServerError, err)
+		conn.AbortWithCode(c, http.StatusInternalServerError, conn.ErrCodeDbError, err.Error())
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"current": o.Provider, "providers": ps})
 }
+
+type rotateRoomTokenData struct {
+	Room uint `json:"room"`
+}
+
+// PostRotateRoomToken revokes the token of an owned room.
+// Online One will receive the new token, offline One must reg again.
+func (s *Server) PostRotateRoomToken(c *gin.Context) {
+	var data rotateRoomTokenData
+	if err := c.BindJSON(&data); err != nil {
+		conn.AbortWithCode(c, http.StatusBadRequest, conn.ErrCodeBadRequest, "room required")
+		return
+	}
+	o := c.Keys[s.UserKey].(*account.Oauth)
+	one := &account.One{}
+	if err := one.FindIfOwner(data.Room, o.AccountId); err != nil {
+		conn.AbortWithCode(c, http.StatusForbidden, conn.ErrCodeNotOwner, "not the owner of the room")
+		return
+	}
+	if room, ok := s.Hub.GetRoom(data.Room); ok {
+		switch err := room.RotateToken(); err {
+		case nil:
+			c.JSON(http.StatusOK, gin.H{"room": data.Room, "online": true})
+		case conn.ErrRoomTokenNotSent:
+			conn.AbortWithCode(c, http.StatusGatewayTimeout, conn.ErrCodeRoomOffline, err.Error())
+		default:
+			conn.AbortWithCode(c, http.StatusInternalServerError, conn.ErrCodeDbError, err.Error())
+		}
+		return
+	}
+	if err := one.SetAddr(utils.NewRandom()); err != nil {
+		conn.AbortWithCode(c, http.StatusInternalServerError, conn.ErrCodeDbError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"room": data.Room, "online": false})
+}
+
+// PostPairCode returns a short code for reg a One of the user,
+// One sends one:Pair:[code]:{"name":""} to get the room token.
+func (s *Server) PostPairCode(c *gin.Context) {
+	o := c.Keys[s.UserKey].(*account.Oauth)
+	code, exp := s.Hub.NewPairCode(o.AccountId)
+	c.JSON(http.StatusOK, gin.H{"code": code, "exp": exp.Unix()})
+}
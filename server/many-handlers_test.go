@@ -0,0 +1,117 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/empirefox/ic-server-conductor/account"
+	"github.com/empirefox/ic-server-conductor/account/accounttest"
+	"github.com/empirefox/ic-server-conductor/conn"
+	"github.com/empirefox/ic-server-conductor/conn/hub"
+	"github.com/empirefox/ic-server-conductor/keys"
+)
+
+// serve requests path on the handler routed by method and route, as o
+func serve(s *Server, method, route, path string, handler gin.HandlerFunc, o *account.Oauth, body interface{}) *httptest.ResponseRecorder {
+	router := gin.New()
+	router.Handle(method, route, func(c *gin.Context) { c.Set(s.UserKey, o) }, handler)
+	b, _ := json.Marshal(body)
+	req, _ := http.NewRequest(method, path, bytes.NewReader(b))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestManyHandlers(t *testing.T) {
+	Convey("many handlers", t, func() {
+		owner := accounttest.Setup()
+		s := &Server{UserKey: "user", Hub: hub.NewHub()}
+		one := &account.One{Name: "room", Addr: "addr"}
+		So(owner.Account.RegOne(one), ShouldBeNil)
+
+		Convey("should list providers", func() {
+			w := serve(s, "POST", "/", "/", s.GetAccountProviders, owner, nil)
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(w.Body.String(), ShouldContainSubstring, `"p"`)
+		})
+
+		Convey("should rotate token of offline room", func() {
+			w := serve(s, "POST", "/", "/", s.PostRotateRoomToken, owner, rotateRoomTokenData{Room: one.ID})
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(w.Body.String(), ShouldContainSubstring, `"online":false`)
+			saved := &account.One{}
+			So(saved.Find(one.ID), ShouldBeNil)
+			So(saved.Addr, ShouldNotEqual, "addr")
+		})
+
+		Convey("should not rotate token of others", func() {
+			viewer := accounttest.Login("viewer")
+			w := serve(s, "POST", "/", "/", s.PostRotateRoomToken, viewer, rotateRoomTokenData{Room: one.ID})
+			So(w.Code, ShouldEqual, http.StatusForbidden)
+		})
+
+		Convey("should change password with the current one", func() {
+			_, err := account.NewLocalUser("alice", "password1", "")
+			So(err, ShouldBeNil)
+			o := &account.Oauth{}
+			So(o.LoginLocal("alice", "password1"), ShouldBeNil)
+			w := serve(s, "PUT", "/password", "/password", s.PutPassword, o, passwordData{Password: "password2"})
+			So(w.Code, ShouldEqual, http.StatusForbidden)
+			w = serve(s, "PUT", "/password", "/password", s.PutPassword, o, passwordData{Current: "password1", Password: "password2"})
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So((&account.Oauth{}).LoginLocal("alice", "password2"), ShouldBeNil)
+		})
+
+		Convey("should keep api keys off destructive routes", func() {
+			k, _, err := owner.Account.NewApiKey("ci")
+			So(err, ShouldBeNil)
+			w := serve(s, "POST", "/", "/", s.NoApiKey, k, nil)
+			So(w.Code, ShouldEqual, http.StatusForbidden)
+			w = serve(s, "POST", "/", "/", s.NoApiKey, owner, nil)
+			So(w.Code, ShouldEqual, http.StatusOK)
+
+			k.Account = owner.Account
+			path := fmt.Sprintf("/rooms/%d", one.ID)
+			w = serve(s, "PATCH", "/rooms/:id", path, s.PatchRoom, k, gin.H{"Enabled": false})
+			So(w.Code, ShouldEqual, http.StatusForbidden)
+			saved := &account.One{}
+			So(saved.Find(one.ID), ShouldBeNil)
+			So(saved.Enabled, ShouldBeTrue)
+		})
+	})
+}
+
+func TestSessionToken(t *testing.T) {
+	Convey("session token", t, func() {
+		owner := accounttest.Setup()
+		s := &Server{UserKey: "user", KeyManager: keys.FromSecrets(map[string][]byte{
+			keys.UseSession: []byte("session-secret"),
+			keys.UseGuest:   []byte("guest-secret"),
+		})}
+
+		Convey("should be signed by the session key and find the oauth", func() {
+			tokenObj, err := s.newSessionToken(owner)
+			So(err, ShouldBeNil)
+			token := []byte(tokenObj["token"].(string))
+			So(conn.TokenKid(token), ShouldEqual, keys.UseSession)
+
+			o := &account.Oauth{}
+			So(s.verifySession(o, token), ShouldBeNil)
+			So(o.AccountId, ShouldEqual, owner.AccountId)
+		})
+
+		Convey("should not be verified by a key of other use", func() {
+			token, err := s.KeyManager.Sign(keys.UseGuest, map[string]interface{}{"prd": "p", "oid": "owner"})
+			So(err, ShouldBeNil)
+			So(s.verifySession(&account.Oauth{}, []byte(token)), ShouldNotBeNil)
+		})
+	})
+}
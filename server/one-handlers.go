@@ -1,6 +1,9 @@
 package server
 
 import (
+	"net/http"
+
+	"github.com/empirefox/ic-server-conductor/conn"
 	"github.com/empirefox/ic-server-conductor/utils"
 	"github.com/gin-gonic/gin"
 	"github.com/golang/glog"
@@ -10,6 +13,7 @@ func (s *Server) WsOneSignaling(c *gin.Context) {
 	res, err := s.Hub.ProcessFromWait(c.Params.ByName("reciever"))
 	if err != nil {
 		glog.Errorln(err)
+		conn.AbortWithCode(c, http.StatusNotFound, conn.ErrCodeNotFound, err.Error())
 		return
 	}
 	ws, err := utils.Upgrader.Upgrade(c.Writer, c.Request, nil)
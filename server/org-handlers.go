@@ -0,0 +1,204 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/empirefox/ic-server-conductor/account"
+	"github.com/empirefox/ic-server-conductor/conn"
+)
+
+type orgData struct {
+	Name string `json:"name"`
+	Dsc  string `json:"dsc"`
+}
+
+type orgMemberData struct {
+	Account uint   `json:"account"`
+	Role    string `json:"role"`
+}
+
+type orgRoomData struct {
+	Room uint `json:"room"`
+}
+
+func paramUint(c *gin.Context, name string) (uint, bool) {
+	id, err := strconv.ParseUint(c.Params.ByName(name), 10, 64)
+	if err != nil || id == 0 {
+		conn.AbortWithCode(c, http.StatusBadRequest, conn.ErrCodeBadRequest, name+" required")
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// findOrg aborts when the user has no role in the org, or the role is
+// not enough for manage.
+func (s *Server) findOrg(c *gin.Context, manage bool) (*account.Org, *account.Oauth, bool) {
+	id, ok := paramUint(c, "id")
+	if !ok {
+		return nil, nil, false
+	}
+	o := c.Keys[s.UserKey].(*account.Oauth)
+	org := &account.Org{}
+	if err := org.Find(id); err != nil {
+		conn.AbortWithCode(c, http.StatusNotFound, conn.ErrCodeNotFound, "org not found")
+		return nil, nil, false
+	}
+	role := org.Role(o.AccountId)
+	if role == "" || (manage && !account.CanManageOrg(role)) {
+		conn.AbortWithCode(c, http.StatusForbidden, conn.ErrCodeNotPermitted, "not permitted in the org")
+		return nil, nil, false
+	}
+	return org, o, true
+}
+
+// GetOrgs returns memberships of the user, with orgs
+func (s *Server) GetOrgs(c *gin.Context) {
+	o := c.Keys[s.UserKey].(*account.Oauth)
+	var ms []account.OrgMember
+	if err := o.Account.Orgs(&ms); err != nil {
+		conn.AbortWithCode(c, http.StatusInternalServerError, conn.ErrCodeDbError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, ms)
+}
+
+func (s *Server) PostOrg(c *gin.Context) {
+	var data orgData
+	if err := c.BindJSON(&data); err != nil || data.Name == "" || len(data.Name) > 128 || len(data.Dsc) > 128 {
+		conn.AbortWithCode(c, http.StatusBadRequest, conn.ErrCodeBadRequest, "name required")
+		return
+	}
+	o := c.Keys[s.UserKey].(*account.Oauth)
+	org := &account.Org{Name: data.Name, Dsc: data.Dsc}
+	if err := o.Account.CreateOrg(org); err != nil {
+		conn.AbortWithCode(c, http.StatusInternalServerError, conn.ErrCodeDbError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, org)
+}
+
+// DeleteOrg is for owners only, rooms go back to their owners.
+func (s *Server) DeleteOrg(c *gin.Context) {
+	org, o, ok := s.findOrg(c, true)
+	if !ok {
+		return
+	}
+	if org.Role(o.AccountId) != account.OrgRoleOwner {
+		conn.AbortWithCode(c, http.StatusForbidden, conn.ErrCodeNotPermitted, "only owner can delete the org")
+		return
+	}
+	if err := org.Delete(); err != nil {
+		conn.AbortWithCode(c, http.StatusInternalServerError, conn.ErrCodeDbError, err.Error())
+		return
+	}
+	c.AbortWithStatus(http.StatusOK)
+}
+
+func (s *Server) GetOrgMembers(c *gin.Context) {
+	org, _, ok := s.findOrg(c, false)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, org.Members)
+}
+
+// PostOrgMember adds or changes a member, only owners can set owner.
+func (s *Server) PostOrgMember(c *gin.Context) {
+	org, o, ok := s.findOrg(c, true)
+	if !ok {
+		return
+	}
+	var data orgMemberData
+	if err := c.BindJSON(&data); err != nil || !account.ValidOrgRole(data.Role) {
+		conn.AbortWithCode(c, http.StatusBadRequest, conn.ErrCodeBadRequest, "account and role required")
+		return
+	}
+	isOwner := org.Role(o.AccountId) == account.OrgRoleOwner
+	if !isOwner && (data.Role == account.OrgRoleOwner || org.Role(data.Account) == account.OrgRoleOwner) {
+		conn.AbortWithCode(c, http.StatusForbidden, conn.ErrCodeNotPermitted, "only owner can manage owners")
+		return
+	}
+	if err := (&account.Account{}).Find(data.Account); err != nil {
+		conn.AbortWithCode(c, http.StatusNotFound, conn.ErrCodeNotFound, "account not found")
+		return
+	}
+	m := &account.OrgMember{AccountId: data.Account, Role: data.Role}
+	if err := org.SaveMember(m); err != nil {
+		conn.AbortWithCode(c, http.StatusBadRequest, conn.ErrCodeBadRequest, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, m)
+}
+
+// DeleteOrgMember removes a member, everyone can leave.
+func (s *Server) DeleteOrgMember(c *gin.Context) {
+	aid, ok := paramUint(c, "account")
+	if !ok {
+		return
+	}
+	org, o, ok := s.findOrg(c, false)
+	if !ok {
+		return
+	}
+	role := org.Role(o.AccountId)
+	if aid != o.AccountId {
+		if !account.CanManageOrg(role) ||
+			(role != account.OrgRoleOwner && org.Role(aid) == account.OrgRoleOwner) {
+			conn.AbortWithCode(c, http.StatusForbidden, conn.ErrCodeNotPermitted, "not permitted in the org")
+			return
+		}
+	}
+	if err := org.RemoveMember(aid); err != nil {
+		conn.AbortWithCode(c, http.StatusBadRequest, conn.ErrCodeBadRequest, err.Error())
+		return
+	}
+	c.AbortWithStatus(http.StatusOK)
+}
+
+// PostOrgRoom moves a personal room of the user into the org.
+func (s *Server) PostOrgRoom(c *gin.Context) {
+	org, o, ok := s.findOrg(c, true)
+	if !ok {
+		return
+	}
+	var data orgRoomData
+	if err := c.BindJSON(&data); err != nil {
+		conn.AbortWithCode(c, http.StatusBadRequest, conn.ErrCodeBadRequest, "room required")
+		return
+	}
+	one := &account.One{}
+	if err := one.FindIfOwner(data.Room, o.AccountId); err != nil || one.OrgId != 0 {
+		conn.AbortWithCode(c, http.StatusForbidden, conn.ErrCodeNotOwner, "not the owner of the room")
+		return
+	}
+	if err := one.SetOrg(org.ID); err != nil {
+		conn.AbortWithCode(c, http.StatusInternalServerError, conn.ErrCodeDbError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"room": one.ID, "org": org.ID})
+}
+
+// DeleteOrgRoom gives the room back to its owner.
+func (s *Server) DeleteOrgRoom(c *gin.Context) {
+	rid, ok := paramUint(c, "room")
+	if !ok {
+		return
+	}
+	org, o, ok := s.findOrg(c, true)
+	if !ok {
+		return
+	}
+	one := &account.One{}
+	if err := one.FindIfOwner(rid, o.AccountId); err != nil || one.OrgId != org.ID {
+		conn.AbortWithCode(c, http.StatusNotFound, conn.ErrCodeNotFound, "room not in the org")
+		return
+	}
+	if err := one.SetOrg(0); err != nil {
+		conn.AbortWithCode(c, http.StatusInternalServerError, conn.ErrCodeDbError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"room": one.ID, "org": 0})
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/empirefox/ic-server-conductor/account"
+	"github.com/empirefox/ic-server-conductor/conn"
+)
+
+type deletedRoom struct {
+	ID        uint      `json:"id"`
+	Name      string    `json:"name"`
+	OrgId     uint      `json:"org"`
+	DeletedAt time.Time `json:"deleted_at"`
+	PurgeAt   time.Time `json:"purge_at"`
+}
+
+// GetDeletedRooms returns deleted rooms the user can restore
+func (s *Server) GetDeletedRooms(c *gin.Context) {
+	o := c.Keys[s.UserKey].(*account.Oauth)
+	var ones account.Ones
+	if err := o.Account.DeletedOnes(&ones); err != nil {
+		conn.AbortWithCode(c, http.StatusInternalServerError, conn.ErrCodeDbError, err.Error())
+		return
+	}
+	rooms := make([]deletedRoom, 0, len(ones))
+	for _, one := range ones {
+		rooms = append(rooms, deletedRoom{
+			ID:        one.ID,
+			Name:      one.Name,
+			OrgId:     one.OrgId,
+			DeletedAt: *one.DeletedAt,
+			PurgeAt:   one.DeletedAt.Add(account.DeleteGrace),
+		})
+	}
+	c.JSON(http.StatusOK, rooms)
+}
+
+// PostRestoreRoom undoes the deletion, the One can login again with its token
+func (s *Server) PostRestoreRoom(c *gin.Context) {
+	id, ok := paramUint(c, "id")
+	if !ok {
+		return
+	}
+	o := c.Keys[s.UserKey].(*account.Oauth)
+	one := &account.One{}
+	if err := o.Account.RestoreOne(one, id); err != nil {
+		conn.AbortWithCode(c, http.StatusNotFound, conn.ErrCodeNotFound, "no deleted room to restore")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"room": one.ID})
+}
@@ -0,0 +1,224 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/empirefox/ic-server-conductor/account"
+	"github.com/empirefox/ic-server-conductor/conn"
+)
+
+// Rest api of rooms, same rules and live updates as many commands.
+
+// GetRooms returns rooms the user can view, filter by ?tag= or ?group=
+func (s *Server) GetRooms(c *gin.Context) {
+	o := c.Keys[s.UserKey].(*account.Oauth)
+	q := c.Request.URL.Query()
+	var f account.RoomFilter
+	f.Tag = q.Get("tag")
+	if g := q.Get("group"); g != "" {
+		id, err := strconv.ParseUint(g, 10, 64)
+		if err != nil {
+			conn.AbortWithCode(c, http.StatusBadRequest, conn.ErrCodeBadRequest, "bad group id")
+			return
+		}
+		f.GroupId = uint(id)
+	}
+	var rooms *json.RawMessage
+	var err error
+	if f.Tag == "" && f.GroupId == 0 {
+		rooms, err = o.RawRooms()
+	} else {
+		rooms, err = o.RawRoomsFilter(f)
+	}
+	if err != nil {
+		conn.AbortWithCode(c, http.StatusInternalServerError, conn.ErrCodeDbError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, rooms)
+}
+
+func (s *Server) GetRoom(c *gin.Context) {
+	id, ok := paramUint(c, "id")
+	if !ok {
+		return
+	}
+	o := c.Keys[s.UserKey].(*account.Oauth)
+	one := &account.One{}
+	if err := one.Find(id); err != nil {
+		conn.AbortWithCode(c, http.StatusNotFound, conn.ErrCodeNotFound, "room not found")
+		return
+	}
+	if !o.CanView(one) {
+		conn.AbortWithCode(c, http.StatusForbidden, conn.ErrCodeNotPermitted, "not permited to view this room")
+		return
+	}
+	room, err := one.RawUserRoom()
+	if err != nil {
+		conn.AbortWithCode(c, http.StatusInternalServerError, conn.ErrCodeInternal, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, room)
+}
+
+// findManagedRoom aborts when the user cannot manage the room
+func (s *Server) findManagedRoom(c *gin.Context) (*account.One, *account.Oauth, bool) {
+	id, ok := paramUint(c, "id")
+	if !ok {
+		return nil, nil, false
+	}
+	o := c.Keys[s.UserKey].(*account.Oauth)
+	one, status, code, err := conn.FindManagedRoom(&o.Account, id)
+	if err != nil {
+		conn.AbortWithCode(c, status, code, "not the owner of the room")
+		return nil, nil, false
+	}
+	return one, o, true
+}
+
+// PatchRoom changes Name, Dsc or Enabled, absent fields are kept
+func (s *Server) PatchRoom(c *gin.Context) {
+	one, o, ok := s.findManagedRoom(c)
+	if !ok {
+		return
+	}
+	input, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		conn.AbortWithCode(c, http.StatusBadRequest, conn.ErrCodeBadRequest, err.Error())
+		return
+	}
+	room, fields, status, code, err := conn.UpdateRoom(s.Hub, nil, o, one, input)
+	switch {
+	case fields != nil:
+		conn.AbortWithFields(c, fields)
+	case err != nil:
+		conn.AbortWithCode(c, status, code, err.Error())
+	default:
+		c.JSON(http.StatusOK, room)
+	}
+}
+
+func (s *Server) DeleteRoom(c *gin.Context) {
+	one, o, ok := s.findManagedRoom(c)
+	if !ok {
+		return
+	}
+	if status, code, err := conn.DeleteRoom(s.Hub, nil, o, one); err != nil {
+		conn.AbortWithCode(c, status, code, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"room": one.ID})
+}
+
+// manageIpcam passes the command to the online One,
+// the result is sent to the many sessions by T2M.
+func (s *Server) manageIpcam(c *gin.Context, name string, content json.RawMessage) {
+	one, o, ok := s.findManagedRoom(c)
+	if !ok {
+		return
+	}
+	if status, code, err := conn.ManageIpcam(s.Hub, o, one, name, content); err != nil {
+		conn.AbortWithCode(c, status, code, err.Error())
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"room": one.ID, "name": name})
+}
+
+func ipcamParam(c *gin.Context) json.RawMessage {
+	id, _ := json.Marshal(c.Params.ByName("ipcam"))
+	return id
+}
+
+func (s *Server) GetRoomIpcam(c *gin.Context)    { s.manageIpcam(c, "ManageGetIpcam", ipcamParam(c)) }
+func (s *Server) DeleteRoomIpcam(c *gin.Context) { s.manageIpcam(c, "ManageDelIpcam", ipcamParam(c)) }
+
+// PutRoomIpcam body is the ipcam json passed to One
+func (s *Server) PutRoomIpcam(c *gin.Context) {
+	var ipcam json.RawMessage
+	if err := c.BindJSON(&ipcam); err != nil {
+		conn.AbortWithCode(c, http.StatusBadRequest, conn.ErrCodeBadRequest, "ipcam required")
+		return
+	}
+	s.manageIpcam(c, "ManageSetIpcam", ipcam)
+}
+
+type viewCamerasData struct {
+	Cameras []string `json:"cameras"`
+}
+
+// PutRoomViewerCameras limits the cameras a viewer of the shared room can see,
+// empty cameras allows all. The owner cannot be limited.
+func (s *Server) PutRoomViewerCameras(c *gin.Context) {
+	one, _, ok := s.findManagedRoom(c)
+	if !ok {
+		return
+	}
+	accountId, ok := paramUint(c, "account")
+	if !ok {
+		return
+	}
+	var data viewCamerasData
+	if err := c.BindJSON(&data); err != nil {
+		conn.AbortWithCode(c, http.StatusBadRequest, conn.ErrCodeBadRequest, "cameras required")
+		return
+	}
+	cs, err := one.SetViewCameras(accountId, data.Cameras)
+	switch err {
+	case nil:
+	case account.ErrCamerasTooLong, account.ErrViewOwner:
+		conn.AbortWithCode(c, http.StatusBadRequest, conn.ErrCodeBadRequest, err.Error())
+		return
+	case account.ErrRecordNotFound:
+		conn.AbortWithCode(c, http.StatusNotFound, conn.ErrCodeNotFound, "room not shared with the account")
+		return
+	default:
+		conn.AbortWithCode(c, http.StatusInternalServerError, conn.ErrCodeDbError, err.Error())
+		return
+	}
+	if room, ok := s.Hub.GetRoom(one.ID); ok {
+		room.SetCameras(accountId, cs)
+	}
+	if cs == nil {
+		cs = account.Cameras{}
+	}
+	c.JSON(http.StatusOK, gin.H{"room": one.ID, "account": accountId, "cameras": cs})
+}
+
+type viewScheduleData struct {
+	Schedule  *account.ViewSchedule `json:"schedule"`
+	ExpiresAt *time.Time            `json:"expiresAt"`
+}
+
+// PutRoomViewerSchedule limits when a viewer can view the shared room,
+// online viewers out of the schedule are removed by the hub sweeper.
+// The owner cannot be limited.
+func (s *Server) PutRoomViewerSchedule(c *gin.Context) {
+	one, _, ok := s.findManagedRoom(c)
+	if !ok {
+		return
+	}
+	accountId, ok := paramUint(c, "account")
+	if !ok {
+		return
+	}
+	var data viewScheduleData
+	if err := c.BindJSON(&data); err != nil {
+		conn.AbortWithCode(c, http.StatusBadRequest, conn.ErrCodeBadRequest, "schedule required")
+		return
+	}
+	switch err := one.SetViewSchedule(accountId, data.Schedule, data.ExpiresAt); err {
+	case nil:
+		c.JSON(http.StatusOK, gin.H{"room": one.ID, "account": accountId, "schedule": data.Schedule, "expiresAt": data.ExpiresAt})
+	case account.ErrBadSchedule, account.ErrViewOwner:
+		conn.AbortWithCode(c, http.StatusBadRequest, conn.ErrCodeBadRequest, err.Error())
+	case account.ErrRecordNotFound:
+		conn.AbortWithCode(c, http.StatusNotFound, conn.ErrCodeNotFound, "room not shared with the account")
+	default:
+		conn.AbortWithCode(c, http.StatusInternalServerError, conn.ErrCodeDbError, err.Error())
+	}
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/empirefox/ic-server-conductor/account"
+	"github.com/empirefox/ic-server-conductor/account/accounttest"
+	"github.com/empirefox/ic-server-conductor/conn"
+	"github.com/empirefox/ic-server-conductor/conn/hub"
+)
+
+func TestRoomHandlers(t *testing.T) {
+	Convey("room handlers", t, func() {
+		owner := accounttest.Setup()
+		viewer := accounttest.Login("viewer")
+		s := &Server{UserKey: "user", Hub: hub.NewHub()}
+		one := &account.One{Name: "room", Addr: "addr"}
+		So(owner.Account.RegOne(one), ShouldBeNil)
+		path := fmt.Sprintf("/rooms/%d", one.ID)
+
+		Convey("should list and get viewable rooms", func() {
+			w := serve(s, "GET", "/rooms", "/rooms", s.GetRooms, owner, nil)
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(w.Body.String(), ShouldContainSubstring, `"room"`)
+
+			w = serve(s, "GET", "/rooms/:id", path, s.GetRoom, viewer, nil)
+			So(w.Code, ShouldEqual, http.StatusForbidden)
+		})
+
+		Convey("should rename by owner only", func() {
+			w := serve(s, "PATCH", "/rooms/:id", path, s.PatchRoom, viewer, gin.H{"Name": "x"})
+			So(w.Code, ShouldEqual, http.StatusForbidden)
+
+			w = serve(s, "PATCH", "/rooms/:id", path, s.PatchRoom, owner, gin.H{"Name": "renamed"})
+			So(w.Code, ShouldEqual, http.StatusOK)
+			saved := &account.One{}
+			So(saved.Find(one.ID), ShouldBeNil)
+			So(saved.Name, ShouldEqual, "renamed")
+		})
+
+		Convey("should update only given fields", func() {
+			w := serve(s, "PATCH", "/rooms/:id", path, s.PatchRoom, owner, gin.H{"Dsc": "office", "Enabled": false})
+			So(w.Code, ShouldEqual, http.StatusOK)
+			saved := &account.One{}
+			So(saved.Find(one.ID), ShouldBeNil)
+			So(saved.Name, ShouldEqual, "room")
+			So(saved.Dsc, ShouldEqual, "office")
+			So(saved.Enabled, ShouldBeFalse)
+		})
+
+		Convey("should reply errors by field", func() {
+			long := "0123456789012345678901234567890123456789"
+			w := serve(s, "PATCH", "/rooms/:id", path, s.PatchRoom, owner, gin.H{"Name": long})
+			So(w.Code, ShouldEqual, http.StatusBadRequest)
+			var reply conn.ErrorReply
+			So(json.Unmarshal(w.Body.Bytes(), &reply), ShouldBeNil)
+			So(reply.Fields["Name"], ShouldContain, "lmax(32)")
+
+			w = serve(s, "PATCH", "/rooms/:id", path, s.PatchRoom, owner, gin.H{"Name": ""})
+			So(w.Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("should delete offline room", func() {
+			w := serve(s, "DELETE", "/rooms/:id", path, s.DeleteRoom, owner, nil)
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So((&account.One{}).Find(one.ID), ShouldNotBeNil)
+		})
+
+		Convey("should toggle flags", func() {
+			w := serve(s, "PUT", "/rooms/:id/enabled", path+"/enabled", s.PutRoomEnabled, viewer, enabledData{})
+			So(w.Code, ShouldEqual, http.StatusForbidden)
+			w = serve(s, "PUT", "/rooms/:id/enabled", path+"/enabled", s.PutRoomEnabled, owner, enabledData{})
+			So(w.Code, ShouldEqual, http.StatusOK)
+			saved := &account.One{}
+			So(saved.Find(one.ID), ShouldBeNil)
+			So(saved.Enabled, ShouldBeFalse)
+
+			w = serve(s, "PUT", "/accounts/:id/enabled", "/accounts/999/enabled", s.PutSysAccountEnabled, nil, enabledData{})
+			So(w.Code, ShouldEqual, http.StatusNotFound)
+			apath := fmt.Sprintf("/accounts/%d/enabled", viewer.AccountId)
+			w = serve(s, "PUT", "/accounts/:id/enabled", apath, s.PutSysAccountEnabled, nil, enabledData{})
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So((&account.Account{}).Find(viewer.AccountId), ShouldNotBeNil)
+		})
+
+		Convey("should not be enabled by owner when disabled by sys", func() {
+			spath := fmt.Sprintf("/sys/rooms/%d/enabled", one.ID)
+			w := serve(s, "PUT", "/sys/rooms/:id/enabled", spath, s.PutSysRoomEnabled, nil, enabledData{})
+			So(w.Code, ShouldEqual, http.StatusOK)
+
+			w = serve(s, "PUT", "/rooms/:id/enabled", path+"/enabled", s.PutRoomEnabled, owner, enabledData{true})
+			So(w.Code, ShouldEqual, http.StatusForbidden)
+			w = serve(s, "PATCH", "/rooms/:id", path, s.PatchRoom, owner, gin.H{"Enabled": true})
+			So(w.Code, ShouldEqual, http.StatusForbidden)
+			saved := &account.One{}
+			So(saved.Find(one.ID), ShouldBeNil)
+			So(saved.Enabled, ShouldBeFalse)
+			So(saved.DisabledBySys, ShouldBeTrue)
+			So(saved.Usable(), ShouldBeFalse)
+
+			w = serve(s, "PATCH", "/rooms/:id", path, s.PatchRoom, owner, gin.H{"Dsc": "office"})
+			So(w.Code, ShouldEqual, http.StatusOK)
+
+			w = serve(s, "PUT", "/sys/rooms/:id/enabled", spath, s.PutSysRoomEnabled, nil, enabledData{true})
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(saved.Find(one.ID), ShouldBeNil)
+			So(saved.Usable(), ShouldBeTrue)
+		})
+
+		Convey("should limit cameras of a viewer", func() {
+			vpath := fmt.Sprintf("%s/viewers/%d/cameras", path, viewer.AccountId)
+			route := "/rooms/:id/viewers/:account/cameras"
+			w := serve(s, "PUT", route, vpath, s.PutRoomViewerCameras, owner, viewCamerasData{[]string{"door"}})
+			So(w.Code, ShouldEqual, http.StatusNotFound)
+
+			So(viewer.Account.ViewOne(one), ShouldBeNil)
+			So(viewer.CanViewCamera(one, ""), ShouldBeTrue)
+			w = serve(s, "PUT", route, vpath, s.PutRoomViewerCameras, viewer, viewCamerasData{[]string{"door"}})
+			So(w.Code, ShouldEqual, http.StatusForbidden)
+			w = serve(s, "PUT", route, vpath, s.PutRoomViewerCameras, owner, viewCamerasData{[]string{"door", " door"}})
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(viewer.CanViewCamera(one, "door"), ShouldBeTrue)
+			So(viewer.CanViewCamera(one, "office"), ShouldBeFalse)
+			So(viewer.CanViewCamera(one, ""), ShouldBeFalse)
+			So(owner.CanViewCamera(one, "office"), ShouldBeTrue)
+			opath := fmt.Sprintf("%s/viewers/%d/cameras", path, owner.AccountId)
+			w = serve(s, "PUT", route, opath, s.PutRoomViewerCameras, owner, viewCamerasData{[]string{"door"}})
+			So(w.Code, ShouldEqual, http.StatusBadRequest)
+
+			cs := account.Cameras{"door"}
+			part, ok := conn.FilterT2M(cs, []byte("IcIds"), json.RawMessage(`["door","office"]`))
+			So(ok, ShouldBeTrue)
+			So(string(part), ShouldEqual, `["door"]`)
+			_, ok = conn.FilterT2M(cs, []byte("Ic"), json.RawMessage(`{"Id":"office"}`))
+			So(ok, ShouldBeFalse)
+			_, ok = conn.FilterT2M(nil, []byte("Ic"), json.RawMessage(`{"Id":"office"}`))
+			So(ok, ShouldBeTrue)
+		})
+
+		Convey("should schedule a viewer", func() {
+			So(viewer.Account.ViewOne(one), ShouldBeNil)
+			route := "/rooms/:id/viewers/:account/schedule"
+			vpath := fmt.Sprintf("%s/viewers/%d/schedule", path, viewer.AccountId)
+			bad := &account.ViewSchedule{Timezone: "Nowhere/City", Windows: []account.ScheduleWindow{{}}}
+			w := serve(s, "PUT", route, vpath, s.PutRoomViewerSchedule, owner, viewScheduleData{Schedule: bad})
+			So(w.Code, ShouldEqual, http.StatusBadRequest)
+
+			expired := time.Now().Add(-time.Minute)
+			w = serve(s, "PUT", route, vpath, s.PutRoomViewerSchedule, owner, viewScheduleData{ExpiresAt: &expired})
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(viewer.CanView(one), ShouldBeFalse)
+
+			opath := fmt.Sprintf("%s/viewers/%d/schedule", path, owner.AccountId)
+			w = serve(s, "PUT", route, opath, s.PutRoomViewerSchedule, owner, viewScheduleData{ExpiresAt: &expired})
+			So(w.Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("should not pass ipcam command to offline room", func() {
+			w := serve(s, "GET", "/rooms/:id/ipcams/:ipcam", path+"/ipcams/cam1", s.GetRoomIpcam, owner, nil)
+			So(w.Code, ShouldEqual, http.StatusConflict)
+		})
+	})
+}
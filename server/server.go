@@ -1,8 +1,10 @@
 package server
 
 import (
+	"errors"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/contrib/secure"
 	"github.com/gin-gonic/gin"
@@ -14,7 +16,10 @@ import (
 	"github.com/empirefox/ic-server-conductor/conn"
 	"github.com/empirefox/ic-server-conductor/conn/many"
 	"github.com/empirefox/ic-server-conductor/conn/one"
+	"github.com/empirefox/ic-server-conductor/export"
+	"github.com/empirefox/ic-server-conductor/gorm"
 	"github.com/empirefox/ic-server-conductor/invite"
+	"github.com/empirefox/ic-server-conductor/keys"
 	"github.com/empirefox/ic-server-conductor/utils"
 )
 
@@ -24,25 +29,54 @@ const (
 )
 
 type Server struct {
-	Origins         string
-	ClaimsKey       string
-	UserKey         string
-	OneAlg          string
+	Origins   string
+	ClaimsKey string
+	UserKey   string
+	OneAlg    string
+	// HMAC keys of "system", "proxy", "guest" and "session", loaded when
+	// KeyManager is nil. Run fails when no "guest" or "session" key.
 	Keys            map[string][]byte
+	KeyManager      *keys.Manager
+	AccountService  account.AccountService // created from DB when nil, one per process
+	DB              *gorm.DB               // opened by gorm.EnvConfig when nil
+	DeleteGrace     time.Duration          // account.DeleteGrace when 0
+	Exports         *export.Manager        // created when nil
 	Hub             conn.Hub
 	IsDevMode       bool
 	OnEngineCreated func(*gin.Engine)
 	OauthGroupName  string
 	Proxied         []string
+	TotpIssuer      string // shown in authenticator apps
 	goauthConfig    *goauth.Config
 }
 
+// ErrServiceInUse fails Run, the account package serves one AccountService per process
+var ErrServiceInUse = errors.New("Another AccountService is in use by this process")
+
 func (s *Server) Ok(c *gin.Context)       { c.AbortWithStatus(http.StatusOK) }
 func (s *Server) NotFound(c *gin.Context) { c.AbortWithStatus(http.StatusNotFound) }
 
 func (s *Server) Run() error {
 	utils.Origin = s.Origins
-	corsMiddleWare := s.Cors("GET, PUT, POST, DELETE")
+	if err := s.initAccountService(); err != nil {
+		return err
+	}
+	if s.DeleteGrace > 0 {
+		account.DeleteGrace = s.DeleteGrace
+	}
+	if s.Exports == nil {
+		s.Exports = export.NewManager()
+	}
+	if s.KeyManager == nil {
+		s.KeyManager = keys.FromSecrets(s.Keys)
+	}
+	if _, ok := s.KeyManager.Signer(keys.UseGuest); !ok {
+		return ErrNoGuestKey
+	}
+	if _, ok := s.KeyManager.Signer(keys.UseSession); !ok {
+		return ErrNoSessionKey
+	}
+	corsMiddleWare := s.Cors("GET, PUT, PATCH, POST, DELETE")
 
 	s.goauthConfig = &goauth.Config{
 		GinClaimsKey: s.ClaimsKey,
@@ -54,6 +88,15 @@ func (s *Server) Run() error {
 	}
 	authMiddleWare := goauth.Middleware(s.goauthConfig)
 
+	account.SetHooks(account.Hooks{
+		OnLogoff: func(accountId uint) {
+			s.Hub.OnKick(&conn.Kick{AccountId: accountId, Reason: "Logoff"})
+		},
+		OnUnlink: func(accountId uint, provider string) {
+			s.Hub.OnKick(&conn.Kick{AccountId: accountId, Provider: provider, Reason: "Unlink"})
+		},
+	})
+
 	router := gin.Default()
 	if s.OnEngineCreated != nil {
 		s.OnEngineCreated(router)
@@ -72,6 +115,7 @@ func (s *Server) Run() error {
 
 	// peer from MANY client
 	router.GET("/sys-data.js", s.GetSystemData)
+	router.GET("/.well-known/jwks.json", s.GetJwks)
 
 	roauth := router.Group("/oauth", corsMiddleWare)
 	roauth.GET("/oauths", s.GetOauths)
@@ -81,16 +125,23 @@ func (s *Server) Run() error {
 
 	proxy := router.Group("/proxy", s.Auth(SK_PROXY))
 	proxy.GET("/providers", s.GetProxiedProviders)
-	proxy.POST("/token", s.PostProxyToken)
+	proxy.POST("/token", s.RecordIssued, s.PostProxyToken)
 
 	sys := router.Group("/sys", s.Auth(SK_SYS))
 	sys.POST("/clear-tables", s.PostClearTables)
 	sys.POST("/create-tables", s.PostCreateTables)
+	sys.POST("/migrate", s.PostMigrate)
+	sys.GET("/schema-version", s.GetSchemaVersion)
 	sys.POST("/oauth", s.PostSaveOauth)
+	sys.PUT("/accounts/:id/enabled", s.PutSysAccountEnabled)
+	sys.PUT("/oauths/:id/enabled", s.PutSysOauthEnabled)
+	sys.PUT("/rooms/:id/enabled", s.PutSysRoomEnabled)
+	sys.POST("/local-users", s.PostSysLocalUser)
+	sys.POST("/keys/:use/rotate", s.PostSysRotateKey)
 
 	// peer from ONE client
 	ro := router.Group("/one")
-	ro.GET("/ctrl", one.HandleOneCtrl(s.Hub, s.OneAlg, s.Verify))
+	ro.GET("/ctrl", one.HandleOneCtrl(s.Hub, s.OneAlg, s.KeyManager, s.Verify))
 	ro.GET("/signaling/:reciever", s.WsOneSignaling)
 
 	// websocket
@@ -99,27 +150,179 @@ func (s *Server) Run() error {
 	manyws.GET("/ctrl", many.HandleManyCtrl(s.Hub, s.Verify))
 	manyws.GET("/signaling", s.WsManySignaling)
 
+	// guest links, no account
+	guest := router.Group("/guest", corsMiddleWare)
+	guest.OPTIONS("/token", s.Ok)
+	guest.POST("/token", s.PostGuestToken)
+
+	// pre-auth tokens of provider logins only get a full token
+	pre := router.Group("/many", corsMiddleWare, s.SessionAuth, s.CheckSession)
+	pre.OPTIONS("/new-token", s.Ok)
+	pre.POST("/new-token", s.PostNewToken)
+
 	// many rest
-	rm := router.Group("/many", corsMiddleWare, authMiddleWare, s.goauthConfig.MustBindUser)
+	rm := router.Group("/many", corsMiddleWare, s.SessionAuth, s.CheckSession, s.NoPreAuth)
 	rm.OPTIONS("/unlink", s.Ok)
 	rm.DELETE("/unlink", s.goauthConfig.Unlink)
 	rm.OPTIONS("/logoff", s.Ok)
-	rm.DELETE("/logoff", s.goauthConfig.Logoff)
-	rm.OPTIONS("/new-token", s.Ok)
-	rm.POST("/new-token", s.PostNewToken)
+	rm.DELETE("/logoff", s.StepUp, s.goauthConfig.Logoff)
 	rm.OPTIONS("/myproviders", s.Ok)
 	rm.GET("/myproviders", s.GetAccountProviders)
-	rm.OPTIONS("/invite-code", s.Ok)
-	rm.POST("/invite-code", invite.HandleManyGetInviteCode(s.Hub, s.UserKey))
-	rm.OPTIONS("/invite-join", s.Ok)
-	rm.POST("/invite-join", invite.HandleManyOnInvite(s.Hub, s.UserKey))
+	rm.OPTIONS("/password", s.Ok)
+	rm.PUT("/password", s.StepUp, s.PutPassword)
+	rm.OPTIONS("/api-keys", s.Ok)
+	rm.GET("/api-keys", s.GetApiKeys)
+	rm.POST("/api-keys", s.PostApiKey)
+	rm.OPTIONS("/api-keys/:id", s.Ok)
+	rm.DELETE("/api-keys/:id", s.DeleteApiKey)
+	rm.OPTIONS("/totp", s.Ok)
+	rm.GET("/totp", s.GetTotp)
+	rm.POST("/totp", s.PostTotp)
+	rm.DELETE("/totp", s.StepUp, s.DeleteTotp)
+	rm.OPTIONS("/totp/confirm", s.Ok)
+	rm.POST("/totp/confirm", s.PostConfirmTotp)
+	rm.OPTIONS("/totp/recovery", s.Ok)
+	rm.POST("/totp/recovery", s.StepUp, s.PostRecoveryCodes)
+	rm.OPTIONS("/sessions", s.Ok)
+	rm.GET("/sessions", s.GetSessions)
+	rm.OPTIONS("/sessions/:id", s.Ok)
+	rm.DELETE("/sessions/:id", s.DeleteSession)
+	s.manyRoutes(rm)
+
+	// same as many rest, for automation by api keys, see NoApiKey
+	s.manyRoutes(router.Group("/api", corsMiddleWare, s.ApiKeyAuth))
+
+	// local credentials, when no oauth provider can be reached
+	local := router.Group("/local", corsMiddleWare)
+	local.OPTIONS("/login", s.Ok)
+	local.POST("/login", s.PostLocalLogin)
 
 	// many and one login rest api
-	// compatible with Satellizer
+	// compatible with Satellizer, tokens are pre-auth when totp enabled
 	for path := range s.goauthConfig.Providers {
-		router.POST(path, corsMiddleWare, authMiddleWare, s.Ok)
+		router.POST(path, corsMiddleWare, s.RecordIssued, authMiddleWare, s.Ok)
 		router.OPTIONS(path, corsMiddleWare, s.Ok)
 	}
 
+	go s.purgeExpired()
+
 	return router.Run(paas.BindAddr)
 }
+
+// manyRoutes are shared by oauth tokens and api keys,
+// destructive routes are guarded by NoApiKey.
+func (s *Server) manyRoutes(g *gin.RouterGroup) {
+	g.OPTIONS("/invite-code", s.Ok)
+	g.POST("/invite-code", invite.HandleManyGetInviteCode(s.Hub, s.UserKey))
+	g.OPTIONS("/invite-join", s.Ok)
+	g.POST("/invite-join", invite.HandleManyOnInvite(s.Hub, s.UserKey))
+	g.OPTIONS("/pair", s.Ok)
+	g.POST("/pair", s.PostPairCode)
+	g.OPTIONS("/room-token", s.Ok)
+	g.POST("/room-token", s.NoApiKey, s.PostRotateRoomToken)
+	g.OPTIONS("/rooms", s.Ok)
+	g.GET("/rooms", s.GetRooms)
+	g.OPTIONS("/rooms/:id", s.Ok)
+	g.GET("/rooms/:id", s.GetRoom)
+	g.PATCH("/rooms/:id", s.PatchRoom)
+	g.DELETE("/rooms/:id", s.NoApiKey, s.StepUp, s.DeleteRoom)
+	g.OPTIONS("/rooms/:id/enabled", s.Ok)
+	g.PUT("/rooms/:id/enabled", s.NoApiKey, s.PutRoomEnabled)
+	g.OPTIONS("/rooms/:id/ipcams", s.Ok)
+	g.PUT("/rooms/:id/ipcams", s.PutRoomIpcam)
+	g.OPTIONS("/rooms/:id/ipcams/:ipcam", s.Ok)
+	g.GET("/rooms/:id/ipcams/:ipcam", s.GetRoomIpcam)
+	g.DELETE("/rooms/:id/ipcams/:ipcam", s.NoApiKey, s.DeleteRoomIpcam)
+	g.OPTIONS("/rooms/:id/viewers/:account/cameras", s.Ok)
+	g.PUT("/rooms/:id/viewers/:account/cameras", s.PutRoomViewerCameras)
+	g.OPTIONS("/rooms/:id/viewers/:account/schedule", s.Ok)
+	g.PUT("/rooms/:id/viewers/:account/schedule", s.PutRoomViewerSchedule)
+	g.OPTIONS("/rooms/:id/guests", s.Ok)
+	g.GET("/rooms/:id/guests", s.GetRoomGuests)
+	g.POST("/rooms/:id/guests", s.PostRoomGuest)
+	g.OPTIONS("/rooms/:id/guests/:guest", s.Ok)
+	g.DELETE("/rooms/:id/guests/:guest", s.NoApiKey, s.DeleteRoomGuest)
+	g.OPTIONS("/orgs", s.Ok)
+	g.GET("/orgs", s.GetOrgs)
+	g.POST("/orgs", s.PostOrg)
+	g.OPTIONS("/orgs/:id", s.Ok)
+	g.DELETE("/orgs/:id", s.NoApiKey, s.DeleteOrg)
+	g.OPTIONS("/orgs/:id/members", s.Ok)
+	g.GET("/orgs/:id/members", s.GetOrgMembers)
+	g.POST("/orgs/:id/members", s.PostOrgMember)
+	g.OPTIONS("/orgs/:id/members/:account", s.Ok)
+	g.DELETE("/orgs/:id/members/:account", s.NoApiKey, s.DeleteOrgMember)
+	g.OPTIONS("/orgs/:id/rooms", s.Ok)
+	g.POST("/orgs/:id/rooms", s.PostOrgRoom)
+	g.OPTIONS("/orgs/:id/rooms/:room", s.Ok)
+	g.DELETE("/orgs/:id/rooms/:room", s.NoApiKey, s.DeleteOrgRoom)
+	g.OPTIONS("/groups", s.Ok)
+	g.GET("/groups", s.GetGroups)
+	g.POST("/groups", s.PostGroup)
+	g.OPTIONS("/groups/:id", s.Ok)
+	g.PUT("/groups/:id", s.PutGroup)
+	g.DELETE("/groups/:id", s.NoApiKey, s.DeleteGroup)
+	g.OPTIONS("/views/:room", s.Ok)
+	g.PUT("/views/:room", s.PutView)
+	g.OPTIONS("/transfers", s.Ok)
+	g.GET("/transfers", s.GetTransfers)
+	g.POST("/transfers", s.NoApiKey, s.StepUp, s.PostTransfer)
+	g.OPTIONS("/transfers/:id", s.Ok)
+	g.DELETE("/transfers/:id", s.NoApiKey, s.DeleteTransfer)
+	g.OPTIONS("/transfers/:id/accept", s.Ok)
+	g.POST("/transfers/:id/accept", s.NoApiKey, s.PostAcceptTransfer)
+	g.OPTIONS("/deleted-rooms", s.Ok)
+	g.GET("/deleted-rooms", s.GetDeletedRooms)
+	g.OPTIONS("/deleted-rooms/:id/restore", s.Ok)
+	g.POST("/deleted-rooms/:id/restore", s.PostRestoreRoom)
+	g.OPTIONS("/export", s.Ok)
+	g.POST("/export", s.PostExport)
+	g.OPTIONS("/export/:id", s.Ok)
+	g.GET("/export/:id", s.GetExport)
+	g.OPTIONS("/export/:id/download", s.Ok)
+	g.GET("/export/:id/download", s.GetExportDownload)
+}
+
+func (s *Server) initAccountService() error {
+	if s.AccountService == nil {
+		cfg := gorm.EnvConfig()
+		if s.DB == nil && cfg.Store == gorm.StoreMemory {
+			s.AccountService = account.NewMemAccountService()
+		} else {
+			if s.DB == nil {
+				db, err := gorm.Open(cfg)
+				if err != nil {
+					return err
+				}
+				s.DB = db
+			}
+			s.AccountService = account.NewAccountService(s.DB)
+		}
+	}
+	if current := account.Service(); current != nil && current != s.AccountService {
+		return ErrServiceInUse
+	}
+	account.SetService(s.AccountService)
+	return nil
+}
+
+// purgeExpired removes revoked tokens, deletions out of grace, old exports,
+// expired guest links and retired keys
+func (s *Server) purgeExpired() {
+	for now := range time.Tick(time.Hour) {
+		s.Exports.Purge(now)
+		s.KeyManager.Purge()
+		if err := account.PurgeRevokedTokens(); err != nil {
+			glog.Errorln(err)
+		}
+		if err := account.PurgeDeleted(); err != nil {
+			glog.Errorln(err)
+		}
+		if err := account.PurgeGuestLinks(); err != nil {
+			glog.Errorln(err)
+		}
+		if err := account.PurgeSessions(); err != nil {
+			glog.Errorln(err)
+		}
+	}
+}
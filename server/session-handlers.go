@@ -0,0 +1,158 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+
+	"github.com/empirefox/ic-server-conductor/account"
+	"github.com/empirefox/ic-server-conductor/conn"
+)
+
+// requestToken reads "Authorization: Bearer [token]"
+func requestToken(c *gin.Context) string {
+	return strings.TrimPrefix(c.Request.Header.Get("Authorization"), "Bearer ")
+}
+
+// issuedToken reads the token from the Satellizer compatible {"token":""}
+func issuedToken(tokenObj interface{}) string {
+	b, err := json.Marshal(tokenObj)
+	if err != nil {
+		return ""
+	}
+	return replyToken(b)
+}
+
+func replyToken(b []byte) string {
+	var t struct {
+		Token string `json:"token"`
+	}
+	json.Unmarshal(b, &t)
+	return t.Token
+}
+
+// recordSession labels the new token by ?device= and user-agent,
+// steppedUp is false for tokens of provider logins.
+func (s *Server) recordSession(c *gin.Context, o *account.Oauth, token string, steppedUp bool) error {
+	if token == "" {
+		return ErrNoIssuedToken
+	}
+	exp, _ := conn.TokenExp([]byte(token))
+	device := c.Request.URL.Query().Get("device")
+	return o.RecordSession(token, device, c.Request.UserAgent(), exp, steppedUp)
+}
+
+// issuedWriter keeps the reply of provider logins to find the issued token
+type issuedWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *issuedWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *issuedWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.Write([]byte(s))
+}
+
+// RecordIssued records the token replied by provider logins as a session,
+// so it is listed and revocable before the first use. It stays pre-auth.
+func (s *Server) RecordIssued(c *gin.Context) {
+	w := &issuedWriter{ResponseWriter: c.Writer}
+	c.Writer = w
+	c.Next()
+	if w.Status() != http.StatusOK {
+		return
+	}
+	token := replyToken(w.body.Bytes())
+	if token == "" {
+		return
+	}
+	o := &account.Oauth{}
+	if err := s.verifySession(o, []byte(token)); err != nil {
+		glog.Errorln(err)
+		return
+	}
+	if err := s.recordSession(c, o, token, false); err != nil {
+		glog.Errorln(err)
+	}
+}
+
+func (s *Server) seenSession(o *account.Oauth, token, ua string) {
+	exp, _ := conn.TokenExp([]byte(token))
+	if err := o.SeenSession(token, ua, exp); err != nil {
+		glog.Errorln(err)
+	}
+}
+
+// CheckSession rejects revoked tokens, and records the token when first seen
+func (s *Server) CheckSession(c *gin.Context) {
+	token := requestToken(c)
+	if account.IsTokenRevoked(account.TokenJti(token)) {
+		conn.AbortWithCode(c, http.StatusUnauthorized, conn.ErrCodeBadToken, ErrTokenRevoked.Error())
+		return
+	}
+	s.seenSession(c.Keys[s.UserKey].(*account.Oauth), token, c.Request.UserAgent())
+}
+
+// NoPreAuth rejects pre-auth tokens, see account.Oauth.PreAuth.
+// PostNewToken with the totp code replaces them by full tokens.
+func (s *Server) NoPreAuth(c *gin.Context) {
+	o := c.Keys[s.UserKey].(*account.Oauth)
+	pre, err := o.PreAuth(requestToken(c))
+	if err != nil {
+		conn.AbortWithCode(c, http.StatusInternalServerError, conn.ErrCodeDbError, err.Error())
+		return
+	}
+	if pre {
+		conn.AbortWithCode(c, http.StatusForbidden, conn.ErrCodeTotpRequired, ErrPreAuth.Error())
+	}
+}
+
+type sessionReply struct {
+	account.Session
+	Current bool
+}
+
+// GetSessions lists the tokens of the account, the token of this request is Current
+func (s *Server) GetSessions(c *gin.Context) {
+	o := c.Keys[s.UserKey].(*account.Oauth)
+	var ss []account.Session
+	if err := o.Account.Sessions(&ss); err != nil {
+		conn.AbortWithCode(c, http.StatusInternalServerError, conn.ErrCodeDbError, err.Error())
+		return
+	}
+	jti := account.TokenJti(requestToken(c))
+	replies := make([]sessionReply, 0, len(ss))
+	for _, session := range ss {
+		replies = append(replies, sessionReply{Session: session, Current: session.Jti == jti})
+	}
+	c.JSON(http.StatusOK, replies)
+}
+
+// DeleteSession revokes the token, control and signaling sockets
+// authed by the token are dropped.
+func (s *Server) DeleteSession(c *gin.Context) {
+	id, ok := paramUint(c, "id")
+	if !ok {
+		return
+	}
+	o := c.Keys[s.UserKey].(*account.Oauth)
+	session, err := o.Account.RevokeSession(id)
+	switch err {
+	case nil:
+		s.Hub.OnKick(&conn.Kick{AccountId: o.AccountId, Jti: session.Jti, Reason: "SessionRevoked"})
+		c.JSON(http.StatusOK, gin.H{"id": id})
+	case account.ErrRecordNotFound:
+		conn.AbortWithCode(c, http.StatusNotFound, conn.ErrCodeNotFound, "session not found")
+	default:
+		conn.AbortWithCode(c, http.StatusInternalServerError, conn.ErrCodeDbError, err.Error())
+	}
+}
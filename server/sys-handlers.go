@@ -1,25 +1,30 @@
 package server
 
 import (
+	"io/ioutil"
 	"net/http"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
 	"github.com/empirefox/ic-server-conductor/account"
+	"github.com/empirefox/ic-server-conductor/conn"
+	"github.com/empirefox/ic-server-conductor/conn/one"
+	"github.com/empirefox/ic-server-conductor/keys"
 	"github.com/empirefox/tagsjson/tagjson"
 )
 
 func (s *Server) PostSaveOauth(c *gin.Context) {
 	var op account.OauthProvider
 	if errs, ok := tagjson.NewDecoder(account.PrdSave).DecodeReaderV(c.Request.Body, &op); !ok {
-		c.JSON(http.StatusBadRequest, errs)
+		conn.AbortWithFields(c, errs)
 		return
 	}
 
 	if err := op.Save(); err != nil {
-		c.AbortWithStatus(http.StatusInternalServerError)
+		conn.AbortWithCode(c, http.StatusInternalServerError, conn.ErrCodeDbError, err.Error())
 		return
 	}
 	c.AbortWithStatus(http.StatusOK)
@@ -28,11 +33,11 @@ func (s *Server) PostSaveOauth(c *gin.Context) {
 func (s *Server) PostClearTables(c *gin.Context) {
 	allow, _ := strconv.ParseBool(os.Getenv("ALLOW_CLEAR_TABLES"))
 	if !allow {
-		c.AbortWithStatus(http.StatusForbidden)
+		conn.AbortWithCode(c, http.StatusForbidden, conn.ErrCodeNotPermitted, "ALLOW_CLEAR_TABLES not set")
 		return
 	}
 	if err := account.ClearTables(); err != nil {
-		c.AbortWithError(http.StatusInternalServerError, err)
+		conn.AbortWithCode(c, http.StatusInternalServerError, conn.ErrCodeDbError, err.Error())
 		return
 	}
 	c.AbortWithStatus(http.StatusOK)
@@ -41,12 +46,89 @@ func (s *Server) PostClearTables(c *gin.Context) {
 func (s *Server) PostCreateTables(c *gin.Context) {
 	allow, _ := strconv.ParseBool(os.Getenv("ALLOW_CLEAR_TABLES"))
 	if !allow {
-		c.AbortWithStatus(http.StatusForbidden)
+		conn.AbortWithCode(c, http.StatusForbidden, conn.ErrCodeNotPermitted, "ALLOW_CLEAR_TABLES not set")
 		return
 	}
 	if err := account.CreateTables(); err != nil {
-		c.AbortWithError(http.StatusInternalServerError, err)
+		conn.AbortWithCode(c, http.StatusInternalServerError, conn.ErrCodeDbError, err.Error())
 		return
 	}
 	c.AbortWithStatus(http.StatusOK)
 }
+
+// PostMigrate applies pending migrations, with ?dry=true only lists them.
+func (s *Server) PostMigrate(c *gin.Context) {
+	dry, _ := strconv.ParseBool(c.Request.URL.Query().Get("dry"))
+	ms, err := account.Migrate(dry)
+	if err != nil {
+		conn.AbortWithCode(c, http.StatusInternalServerError, conn.ErrCodeDbError, err.Error())
+		return
+	}
+	v, err := account.CurrentVersion()
+	if err != nil {
+		conn.AbortWithCode(c, http.StatusInternalServerError, conn.ErrCodeDbError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"version": v, "latest": account.LatestVersion(), "dry": dry, "migrations": ms})
+}
+
+func (s *Server) GetSchemaVersion(c *gin.Context) {
+	v, err := account.CurrentVersion()
+	if err != nil {
+		conn.AbortWithCode(c, http.StatusInternalServerError, conn.ErrCodeDbError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"version": v, "latest": account.LatestVersion()})
+}
+
+// rotateOverlaps are the default overlaps, old keys verify tokens
+// signed before rotation until they expired.
+var rotateOverlaps = map[string]time.Duration{
+	keys.UseRoom:    one.RoomTokenTTL,
+	keys.UseGuest:   GuestTokenTTL,
+	keys.UseProxy:   time.Hour,
+	keys.UseSys:     time.Hour,
+	keys.UseSession: account.SessionMaxAge,
+}
+
+// PostSysRotateKey makes the PEM private key in body the new signer of use,
+// ?kid= is required and must be new, ?overlap= overrides the default overlap
+// of old keys.
+// Keys are kept in memory, add the PEM to config to keep it after restart.
+func (s *Server) PostSysRotateKey(c *gin.Context) {
+	use := c.Param("use")
+	overlap, ok := rotateOverlaps[use]
+	if !ok {
+		conn.AbortWithCode(c, http.StatusBadRequest, conn.ErrCodeBadRequest, "unknown key use")
+		return
+	}
+	q := c.Request.URL.Query()
+	kid := q.Get("kid")
+	if kid == "" {
+		conn.AbortWithCode(c, http.StatusBadRequest, conn.ErrCodeBadRequest, "kid required")
+		return
+	}
+	if o := q.Get("overlap"); o != "" {
+		d, err := time.ParseDuration(o)
+		if err != nil {
+			conn.AbortWithCode(c, http.StatusBadRequest, conn.ErrCodeBadRequest, err.Error())
+			return
+		}
+		overlap = d
+	}
+	data, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		conn.AbortWithCode(c, http.StatusBadRequest, conn.ErrCodeBadRequest, err.Error())
+		return
+	}
+	key, err := keys.ParsePEM(kid, use, data)
+	if err != nil {
+		conn.AbortWithCode(c, http.StatusBadRequest, conn.ErrCodeBadRequest, err.Error())
+		return
+	}
+	if err := s.KeyManager.Rotate(key, overlap); err != nil {
+		conn.AbortWithCode(c, http.StatusConflict, conn.ErrCodeBadRequest, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"kid": key.Kid, "use": use, "alg": key.Alg, "overlap": overlap.String()})
+}
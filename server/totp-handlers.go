@@ -0,0 +1,100 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/empirefox/ic-server-conductor/account"
+	"github.com/empirefox/ic-server-conductor/conn"
+)
+
+// TotpHeader carries the totp or recovery code of step-up requests
+const TotpHeader = "X-Totp-Code"
+
+// StepUp guards destructive rest api when the account enabled totp
+func (s *Server) StepUp(c *gin.Context) {
+	o := c.Keys[s.UserKey].(*account.Oauth)
+	if status, code, err := conn.StepUp(&o.Account, c.Request.Header.Get(TotpHeader)); err != nil {
+		conn.AbortWithCode(c, status, code, err.Error())
+	}
+}
+
+func (s *Server) totpIssuer() string {
+	if s.TotpIssuer == "" {
+		return "ic-server"
+	}
+	return s.TotpIssuer
+}
+
+func (s *Server) GetTotp(c *gin.Context) {
+	o := c.Keys[s.UserKey].(*account.Oauth)
+	c.JSON(http.StatusOK, gin.H{"enabled": o.Account.TotpEnabled()})
+}
+
+// PostTotp starts enrollment, totp is enabled after confirmed
+func (s *Server) PostTotp(c *gin.Context) {
+	o := c.Keys[s.UserKey].(*account.Oauth)
+	t, err := o.Account.EnrollTotp()
+	switch err {
+	case nil:
+		c.JSON(http.StatusOK, gin.H{"secret": t.Secret, "url": t.Url(s.totpIssuer(), o.Account.Name)})
+	case account.ErrTotpEnrolled:
+		conn.AbortWithCode(c, http.StatusConflict, conn.ErrCodeBadRequest, err.Error())
+	default:
+		conn.AbortWithCode(c, http.StatusInternalServerError, conn.ErrCodeDbError, err.Error())
+	}
+}
+
+type totpCodeData struct {
+	Code string `json:"code"`
+}
+
+// PostConfirmTotp enables totp, recovery codes are only in this reply.
+func (s *Server) PostConfirmTotp(c *gin.Context) {
+	var data totpCodeData
+	if err := c.BindJSON(&data); err != nil {
+		conn.AbortWithCode(c, http.StatusBadRequest, conn.ErrCodeBadRequest, "code required")
+		return
+	}
+	o := c.Keys[s.UserKey].(*account.Oauth)
+	codes, err := o.Account.ConfirmTotp(data.Code)
+	switch err {
+	case nil:
+		c.JSON(http.StatusOK, gin.H{"recovery": codes})
+	case account.ErrBadTotp:
+		conn.AbortWithCode(c, http.StatusForbidden, conn.ErrCodeBadTotp, err.Error())
+	case account.ErrTotpEnrolled, account.ErrTotpChanged:
+		conn.AbortWithCode(c, http.StatusConflict, conn.ErrCodeBadRequest, err.Error())
+	case account.ErrRecordNotFound:
+		conn.AbortWithCode(c, http.StatusNotFound, conn.ErrCodeNotFound, "totp not enrolled")
+	default:
+		conn.AbortWithCode(c, http.StatusInternalServerError, conn.ErrCodeDbError, err.Error())
+	}
+}
+
+// PostRecoveryCodes replaces all recovery codes, need step-up
+func (s *Server) PostRecoveryCodes(c *gin.Context) {
+	o := c.Keys[s.UserKey].(*account.Oauth)
+	codes, err := o.Account.NewRecoveryCodes()
+	switch err {
+	case nil:
+		c.JSON(http.StatusOK, gin.H{"recovery": codes})
+	case account.ErrTotpNotEnrolled:
+		conn.AbortWithCode(c, http.StatusNotFound, conn.ErrCodeNotFound, err.Error())
+	case account.ErrTotpChanged:
+		conn.AbortWithCode(c, http.StatusConflict, conn.ErrCodeBadRequest, err.Error())
+	default:
+		conn.AbortWithCode(c, http.StatusInternalServerError, conn.ErrCodeDbError, err.Error())
+	}
+}
+
+// DeleteTotp disables totp, need step-up
+func (s *Server) DeleteTotp(c *gin.Context) {
+	o := c.Keys[s.UserKey].(*account.Oauth)
+	if err := o.Account.DisableTotp(); err != nil {
+		conn.AbortWithCode(c, http.StatusInternalServerError, conn.ErrCodeDbError, err.Error())
+		return
+	}
+	c.AbortWithStatus(http.StatusOK)
+}
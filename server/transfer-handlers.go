@@ -0,0 +1,79 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/empirefox/ic-server-conductor/account"
+	"github.com/empirefox/ic-server-conductor/conn"
+)
+
+type transferData struct {
+	Room uint `json:"room"`
+	To   uint `json:"to"`
+}
+
+// GetTransfers returns pending transfers from or to the user
+func (s *Server) GetTransfers(c *gin.Context) {
+	o := c.Keys[s.UserKey].(*account.Oauth)
+	var ts []account.OneTransfer
+	if err := o.Account.Transfers(&ts); err != nil {
+		conn.AbortWithCode(c, http.StatusInternalServerError, conn.ErrCodeDbError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, ts)
+}
+
+// PostTransfer offers a personal room to another account
+func (s *Server) PostTransfer(c *gin.Context) {
+	var data transferData
+	if err := c.BindJSON(&data); err != nil {
+		conn.AbortWithCode(c, http.StatusBadRequest, conn.ErrCodeBadRequest, "room and to required")
+		return
+	}
+	o := c.Keys[s.UserKey].(*account.Oauth)
+	one := &account.One{}
+	if err := one.FindIfOwner(data.Room, o.AccountId); err != nil {
+		conn.AbortWithCode(c, http.StatusForbidden, conn.ErrCodeNotOwner, "not the owner of the room")
+		return
+	}
+	t, err := o.Account.StartTransfer(one, data.To)
+	switch err {
+	case nil:
+		c.JSON(http.StatusOK, t)
+	case account.ErrTransferOwner:
+		conn.AbortWithCode(c, http.StatusForbidden, conn.ErrCodeNotOwner, err.Error())
+	case account.ErrTransferSelf:
+		conn.AbortWithCode(c, http.StatusBadRequest, conn.ErrCodeBadRequest, err.Error())
+	default:
+		conn.AbortWithCode(c, http.StatusNotFound, conn.ErrCodeNotFound, "target account not found")
+	}
+}
+
+func (s *Server) PostAcceptTransfer(c *gin.Context) {
+	id, ok := paramUint(c, "id")
+	if !ok {
+		return
+	}
+	o := c.Keys[s.UserKey].(*account.Oauth)
+	t, status, code, err := conn.AcceptTransfer(s.Hub, &o.Account, id)
+	if err != nil {
+		conn.AbortWithCode(c, status, code, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, t)
+}
+
+func (s *Server) DeleteTransfer(c *gin.Context) {
+	id, ok := paramUint(c, "id")
+	if !ok {
+		return
+	}
+	o := c.Keys[s.UserKey].(*account.Oauth)
+	if err := o.Account.CancelTransfer(id); err != nil {
+		conn.AbortWithCode(c, http.StatusNotFound, conn.ErrCodeNotFound, "transfer not found")
+		return
+	}
+	c.AbortWithStatus(http.StatusOK)
+}